@@ -0,0 +1,170 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gnur/demeter/lib"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var authorCmd = &cobra.Command{
+	Use:   "author",
+	Short: "all author related commands",
+}
+
+var aliasCmd = &cobra.Command{
+	Use:     "alias",
+	Aliases: []string{"aliases"},
+	Short:   "manage author aliases and pen names",
+	Long: `Aliases link alternative author names, pen names and spellings
+to a single canonical author. Every author is resolved to its canonical
+name before a book is hashed, the stored books are rehashed whenever an
+alias is added or removed.`,
+}
+
+var aliasAddCmd = &cobra.Command{
+	Use:   "add alias canonical",
+	Args:  cobra.ExactArgs(2),
+	Short: "add an alias for an author",
+	Run: func(cmd *cobra.Command, args []string) {
+		a, err := lib.AddAlias(store, args[0], args[1])
+		if err != nil {
+			log.WithFields(log.Fields{
+				"alias": args[0],
+				"err":   err,
+			}).Error("could not save alias")
+			return
+		}
+		log.WithFields(log.Fields{
+			"alias":     a.Name,
+			"canonical": a.Canonical,
+		}).Info("alias has been added to the database")
+	},
+}
+
+var aliasRmCmd = &cobra.Command{
+	Use:     "rm alias [alias]..",
+	Aliases: []string{"del", "delete", "remove"},
+	Args:    cobra.MinimumNArgs(1),
+	Short:   "remove one or more aliases",
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, alias := range args {
+			err := lib.RemoveAlias(store, alias)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"alias": alias,
+					"err":   err,
+				}).Error("could not remove alias")
+				continue
+			}
+			log.WithField("alias", alias).Info("alias was removed")
+		}
+	},
+}
+
+var aliasListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "list all aliases",
+	Aliases: []string{"ls"},
+	Run: func(cmd *cobra.Command, args []string) {
+		aliases, err := lib.ListAliases(store)
+		if err != nil {
+			log.WithField("err", err).Error("could not list aliases")
+			return
+		}
+		if len(aliases) == 0 {
+			log.Info("no aliases were found")
+			return
+		}
+		for i, a := range aliases {
+			if i%25 == 0 {
+				fmt.Printf(`%30s|%30s`, "alias", "canonical")
+				fmt.Println()
+			}
+			fmt.Printf(`%30s|%30s`, a.Name, a.Canonical)
+			fmt.Println()
+		}
+	},
+}
+
+var aliasImportCmd = &cobra.Command{
+	Use:   "import file",
+	Args:  cobra.ExactArgs(1),
+	Short: "import an alias list",
+	Long: `Import an alias list, every line of the file holds a single
+alias in the form:
+
+  Robert Galbraith = J. K. Rowling
+
+Empty lines and lines starting with # are ignored.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.WithField("err", err).Error("could not open alias list")
+			return
+		}
+		defer f.Close()
+		aliases, err := lib.ParseAliases(f)
+		if err != nil {
+			log.WithField("err", err).Error("could not parse alias list")
+			return
+		}
+		added, skipped, err := lib.ImportAliases(store, aliases)
+		if err != nil {
+			log.WithField("err", err).Error("could not import alias list, nothing was imported")
+			return
+		}
+		for _, a := range skipped {
+			log.WithFields(log.Fields{
+				"alias": a.Name,
+				"err":   lib.ErrSelfAlias,
+			}).Warning("skipping alias")
+		}
+		log.WithFields(log.Fields{
+			"added":   added,
+			"scanned": len(aliases),
+		}).Info("alias list was imported")
+	},
+}
+
+var aliasExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "print all aliases in the import format",
+	Run: func(cmd *cobra.Command, args []string) {
+		aliases, err := lib.ListAliases(store)
+		if err != nil {
+			log.WithField("err", err).Error("could not list aliases")
+			return
+		}
+		err = lib.WriteAliases(os.Stdout, aliases)
+		if err != nil {
+			log.WithField("err", err).Error("could not export aliases")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(authorCmd)
+	authorCmd.AddCommand(aliasCmd)
+	aliasCmd.AddCommand(aliasAddCmd)
+	aliasCmd.AddCommand(aliasRmCmd)
+	aliasCmd.AddCommand(aliasListCmd)
+	aliasCmd.AddCommand(aliasImportCmd)
+	aliasCmd.AddCommand(aliasExportCmd)
+}
@@ -0,0 +1,81 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/gnur/demeter/lib"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var daemonConcurrency int
+var daemonTick time.Duration
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Args:  cobra.NoArgs,
+	Short: "keep scraping hosts whenever they are due",
+	Long: `Scrape every active host whenever it is due according to its
+schedule, see host schedule, with at most --concurrency hosts at the
+same time. Scheduled backups and trash expiry run every hour.
+
+The daemon keeps the database open, other demeter commands report that
+another demeter process is running until it is stopped.
+
+On SIGINT or SIGTERM the running scrapes stop downloading and save their
+progress like scrape run, a second signal stops demeter right away.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if daemonConcurrency < 1 {
+			log.Error("concurrency should be at least 1")
+			return
+		}
+		a, err := newApp()
+		if err != nil {
+			log.WithField("err", err).Error("Could not start scraping")
+			return
+		}
+		defer a.Pool.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		defer cancelOnSignal(cancel, "Shutting down")()
+
+		d := lib.Daemon{
+			App:         a,
+			Policy:      cfg.Policy(),
+			Concurrency: daemonConcurrency,
+			Tick:        daemonTick,
+			Backups:     cfg.Backups,
+			Trash:       cfg.Trash,
+		}
+		log.WithFields(log.Fields{
+			"schedule":    cfg.Schedule.String(),
+			"concurrency": daemonConcurrency,
+		}).Info("Daemon started")
+		d.Run(ctx)
+		log.Info("Daemon stopped")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	addScrapeFlags(daemonCmd)
+
+	daemonCmd.Flags().IntVarP(&daemonConcurrency, "concurrency", "c", 2, "number of hosts that are scraped at the same time")
+	daemonCmd.Flags().DurationVar(&daemonTick, "tick", time.Minute, "how often to look for hosts that are due")
+}
@@ -0,0 +1,311 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var dbFormat string
+var dbBuckets []string
+var dbMergePolicy string
+
+const (
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+)
+
+var dbCmd = &cobra.Command{
+	Use:     "db",
+	Aliases: []string{"database"},
+	Short:   "all database related commands",
+}
+
+var dbExportCmd = &cobra.Command{
+	Use:   "export file|dir",
+	Args:  cobra.ExactArgs(1),
+	Short: "export the database as json lines or csv",
+	Long: `Export the database as json lines or csv. With json lines all
+records are written to a single file, use - to write to stdout. With
+csv a file is written for every bucket in the given directory.`,
+	PreRunE: validateDBFlags,
+	Run: func(cmd *cobra.Command, args []string) {
+		var counts map[string]int
+		var err error
+		if dbFormat == formatCSV {
+			counts, err = lib.ExportCSV(store, args[0], dbBuckets)
+		} else {
+			var w io.Writer = os.Stdout
+			if args[0] != "-" {
+				f, ferr := os.Create(args[0])
+				if ferr != nil {
+					log.WithField("err", ferr).Error("could not create export file")
+					return
+				}
+				defer f.Close()
+				w = f
+			}
+			counts, err = lib.ExportJSONL(store, w, dbBuckets)
+		}
+		if err != nil {
+			log.WithField("err", err).Error("export failed")
+			return
+		}
+		for _, bucket := range dbBuckets {
+			log.WithFields(log.Fields{
+				"bucket":  bucket,
+				"records": counts[bucket],
+			}).Info("bucket exported")
+		}
+	},
+}
+
+var dbImportCmd = &cobra.Command{
+	Use:   "import file|dir",
+	Args:  cobra.ExactArgs(1),
+	Short: "import a database export",
+	Long: `Import a json lines or csv export into the database. Hosts are
+matched on their url and books on their hash, --merge decides what
+happens to records that already exist:
+
+  skip         keep the existing record
+  overwrite    replace the existing record
+  newest-wins  keep the record that was scraped, added or seen last`,
+	PreRunE: validateDBFlags,
+	Run: func(cmd *cobra.Command, args []string) {
+		var counts map[string]lib.MergeCount
+		var err error
+		if dbFormat == formatCSV {
+			counts, err = lib.ImportCSV(store, args[0], dbBuckets, dbMergePolicy)
+		} else {
+			var r io.Reader = os.Stdin
+			if args[0] != "-" {
+				f, ferr := os.Open(args[0])
+				if ferr != nil {
+					log.WithField("err", ferr).Error("could not open import file")
+					return
+				}
+				defer f.Close()
+				r = f
+			}
+			counts, err = lib.ImportJSONL(store, r, dbBuckets, dbMergePolicy)
+		}
+		if err != nil {
+			log.WithField("err", err).Error("import failed, nothing was imported")
+			return
+		}
+		for _, bucket := range dbBuckets {
+			c := counts[bucket]
+			log.WithFields(log.Fields{
+				"bucket":  bucket,
+				"added":   c.Added,
+				"updated": c.Updated,
+				"skipped": c.Skipped,
+			}).Info("bucket imported")
+		}
+	},
+}
+
+func validateDBFlags(cmd *cobra.Command, args []string) error {
+	if dbFormat != formatJSONL && dbFormat != formatCSV {
+		return fmt.Errorf("unknown format %q, use jsonl or csv", dbFormat)
+	}
+	for _, b := range dbBuckets {
+		if !lib.ValidBucket(b) {
+			return fmt.Errorf("unknown bucket %q, use one of %v", b, lib.AllBuckets)
+		}
+	}
+	if dbMergePolicy != lib.MergeSkip && dbMergePolicy != lib.MergeOverwrite && dbMergePolicy != lib.MergeNewest {
+		return fmt.Errorf("unknown merge policy %q", dbMergePolicy)
+	}
+	return nil
+}
+
+var migrateDryRun bool
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Args:  cobra.NoArgs,
+	Short: "upgrade the database to the current schema",
+	Long: `Apply all pending schema migrations. This also happens on the
+start of every other command, a backup of the database is written
+next to it before anything is migrated. Use --dry-run to only list the
+pending migrations.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		version, err := store.SchemaVersion()
+		if err != nil {
+			log.WithField("err", err).Error("could not read schema version")
+			return
+		}
+		pending, err := store.PendingMigrations()
+		if err != nil {
+			log.WithField("err", err).Error("could not list migrations")
+			return
+		}
+		log.WithFields(log.Fields{
+			"version": version,
+			"pending": len(pending),
+		}).Info("database schema")
+		if migrateDryRun {
+			for _, m := range pending {
+				fmt.Printf("%3d  %s\n", m.Version, m.Name)
+			}
+			return
+		}
+		backup, applied, err := store.Migrate()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"backup": backup,
+				"err":    err,
+			}).Error("migration failed")
+			return
+		}
+		for _, m := range applied {
+			log.WithFields(log.Fields{
+				"version":   m.Version,
+				"migration": m.Name,
+				"backup":    backup,
+			}).Info("migration applied")
+		}
+	},
+}
+
+// boltStore returns the store as a bbolt database, maintenance is not possible on a database in memory
+func boltStore() (*db.Bolt, bool) {
+	b, ok := store.(*db.Bolt)
+	if !ok {
+		log.Error("this command needs a database file, not " + memoryDB)
+	}
+	return b, ok
+}
+
+var dbBackupCmd = &cobra.Command{
+	Use:   "backup file",
+	Args:  cobra.ExactArgs(1),
+	Short: "write a consistent copy of the database",
+	Long: `Write a consistent copy of the database to a file. The copy is
+made in a read transaction, so it is safe to make a backup while
+another demeter process is scraping.
+
+Scheduled backups are configured in the backups section of the config.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		b, ok := boltStore()
+		if !ok {
+			return
+		}
+		err := b.Backup(args[0])
+		if err != nil {
+			log.WithField("err", err).Error("backup failed")
+			return
+		}
+		log.WithField("file", args[0]).Info("database backed up")
+	},
+}
+
+var dbCompactCmd = &cobra.Command{
+	Use:   "compact",
+	Args:  cobra.NoArgs,
+	Short: "rewrite the database into a smaller file",
+	Long: `The database file never shrinks, space of removed records is only
+reused for new records. Compact copies all records into a fresh file
+and replaces the database with it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		b, ok := boltStore()
+		if !ok {
+			return
+		}
+		before, after, err := b.Compact()
+		if err != nil {
+			log.WithField("err", err).Error("compacting failed")
+			return
+		}
+		log.WithFields(log.Fields{
+			"before": before,
+			"after":  after,
+		}).Info("database compacted")
+	},
+}
+
+var dbVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Args:  cobra.NoArgs,
+	Short: "check the database for corruption and stale records",
+	Long: `Check the integrity of all buckets, and look for checked ids of
+hosts that have been removed, books that share a hash and downloaded
+books whose file is missing. Relative book paths are resolved from the
+current directory, so run verify from where you run scrapes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		problems := 0
+		if b, ok := store.(*db.Bolt); ok {
+			for _, err := range b.Check() {
+				log.WithField("err", err).Error("database is corrupt")
+				problems++
+			}
+		}
+		r, err := lib.Verify(store)
+		if err != nil {
+			log.WithField("err", err).Error("could not verify database")
+			return
+		}
+		orphans := make(map[int]int)
+		for _, id := range r.OrphanedIDs {
+			orphans[id.HostID]++
+		}
+		for hostID, n := range orphans {
+			log.WithFields(log.Fields{
+				"host": hostID,
+				"ids":  n,
+			}).Warn("checked ids of removed host")
+		}
+		for hash, ids := range r.DuplicateHashes {
+			log.WithFields(log.Fields{
+				"hash":  hash,
+				"books": ids,
+			}).Warn("books share a hash")
+		}
+		for _, b := range r.MissingFiles {
+			log.WithFields(log.Fields{
+				"book": b.ID,
+				"path": b.Path,
+			}).Warn("book file is missing")
+		}
+		problems += r.Problems()
+		log.WithField("problems", problems).Info("database verified")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbExportCmd)
+	dbCmd.AddCommand(dbImportCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbBackupCmd)
+	dbCmd.AddCommand(dbCompactCmd)
+	dbCmd.AddCommand(dbVerifyCmd)
+
+	for _, c := range []*cobra.Command{dbExportCmd, dbImportCmd} {
+		c.Flags().StringVarP(&dbFormat, "format", "f", formatJSONL, "format of the export: jsonl or csv")
+		c.Flags().StringSliceVarP(&dbBuckets, "buckets", "b", lib.AllBuckets, "buckets to export or import")
+	}
+	dbMigrateCmd.Flags().BoolVarP(&migrateDryRun, "dry-run", "n", false, "only show the pending migrations")
+	dbImportCmd.Flags().StringVarP(&dbMergePolicy, "merge", "m", lib.MergeSkip, "merge policy for existing records: skip, overwrite or newest-wins")
+}
@@ -16,9 +16,10 @@ package cmd
 
 import (
 	"fmt"
+	"path/filepath"
+	"strconv"
 	"time"
 
-	"github.com/gnur/demeter/db"
 	"github.com/gnur/demeter/lib"
 	log "github.com/sirupsen/logrus"
 
@@ -38,8 +39,7 @@ var dlListCmd = &cobra.Command{
 	Short:   "list all downloads",
 	Aliases: []string{"ls"},
 	Run: func(cmd *cobra.Command, args []string) {
-		var books []lib.Book
-		err := db.Conn.All(&books)
+		books, err := store.Books()
 		if err != nil {
 			fmt.Printf("Error listing downloads: %e\n", err)
 		}
@@ -55,29 +55,26 @@ var dlAddCmd = &cobra.Command{
 	Args:  cobra.MinimumNArgs(1),
 	Short: "add a number of hashes to the database",
 	Run: func(cmd *cobra.Command, args []string) {
-		tx, err := db.Conn.Begin(true)
-		if err != nil {
-			log.WithField("err", err).Error("could not start transaction")
-			return
-		}
-		for _, hash := range args {
-			h := lib.Book{
-				Hash:     hash,
-				Added:    time.Now(),
-				SourceID: 0,
-			}
+		err := store.Update(func(tx lib.Store) error {
+			for _, hash := range args {
+				h := lib.Book{
+					Hash:     hash,
+					Added:    time.Now(),
+					SourceID: 0,
+				}
 
-			err := tx.Save(&h)
-			if err != nil {
-				log.WithField("err", err).Error("could not save")
-				continue
+				err := tx.SaveBook(&h)
+				if err != nil {
+					log.WithField("err", err).Error("could not save")
+					continue
+				}
+				log.WithFields(log.Fields{
+					"id":   h.ID,
+					"hash": h.Hash,
+				}).Info("book has been added to the database")
 			}
-			log.WithFields(log.Fields{
-				"id":   h.ID,
-				"hash": h.Hash,
-			}).Info("book has been added to the database")
-		}
-		err = tx.Commit()
+			return nil
+		})
 		if err != nil {
 			log.WithField("err", err).Error("failed to commit")
 			return
@@ -89,6 +86,8 @@ var dlDelRecentCmd = &cobra.Command{
 	Use:   "deleterecent 24h",
 	Args:  cobra.ExactArgs(1),
 	Short: "delete all downloads from this time period",
+	Long: `Move all downloads that were added in the given period to the
+trash, this is the same as dl rm --since 24h.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		duration, err := time.ParseDuration(args[0])
 		if err != nil {
@@ -97,21 +96,220 @@ var dlDelRecentCmd = &cobra.Command{
 		}
 		cutOffPoint := time.Now().Add(-duration)
 		log.WithField("cutoffpoint", cutOffPoint).Info("Deleting all downloads newer then this date")
-		var books []lib.Book
-		db.Conn.All(&books)
-		deleted := 0
-		scanned := 0
-		for _, b := range books {
-			scanned++
-			if b.Added.After(cutOffPoint) {
-				//db.Conn.DeleteStruct(&b)
-				deleted++
+		trashDownloads(lib.BookFilter{Since: cutOffPoint})
+	},
+}
+
+var rmSince string
+var rmUntil string
+var rmHosts []int
+var rmAuthor string
+var rmHashes []string
+var rmFiles bool
+var rmDryRun bool
+
+var dlRmCmd = &cobra.Command{
+	Use:     "rm",
+	Aliases: []string{"del", "delete", "remove"},
+	Args:    cobra.NoArgs,
+	Short:   "move downloads to the trash",
+	Long: `Move all downloads that match the filters to the trash, at least
+one filter is required. --since and --until take a duration like 24h,
+which is counted back from now, or a date like 2018-05-01. With --files
+the book files are moved to the trash directory as well.
+
+Use --dry-run to see what would be removed, and dl restore to bring
+back books that were removed by mistake.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var f lib.BookFilter
+		var err error
+		if rmSince != "" {
+			f.Since, err = parseTimeFlag(rmSince)
+			if err != nil {
+				log.WithField("err", err).Error("invalid --since")
+				return
+			}
+		}
+		if rmUntil != "" {
+			f.Until, err = parseTimeFlag(rmUntil)
+			if err != nil {
+				log.WithField("err", err).Error("invalid --until")
+				return
+			}
+		}
+		f.HostIDs = rmHosts
+		f.Author = rmAuthor
+		f.Hashes = rmHashes
+		if f.Empty() {
+			log.Error("provide at least one filter, use --until 0s to remove all downloads")
+			return
+		}
+		trashDownloads(f)
+	},
+}
+
+// parseTimeFlag reads a duration that is counted back from now, or a date
+func parseTimeFlag(v string) (time.Time, error) {
+	d, err := time.ParseDuration(v)
+	if err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// trashDir is the directory trashed book files are moved to
+func trashDir() string {
+	return filepath.Join(dataDir, "trash")
+}
+
+// trashDownloads moves the books that match f to the trash, or only lists them with --dry-run
+func trashDownloads(f lib.BookFilter) {
+	books, err := lib.FindBooks(store, f)
+	if err != nil {
+		log.WithField("err", err).Error("could not find downloads")
+		return
+	}
+	for _, b := range books {
+		fmt.Printf("%6d  %s  %s - %s  %s\n", b.ID, b.Added.Format("2006-01-02 15:04"), b.Author, b.Title, b.Hash)
+	}
+	if rmDryRun {
+		log.WithField("books", len(books)).Info("would have moved these downloads to the trash")
+		return
+	}
+	trashed, err := lib.TrashBooks(store, books, trashDir(), outputDir, rmFiles)
+	if err != nil {
+		log.WithField("err", err).Error("could not move downloads to the trash")
+		return
+	}
+	log.WithField("books", len(trashed)).Info("downloads have been moved to the trash, use dl restore to undo")
+}
+
+var restoreAll bool
+
+var dlRestoreCmd = &cobra.Command{
+	Use:   "restore [trashid]...",
+	Short: "restore downloads from the trash",
+	Long: `Restore trashed downloads by their trash id, see dl trash. Without
+ids the downloads of the last dl rm are restored, --all restores
+everything that is in the trash.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var trashed []lib.TrashedBook
+		var err error
+		switch {
+		case restoreAll:
+			trashed, err = store.Trash()
+		case len(args) == 0:
+			trashed, err = lib.LastTrashed(store)
+		default:
+			for _, arg := range args {
+				id, cerr := strconv.Atoi(arg)
+				if cerr != nil {
+					log.WithField("err", cerr).Error("please provide a numeric ID")
+					return
+				}
+				t, terr := store.TrashedBook(id)
+				if terr != nil {
+					log.WithField("id", id).Error("No trashed download with that ID was found")
+					return
+				}
+				trashed = append(trashed, t)
+			}
+		}
+		if err != nil {
+			log.WithField("err", err).Error("could not read the trash")
+			return
+		}
+		restored, err := lib.RestoreBooks(store, trashed)
+		if err != nil {
+			log.WithField("err", err).Error("could not restore downloads")
+		}
+		log.WithFields(log.Fields{
+			"restored": len(restored),
+			"skipped":  len(trashed) - len(restored),
+		}).Info("downloads have been restored")
+	},
+}
+
+var dlTrashCmd = &cobra.Command{
+	Use:   "trash",
+	Args:  cobra.NoArgs,
+	Short: "list the downloads in the trash",
+	Run: func(cmd *cobra.Command, args []string) {
+		trash, err := store.Trash()
+		if err != nil {
+			log.WithField("err", err).Error("could not read the trash")
+			return
+		}
+		for _, t := range trash {
+			file := "-"
+			if t.File != "" {
+				file = t.File
+			}
+			fmt.Printf("%6d  %s  %s - %s  %s  %s\n", t.ID, t.Deleted.Format("2006-01-02 15:04"), t.Book.Author, t.Book.Title, t.Book.Hash, file)
+		}
+		if cfg.Trash.KeepDays > 0 {
+			log.WithField("days", cfg.Trash.KeepDays).Info("trashed downloads are removed for good after this many days")
+		}
+	},
+}
+
+var dedupeRemove bool
+var dedupeDryRun bool
+
+var dlDedupeFilesCmd = &cobra.Command{
+	Use:   "dedupe-files [dir]",
+	Args:  cobra.MaximumNArgs(1),
+	Short: "replace byte-identical downloads with hardlinks",
+	Long: `Scan the output directory for files with the exact same content
+and replace every copy with a hardlink to the first file, or remove
+the copies with --remove. Books that point to these files are updated.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := outputDir
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		sets, err := lib.FindDuplicateFiles(dir)
+		if err != nil {
+			log.WithField("err", err).Error("could not scan for duplicate files")
+			return
+		}
+		replaced := 0
+		var saved int64
+		for _, set := range sets {
+			for _, dup := range set.Duplicates {
+				if !dedupeRemove && lib.IsLinked(set.Keep, dup) {
+					continue
+				}
+				l := log.WithFields(log.Fields{
+					"keep":      set.Keep,
+					"duplicate": dup,
+				})
+				if dedupeDryRun {
+					l.Info("would replace duplicate")
+					replaced++
+					saved += set.Size
+					continue
+				}
+				err := lib.ReplaceDuplicate(store, set, dup, dedupeRemove)
+				if err != nil {
+					l.WithField("err", err).Error("could not replace duplicate")
+					continue
+				}
+				l.Debug("replaced duplicate")
+				replaced++
+				saved += set.Size
 			}
 		}
 		log.WithFields(log.Fields{
-			"deleted": deleted,
-			"scanned": scanned,
-		}).Info("would have deleted these downloads")
+			"sets":     len(sets),
+			"replaced": replaced,
+			"bytes":    saved,
+			"dryrun":   dedupeDryRun,
+		}).Info("duplicate files have been processed")
 	},
 }
 
@@ -120,5 +318,25 @@ func init() {
 	dlCmd.AddCommand(dlListCmd)
 	dlCmd.AddCommand(dlDelRecentCmd)
 	dlCmd.AddCommand(dlAddCmd)
+	dlCmd.AddCommand(dlDedupeFilesCmd)
+	dlCmd.AddCommand(dlRmCmd)
+	dlCmd.AddCommand(dlRestoreCmd)
+	dlCmd.AddCommand(dlTrashCmd)
+
+	for _, c := range []*cobra.Command{dlRmCmd, dlDelRecentCmd} {
+		c.Flags().BoolVar(&rmFiles, "files", false, "move the book files to the trash as well")
+		c.Flags().StringVarP(&outputDir, "outputdir", "d", "books", "directory with downloaded books, only files in it are moved to the trash")
+		c.Flags().BoolVarP(&rmDryRun, "dry-run", "n", false, "only show what would be removed")
+	}
+	dlRmCmd.Flags().StringVar(&rmSince, "since", "", "only books added after this duration ago or date")
+	dlRmCmd.Flags().StringVar(&rmUntil, "until", "", "only books added before this duration ago or date")
+	dlRmCmd.Flags().IntSliceVar(&rmHosts, "host", nil, "only books downloaded from these host ids")
+	dlRmCmd.Flags().StringVar(&rmAuthor, "author", "", "only books whose author contains this")
+	dlRmCmd.Flags().StringSliceVar(&rmHashes, "hash", nil, "only books with these hashes")
+	dlRestoreCmd.Flags().BoolVar(&restoreAll, "all", false, "restore everything in the trash")
+
+	dlDedupeFilesCmd.Flags().StringVarP(&outputDir, "outputdir", "d", "books", "directory with downloaded books")
+	dlDedupeFilesCmd.Flags().BoolVar(&dedupeRemove, "remove", false, "remove duplicates instead of replacing them with hardlinks")
+	dlDedupeFilesCmd.Flags().BoolVarP(&dedupeDryRun, "dry-run", "n", false, "only show what would be done")
 
 }
@@ -21,13 +21,14 @@ import (
 	"strings"
 	"time"
 
-	"github.com/gnur/demeter/db"
 	"github.com/gnur/demeter/lib"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var deleteID uint32
+var keepHistory bool
+var purgeBooks bool
 
 var hostCmd = &cobra.Command{
 	Use:   "host",
@@ -39,8 +40,7 @@ var listCmd = &cobra.Command{
 	Short:   "list all hosts",
 	Aliases: []string{"ls"},
 	Run: func(cmd *cobra.Command, args []string) {
-		var hosts []lib.Host
-		db.Conn.All(&hosts)
+		hosts, _ := store.Hosts()
 
 		if len(hosts) == 0 {
 			log.Info("no hosts were found")
@@ -48,10 +48,10 @@ var listCmd = &cobra.Command{
 
 		for i, h := range hosts {
 			if i%25 == 0 {
-				fmt.Printf(`%5s|%30s|%7s|%7s|%5s|%6s|%6s|%6s`, "id", "url", "total", "dls(5)", "fails", "scrape", "dls", "active")
+				fmt.Printf(`%5s|%30s|%7s|%7s|%5s|%6s|%6s|%6s|%11s|%16s`, "id", "url", "total", "dls(5)", "fails", "scrape", "dls", "active", "health", "next scrape")
 				fmt.Println()
 			}
-			h.Print(false)
+			h.Print(store, cfg.Policy(), false)
 			fmt.Println()
 		}
 
@@ -76,7 +76,7 @@ var addCmd = &cobra.Command{
 				Active:     true,
 			}
 
-			err = db.Conn.Save(&h)
+			err = store.SaveHost(&h)
 			if err != nil {
 				log.WithField("err", err).Error("could not save")
 				return
@@ -93,21 +93,37 @@ var delCmd = &cobra.Command{
 	Use:     "rm hostid",
 	Aliases: []string{"del", "rm", "delete", "remove"},
 	Short:   "delete a host",
-	Args:    cobra.ExactArgs(1),
+	Long: `Delete a host together with its checked ids, scrape history and
+catalog snapshot. Everything is removed in a single transaction.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		var h lib.Host
 		id, err := strconv.Atoi(args[0])
 		if err != nil {
 			log.WithField("err", err).Error("please provide a numeric ID")
 			return
 		}
-		err = db.Conn.One("ID", id, &h)
+		h, err := store.Host(id)
 		if err != nil {
 			log.WithField("err", err).Error("No host with that ID was found")
 			return
 		}
-		db.Conn.DeleteStruct(&h)
-		log.WithField("host", h.URL).Info("host was removed")
+		removed, err := lib.RemoveHost(store, h.ID, keepHistory, purgeBooks)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"host": h.URL,
+				"err":  err,
+			}).Error("Could not remove host, nothing was removed")
+			return
+		}
+		log.WithFields(log.Fields{
+			"host":    h.URL,
+			"checked": removed.CheckedIDs,
+			"scrapes": removed.ScrapeResults,
+			"catalog": removed.CatalogEntries,
+			"books":   removed.Books,
+			"queued":  removed.Downloads,
+			"passed":  removed.PassedDownloads,
+		}).Info("host was removed")
 
 	},
 }
@@ -118,18 +134,17 @@ var detailCmd = &cobra.Command{
 	Short:   "Get host stats",
 	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		var h lib.Host
 		id, err := strconv.Atoi(args[0])
 		if err != nil {
 			log.WithField("err", err).Error("please provide a numeric ID")
 			return
 		}
-		err = db.Conn.One("ID", id, &h)
+		h, err := store.Host(id)
 		if err != nil {
 			log.WithField("err", err).Error("No host with that ID was found")
 			return
 		}
-		h.Print(true)
+		h.Print(store, cfg.Policy(), true)
 	},
 }
 
@@ -139,19 +154,17 @@ var disableCmd = &cobra.Command{
 	Short:   "disable a host",
 	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		var h lib.Host
 		id, err := strconv.Atoi(args[0])
 		if err != nil {
 			log.WithField("err", err).Error("please provide a numeric ID")
 			return
 		}
-		err = db.Conn.One("ID", id, &h)
+		h, err := store.Host(id)
 		if err != nil {
 			log.WithField("err", err).Error("No host with that ID was found")
 			return
 		}
-		h.Active = false
-		err = db.Conn.UpdateField(&h, "Active", false)
+		err = lib.SaveHostHealth(store, &h, h.Disable(time.Now()))
 		if err != nil {
 			log.WithFields(log.Fields{
 				"host":   h.URL,
@@ -160,10 +173,18 @@ var disableCmd = &cobra.Command{
 			}).Error("Could not store new active state")
 			return
 		}
+		passed, err := lib.PassClaims(store, h.ID, time.Now())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"host": h.URL,
+				"err":  err,
+			}).Error("Could not pass queued downloads to other hosts")
+		}
 		log.WithFields(log.Fields{
 			"host":   h.URL,
 			"id":     h.ID,
 			"active": h.Active,
+			"passed": passed,
 		}).Info("host was disabled")
 
 	},
@@ -173,21 +194,21 @@ var enableCmd = &cobra.Command{
 	Use:     "enable hostid",
 	Aliases: []string{"en", "activate", "enable"},
 	Short:   "make a host active",
-	Args:    cobra.ExactArgs(1),
+	Long: `Make a host active and healthy again, this also ends its backoff
+and revives hosts that were declared dead.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		var h lib.Host
 		id, err := strconv.Atoi(args[0])
 		if err != nil {
 			log.WithField("err", err).Error("please provide a numeric ID")
 			return
 		}
-		err = db.Conn.One("ID", id, &h)
+		h, err := store.Host(id)
 		if err != nil {
 			log.WithField("err", err).Error("No host with that ID was found")
 			return
 		}
-		h.Active = true
-		err = db.Conn.Update(&h)
+		err = lib.SaveHostHealth(store, &h, h.Reset(time.Now()))
 		if err != nil {
 			log.WithFields(log.Fields{
 				"host":   h.URL,
@@ -201,15 +222,80 @@ var enableCmd = &cobra.Command{
 	},
 }
 
+var scheduleInterval string
+var scheduleCron string
+var scheduleJitter string
+var scheduleWindow string
+var scheduleClear bool
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule hostid",
+	Short: "set when a host is scraped",
+	Long: `Set the schedule of a host, fields that are not set are taken from
+the schedule section of the config. A host is scraped --interval after
+its last scrape plus up to --jitter, or on every time that matches the
+--cron expression (minute hour day-of-month month day-of-week). With
+--window like 01:00-06:00 scrapes only start within that time of day.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			log.WithField("err", err).Error("please provide a numeric ID")
+			return
+		}
+		h, err := store.Host(id)
+		if err != nil {
+			log.WithField("err", err).Error("No host with that ID was found")
+			return
+		}
+		sched := h.Schedule
+		if scheduleClear {
+			sched = lib.Schedule{}
+		}
+		if cmd.Flags().Changed("interval") {
+			sched.Interval = scheduleInterval
+			sched.Cron = ""
+		}
+		if cmd.Flags().Changed("cron") {
+			sched.Cron = scheduleCron
+			sched.Interval = ""
+		}
+		if cmd.Flags().Changed("jitter") {
+			sched.Jitter = scheduleJitter
+		}
+		if cmd.Flags().Changed("window") {
+			sched.Window = scheduleWindow
+		}
+		err = sched.Validate()
+		if err != nil {
+			log.WithField("err", err).Error("Invalid schedule")
+			return
+		}
+		h.Schedule = sched
+		err = store.SaveHost(&h)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"host": h.URL,
+				"err":  err,
+			}).Error("Could not store schedule")
+			return
+		}
+		effective := cfg.Policy().ScheduleFor(h)
+		log.WithFields(log.Fields{
+			"host":     h.URL,
+			"schedule": effective.String(),
+			"next":     cfg.Policy().Next(h).Format(time.RFC3339),
+		}).Info("schedule was updated")
+	},
+}
+
 var enableAllCmd = &cobra.Command{
 	Use:   "enable-all",
 	Short: "make all hosts active",
 	Run: func(cmd *cobra.Command, args []string) {
-		var hosts []lib.Host
-		db.Conn.All(&hosts)
+		hosts, _ := store.Hosts()
 		for _, h := range hosts {
-			h.Active = true
-			err := db.Conn.Update(&h)
+			err := lib.SaveHostHealth(store, &h, h.Reset(time.Now()))
 			if err != nil {
 				log.WithFields(log.Fields{
 					"host":   h.URL,
@@ -233,4 +319,13 @@ func init() {
 	hostCmd.AddCommand(enableAllCmd)
 	hostCmd.AddCommand(disableCmd)
 	hostCmd.AddCommand(detailCmd)
+	hostCmd.AddCommand(scheduleCmd)
+
+	scheduleCmd.Flags().StringVar(&scheduleInterval, "interval", "", "time between two scrapes, like 6h")
+	scheduleCmd.Flags().StringVar(&scheduleCron, "cron", "", "cron expression like \"0 3 * * 1\", replaces the interval")
+	scheduleCmd.Flags().StringVar(&scheduleJitter, "jitter", "", "up to this much time is added to the interval")
+	scheduleCmd.Flags().StringVar(&scheduleWindow, "window", "", "only start scrapes within this time of day, like 01:00-06:00")
+	scheduleCmd.Flags().BoolVar(&scheduleClear, "clear", false, "use the default schedule again, other flags are applied after clearing")
+	delCmd.Flags().BoolVar(&keepHistory, "keep-history", false, "keep the scrape history of the host")
+	delCmd.Flags().BoolVar(&purgeBooks, "purge-books", false, "also remove the books that were only downloaded from this host, their files are kept")
 }
@@ -0,0 +1,99 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"runtime"
+
+	"github.com/gnur/demeter/lib"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var importDryRun bool
+var importWorkers int
+var importExtensions []string
+var importResync bool
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "import books you already own into the database",
+}
+
+var importDirCmd = &cobra.Command{
+	Use:   "dir path",
+	Args:  cobra.ExactArgs(1),
+	Short: "import all books in a directory tree",
+	Long: `Walk a directory tree and add every book to the database as a
+locally owned book, so it will never be downloaded. The metadata of
+epub files is read from the file itself, for all other formats the
+author and title are taken from the filename.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if importWorkers < 1 {
+			importWorkers = 1
+		}
+		r, err := lib.ImportDir(store, args[0], importExtensions, importWorkers, importDryRun)
+		l := log.WithFields(log.Fields{
+			"scanned":   r.Scanned,
+			"imported":  r.Imported,
+			"present":   r.Present,
+			"conflicts": r.Conflicts,
+			"failed":    r.Failed,
+			"dryrun":    importDryRun,
+		})
+		if err != nil {
+			l.WithField("err", err).Error("import failed")
+			return
+		}
+		l.Info("import done")
+	},
+}
+
+var importCalibreCmd = &cobra.Command{
+	Use:   "calibre library-dir",
+	Args:  cobra.ExactArgs(1),
+	Short: "import all books of a local calibre library",
+	Long: `Read the metadata.db of a local calibre library and add all of its
+books to the database as locally owned books. The library is opened
+read-only. Use --resync to only import the books that were added to
+the library since the previous import.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		r, err := lib.ImportCalibre(store, args[0], importResync, importDryRun)
+		l := log.WithFields(log.Fields{
+			"scanned":   r.Scanned,
+			"imported":  r.Imported,
+			"present":   r.Present,
+			"conflicts": r.Conflicts,
+			"dryrun":    importDryRun,
+		})
+		if err != nil {
+			l.WithField("err", err).Error("import failed")
+			return
+		}
+		l.Info("import done")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importDirCmd)
+	importCmd.AddCommand(importCalibreCmd)
+
+	importDirCmd.Flags().BoolVarP(&importDryRun, "dry-run", "n", false, "only report what would be imported")
+	importDirCmd.Flags().IntVarP(&importWorkers, "workers", "w", runtime.NumCPU(), "number of files to read in parallel")
+	importDirCmd.Flags().StringSliceVarP(&importExtensions, "extension", "e", lib.EbookExtensions, "extensions of the files to import")
+	importCalibreCmd.Flags().BoolVarP(&importDryRun, "dry-run", "n", false, "only report what would be imported")
+	importCalibreCmd.Flags().BoolVarP(&importResync, "resync", "r", false, "only import books added since the previous import")
+}
@@ -0,0 +1,163 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gnur/demeter/lib"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var explainHostID int
+var explainSeries string
+var explainSeriesIndex float64
+var explainLanguages []string
+
+var matchCmd = &cobra.Command{
+	Use:   "match",
+	Short: "all matching related commands",
+}
+
+var explainCmd = &cobra.Command{
+	Use:   "explain author title | explain --host hostid bookid",
+	Short: "show how a book is normalised and matched",
+	Long: `Print every normalisation step that is used to turn the author and
+title of a book into its hash, together with the books in the
+database that have the same or a similar hash.
+
+The book can either be described by an author and a title, or by
+the ID of a host and the calibre ID of a book on that host.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if explainHostID != 0 && len(args) != 1 {
+			return errors.New("provide a single calibre book ID when using --host")
+		}
+		if explainHostID == 0 && len(args) != 2 {
+			return errors.New("provide an author and a title")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var b lib.CalibreBook
+		if explainHostID != 0 {
+			h, err := store.Host(explainHostID)
+			if err != nil {
+				log.WithField("err", err).Error("No host with that ID was found")
+				return
+			}
+			bookID, err := strconv.Atoi(args[0])
+			if err != nil {
+				log.WithField("err", err).Error("please provide a numeric book ID")
+				return
+			}
+			a := lib.App{
+				UserAgent: userAgent,
+				Timeout:   time.Minute,
+			}
+			b, err = a.GetBook(h.URL, bookID)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"host": h.URL,
+					"err":  err,
+				}).Error("Could not get book")
+				return
+			}
+		} else {
+			b = lib.CalibreBook{
+				Authors:     []string{args[0]},
+				Title:       args[1],
+				Series:      explainSeries,
+				SeriesIndex: explainSeriesIndex,
+				Languages:   explainLanguages,
+			}
+		}
+
+		e, err := lib.Explain(store, b)
+		if err != nil {
+			log.WithField("err", err).Error("Could not explain book")
+			return
+		}
+		fmt.Println("Normalisation steps:")
+		for _, s := range e.Steps {
+			fmt.Printf(`  %-40s %q`, s.Name, s.Value)
+			fmt.Println()
+		}
+		fmt.Println()
+		fmt.Println("Hash:      ", e.Book.Hash)
+		if e.Book.SeriesKey != "" {
+			fmt.Printf("Series key: %s (index %s)", e.Book.SeriesKey, lib.FormatSeriesIndex(e.Book.SeriesIndex))
+			fmt.Println()
+		}
+		fmt.Println()
+		fmt.Println("Matching books:")
+		if len(e.Matches) == 0 {
+			fmt.Println(" - none")
+		}
+		for _, m := range e.Matches {
+			printExplainBook(m, -1)
+		}
+		fmt.Println("Similar books:")
+		if len(e.Similar) == 0 {
+			fmt.Println(" - none")
+		}
+		for _, s := range e.Similar {
+			printExplainBook(s.Book, s.Distance)
+		}
+	},
+}
+
+var rehashCmd = &cobra.Command{
+	Use:   "rehash",
+	Short: "recalculate the hashes of all books with the current matching rules",
+	Long: `Recalculate the hashes of all books with the current matching rules.
+This happens automatically when the rules in the config file change
+and when author aliases are edited or imported, so it is only needed
+to repair a database. Books that end up with the same hash are merged.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		changed, err := lib.Rehash(store)
+		if err != nil {
+			log.WithField("err", err).Error("Could not rehash books")
+			return
+		}
+		log.WithFields(log.Fields{
+			"changed": changed,
+			"matcher": lib.MatcherFingerprint(),
+		}).Info("books have been rehashed")
+	},
+}
+
+func printExplainBook(b lib.Book, distance int) {
+	fmt.Printf(` - %6d %-40s %s / %s (added %s, source %d)`, b.ID, b.Hash, b.Author, b.Title, b.Added.Format(time.RFC3339), b.SourceID)
+	if distance >= 0 {
+		fmt.Printf(", distance %d", distance)
+	}
+	fmt.Println()
+}
+
+func init() {
+	rootCmd.AddCommand(matchCmd)
+	matchCmd.AddCommand(explainCmd)
+	matchCmd.AddCommand(rehashCmd)
+
+	explainCmd.Flags().IntVar(&explainHostID, "host", 0, "ID of the host to retrieve the book from")
+	explainCmd.Flags().StringVarP(&explainSeries, "series", "s", "", "series the book belongs to")
+	explainCmd.Flags().Float64VarP(&explainSeriesIndex, "index", "i", 0, "index of the book in its series")
+	explainCmd.Flags().StringSliceVarP(&explainLanguages, "language", "l", nil, "languages of the book, like eng or nld")
+	explainCmd.Flags().StringVarP(&userAgent, "useragent", "u", "demeter / v1", "user agent used to identify to calibre hosts")
+}
@@ -0,0 +1,238 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gnur/demeter/lib"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var queueHost int
+var queueState string
+var queueAll bool
+var queueFailed bool
+
+var queueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "manage the download queue",
+	Long: `Scrapes add the books they find to the download queue, every run
+downloads the waiting books of its hosts afterwards. Downloads that fail
+are tried again in later runs, after 3 attempts they are marked failed
+until they are retried.`,
+}
+
+var queueListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Args:    cobra.NoArgs,
+	Short:   "list the queued downloads in the order they are downloaded",
+	Run: func(cmd *cobra.Command, args []string) {
+		sel := lib.QueueSelection{
+			HostID: queueHost,
+			All:    queueHost == 0,
+		}
+		if queueState != "" {
+			sel.States = []lib.QueueState{lib.QueueState(queueState)}
+		}
+		queue, err := lib.SelectQueue(store, sel)
+		if err != nil {
+			log.WithField("err", err).Error("Could not read the download queue")
+			return
+		}
+		if lib.IsQueuePaused(store) {
+			log.Warning("the download queue is paused, use queue resume to continue downloading")
+		}
+		if len(queue) == 0 {
+			fmt.Println("the download queue is empty")
+			return
+		}
+		fmt.Printf(`%6s|%5s|%7s|%8s|%8s|%3s|%5s| %s`, "id", "host", "calibre", "state", "priority", "try", "fmt", "book")
+		fmt.Println()
+		for _, d := range queue {
+			d.Print()
+		}
+	},
+}
+
+var queuePauseCmd = &cobra.Command{
+	Use:   "pause [queueid]...",
+	Short: "pause the download queue or single downloads",
+	Long: `Without ids or --host the whole queue is paused, runs still scrape
+and add to the queue but don't download anything. With ids or --host
+only those downloads are paused.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		sel, ok := queueSelection(args)
+		if !ok {
+			return
+		}
+		if sel.Empty() {
+			err := lib.SetQueuePaused(store, true)
+			if err != nil {
+				log.WithField("err", err).Error("Could not pause the download queue")
+				return
+			}
+			log.Info("the download queue has been paused")
+			return
+		}
+		sel.States = []lib.QueueState{lib.QueueWaiting}
+		changeQueue(sel, "paused", func(d *lib.QueuedDownload) bool {
+			d.State = lib.QueuePaused
+			return true
+		})
+	},
+}
+
+var queueResumeCmd = &cobra.Command{
+	Use:   "resume [queueid]...",
+	Short: "resume the download queue or single downloads",
+	Long: `Without ids or --host the whole queue is resumed, paused downloads
+stay paused. With ids or --host only those downloads are resumed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		sel, ok := queueSelection(args)
+		if !ok {
+			return
+		}
+		if sel.Empty() {
+			err := lib.SetQueuePaused(store, false)
+			if err != nil {
+				log.WithField("err", err).Error("Could not resume the download queue")
+				return
+			}
+			log.Info("the download queue has been resumed")
+			return
+		}
+		sel.States = []lib.QueueState{lib.QueuePaused}
+		changeQueue(sel, "resumed", func(d *lib.QueuedDownload) bool {
+			d.State = lib.QueueWaiting
+			return true
+		})
+	},
+}
+
+var queueRetryCmd = &cobra.Command{
+	Use:   "retry [queueid]...",
+	Short: "retry failed downloads",
+	Long: `Put failed downloads back in the queue with their attempts reset.
+Without ids or --host all failed downloads are retried.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		sel, ok := queueSelection(args)
+		if !ok {
+			return
+		}
+		sel.All = sel.Empty()
+		sel.States = []lib.QueueState{lib.QueueFailed}
+		changeQueue(sel, "retried", func(d *lib.QueuedDownload) bool {
+			d.State = lib.QueueWaiting
+			d.Attempts = 0
+			d.Error = ""
+			return true
+		})
+	},
+}
+
+var queueDropCmd = &cobra.Command{
+	Use:     "drop [queueid]...",
+	Aliases: []string{"rm"},
+	Short:   "remove downloads from the queue",
+	Long: `Remove downloads from the queue by their id, or all downloads of a
+host with --host. --failed only removes failed downloads, --all removes
+everything. Dropped books are not queued again by later scrapes, their
+ids were checked already.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		sel, ok := queueSelection(args)
+		if !ok {
+			return
+		}
+		sel.All = queueAll || (queueFailed && sel.Empty())
+		if queueFailed {
+			sel.States = []lib.QueueState{lib.QueueFailed}
+		}
+		if sel.Empty() {
+			log.Error("provide queue ids, --host, --failed or --all")
+			return
+		}
+		dropped, err := lib.DropQueue(store, sel)
+		if err != nil {
+			log.WithField("err", err).Error("Could not drop downloads, nothing was dropped")
+			return
+		}
+		log.WithField("downloads", len(dropped)).Info("downloads have been dropped")
+	},
+}
+
+var queuePrioritizeCmd = &cobra.Command{
+	Use:     "prioritize queueid [queueid]...",
+	Aliases: []string{"prio"},
+	Args:    cobra.MinimumNArgs(1),
+	Short:   "download books before all other queued downloads",
+	Run: func(cmd *cobra.Command, args []string) {
+		sel, ok := queueSelection(args)
+		if !ok {
+			return
+		}
+		changeQueue(sel, "prioritized", func(d *lib.QueuedDownload) bool {
+			if d.Priority == lib.PriorityManual {
+				return false
+			}
+			d.Priority = lib.PriorityManual
+			return true
+		})
+	},
+}
+
+// queueSelection selects the downloads with the ids in args and the downloads of --host
+func queueSelection(args []string) (lib.QueueSelection, bool) {
+	sel := lib.QueueSelection{HostID: queueHost}
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			log.WithField("err", err).Error("please provide a numeric ID")
+			return sel, false
+		}
+		sel.IDs = append(sel.IDs, id)
+	}
+	return sel, true
+}
+
+// changeQueue applies fn to the selected downloads and logs how many were changed
+func changeQueue(sel lib.QueueSelection, done string, fn func(d *lib.QueuedDownload) bool) {
+	changed, err := lib.UpdateQueue(store, sel, fn)
+	if err != nil {
+		log.WithField("err", err).Error("Could not update the download queue, nothing was changed")
+		return
+	}
+	log.WithField("downloads", len(changed)).Info("downloads have been " + done)
+}
+
+func init() {
+	rootCmd.AddCommand(queueCmd)
+	queueCmd.AddCommand(queueListCmd)
+	queueCmd.AddCommand(queuePauseCmd)
+	queueCmd.AddCommand(queueResumeCmd)
+	queueCmd.AddCommand(queueRetryCmd)
+	queueCmd.AddCommand(queueDropCmd)
+	queueCmd.AddCommand(queuePrioritizeCmd)
+
+	for _, c := range []*cobra.Command{queueListCmd, queuePauseCmd, queueResumeCmd, queueRetryCmd, queueDropCmd} {
+		c.Flags().IntVar(&queueHost, "host", 0, "only the downloads of this host id")
+	}
+	queueListCmd.Flags().StringVar(&queueState, "state", "", "only downloads in this state: waiting, paused or failed")
+	queueDropCmd.Flags().BoolVar(&queueFailed, "failed", false, "only failed downloads")
+	queueDropCmd.Flags().BoolVar(&queueAll, "all", false, "all downloads")
+}
@@ -24,11 +24,13 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+	"time"
 
+	"github.com/gnur/demeter/config"
 	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
 
-	"github.com/asdine/storm"
-	"github.com/asdine/storm/codec/msgpack"
 	homedir "github.com/mitchellh/go-homedir"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -36,6 +38,13 @@ import (
 )
 
 var verbose bool
+var dbPath string
+var dataDir string
+var cfg config.Config
+var store lib.Store
+
+// memoryDB is the database path that selects a store that is not persisted
+const memoryDB = ":memory:"
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -58,23 +67,96 @@ retrieving books in epub format that are not in your local library.`,
 			return
 		}
 		dbDir := path.Join(home, ".demeter")
+		dataDir = dbDir
 		err = os.MkdirAll(dbDir, 0755)
 		if err != nil {
 			log.Fatal(err)
 			return
 		}
-		dbPath := path.Join(dbDir, "demeter.db")
-		db.Conn, err = storm.Open(dbPath, storm.Codec(msgpack.Codec), storm.Batch())
-		if err == bolt.ErrTimeout {
-			log.Fatal("It looks like another demeter process is already running")
+		if dbPath == "" {
+			dbPath = os.Getenv("DEMETER_DB")
+		}
+		if dbPath == "" {
+			dbPath = path.Join(dbDir, "demeter.db")
+		}
+		if dbPath == memoryDB {
+			store = db.NewMemory()
+		} else {
+			err = os.MkdirAll(filepath.Dir(dbPath), 0755)
+			if err != nil {
+				log.Fatal(err)
+				return
+			}
+			store, err = db.Open(dbPath)
+			if err == bolt.ErrTimeout {
+				log.Fatal("It looks like another demeter process is already running")
+			}
+			if err != nil {
+				log.Fatal(err)
+				return
+			}
+		}
+
+		cfg, err = config.Load(path.Join(dbDir, "config.json"))
+		if err != nil {
+			log.WithField("err", err).Fatal("Could not load config")
+			return
 		}
+		err = lib.UseRules(cfg.Matching)
 		if err != nil {
-			log.Fatal(err)
+			log.WithField("err", err).Fatal("Invalid matching rules")
 			return
 		}
+		err = cfg.Schedule.Validate()
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid default schedule")
+			return
+		}
+		err = cfg.Adaptive.Validate()
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid adaptive interval settings")
+			return
+		}
+		err = cfg.Health.Validate()
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid health policy")
+			return
+		}
+		err = cfg.Budgets.Validate()
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid budgets")
+			return
+		}
+		if cmd == dbMigrateCmd {
+			return
+		}
+		backup, applied, err := store.Migrate()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"backup": backup,
+				"err":    err,
+			}).Fatal("Could not migrate database")
+			return
+		}
+		for _, m := range applied {
+			log.WithFields(log.Fields{
+				"version":   m.Version,
+				"migration": m.Name,
+				"backup":    backup,
+			}).Info("Database has been migrated")
+		}
+		lib.Maintain(store, cfg.Backups, cfg.Trash, time.Now())
+		changed, err := lib.EnsureMatcher(store)
+		if err != nil {
+			log.WithField("err", err).Fatal("Could not rehash books")
+			return
+		}
+		if changed > 0 {
+			log.WithField("books", changed).Info("Matching rules changed, books have been rehashed")
+		}
 	},
 	PersistentPostRun: func(cmd *cobra.Command, args []string) {
-		err := db.Conn.Close()
+		err := store.Close()
 		if err != nil {
 			log.WithField("err", err).Error("Could not close database")
 			return
@@ -93,6 +175,9 @@ type Info struct {
 
 var info Info
 
+// exitCode is the status demeter exits with once the command is done and the database is closed
+var exitCode int
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute(in Info) {
@@ -101,11 +186,13 @@ func Execute(in Info) {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	os.Exit(exitCode)
 }
 
 func init() {
 
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose output")
+	rootCmd.PersistentFlags().StringVar(&dbPath, "db", "", "path of the database, defaults to $DEMETER_DB or ~/.demeter/demeter.db ("+memoryDB+" keeps everything in memory)")
 
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
@@ -15,11 +15,14 @@
 package cmd
 
 import (
-	"math/rand"
-	"sync"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/gnur/demeter/db"
 	"github.com/gnur/demeter/lib"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -27,123 +30,204 @@ import (
 
 var stepSize int
 var workers int
+var queueSize int
 var userAgent string
 var outputDir string
 var extension string
+var onDuplicate string
+var preload bool
+var maxDuration time.Duration
+var maxDownloads int
+var maxBytes string
+var hostMaxDuration time.Duration
+var hostMaxDownloads int
+var hostMaxBytes string
+var jsonSummary bool
 
 // runCmd represents the run command
 var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "run all scrape jobs",
-	Long: `Go over all defined hosts and if the last scrape
-is old enough it will scrape that host.`,
+	Long: `Go over all active hosts and scrape the ones that are due
+according to their schedule, see host schedule.
+
+The --max flags limit the whole run and the --host-max flags every host,
+they replace the budgets of the config. When a budget runs out the rest of
+the work of a host is deferred to its next scrape.
+
+When the run is done a summary of every host is printed, as JSON with
+--json. demeter exits with status 1 when a host failed or the run was
+stopped by SIGINT or SIGTERM. The running scrapes then keep what they
+downloaded so far, a second signal stops demeter right away.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		var hosts []lib.Host
-		db.Conn.Find("Active", true, &hosts)
+		run := lib.Budget{
+			MaxDownloads: maxDownloads,
+			MaxBytes:     maxBytes,
+			MaxDuration:  formatBudgetDuration(maxDuration),
+		}.Or(cfg.Budgets.Run)
+		p := cfg.Policy()
+		p.Budgets.Host = lib.Budget{
+			MaxDownloads: hostMaxDownloads,
+			MaxBytes:     hostMaxBytes,
+			MaxDuration:  formatBudgetDuration(hostMaxDuration),
+		}.Or(p.Budgets.Host)
+		err := p.Budgets.Validate()
+		if err == nil {
+			err = run.Validate()
+		}
+		if err != nil {
+			log.WithField("err", err).Error("Invalid budget")
+			exitCode = 1
+			return
+		}
+		a, err := newApp()
+		if err != nil {
+			log.WithField("err", err).Error("Could not start scraping")
+			exitCode = 1
+			return
+		}
+		defer a.Pool.Close()
+
+		ctx, cancel := run.WithDeadline(context.Background())
+		defer cancel()
+		defer cancelOnSignal(cancel, "Stopping the run")()
+
+		err = a.ProbeHosts(ctx, p)
+		if err != nil {
+			log.WithField("err", err).Error("Could not probe disabled hosts")
+		}
+
+		hosts, err := store.ActiveHosts()
+		if err != nil {
+			log.WithField("err", err).Error("Could not list hosts")
+			exitCode = 1
+			return
+		}
 
 		if len(hosts) == 0 {
 			log.Info("no active hosts were found")
 			return
 		}
 
-		qs := lib.WorkerQueues{
-			IDS:    make(chan lib.GetIDSRequest),
-			Books:  make(chan lib.GetBooksRequest),
-			DlBook: make(chan lib.DownloadBookRequest),
+		o := lib.Orchestrator{
+			App:    a,
+			Policy: p,
+			Budget: run,
+		}
+		summary := o.Run(ctx, hosts)
+		if !summary.OK() {
+			exitCode = 1
+		}
+		if jsonSummary {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(summary)
+			if err != nil {
+				log.WithField("err", err).Error("Could not write summary")
+			}
+			return
 		}
+		printSummary(summary)
+	},
+}
 
-		a := lib.App{
-			UserAgent:       userAgent,
-			Timeout:         3 * time.Minute,
-			DownloadTimeout: 5 * time.Minute,
-			WorkerInterval:  5 * time.Minute,
-			StepSize:        stepSize,
-			OutputDir:       outputDir,
-			Extension:       extension,
-			Queues:          qs,
+// printSummary prints the outcome of every host that was due and the totals of a run
+func printSummary(s lib.RunSummary) {
+	for _, h := range s.Hosts {
+		if h.Status == lib.HostNotDue {
+			continue
 		}
+		fmt.Printf("%5d|%30s|%12s|%7d|%9d|%12s| %s\n", h.ID, h.URL, h.Status, h.Results, h.Downloads, h.Duration, h.Error)
+	}
+	fmt.Printf("%d scraped, %d failed, %d interrupted, %d deferred, %d downloads (%s) in %s\n", s.Scraped, s.Failed, s.Interrupted, s.Deferred, s.Downloads, lib.FormatBytes(s.Bytes), s.End.Sub(s.Start).Round(time.Second))
+	if s.Cancelled != "" {
+		fmt.Printf("the run stopped early: %s\n", s.Cancelled)
+	}
+}
 
-		for i := 0; i < workers; i++ {
-			go a.Worker(i, qs)
+// formatBudgetDuration formats a duration flag for a budget, 0 is not set
+func formatBudgetDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// cancelOnSignal calls cancel on the first SIGINT or SIGTERM and stops demeter right away
+// on the second one. The returned function stops listening for signals.
+func cancelOnSignal(cancel context.CancelFunc, msg string) func() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			log.WithField("signal", sig).Info(msg + ", send it again to stop right away")
+			cancel()
+		case <-done:
+			return
 		}
-		var wg sync.WaitGroup
-
-		for _, h := range hosts {
-			go func(h lib.Host) {
-				jitter := time.Duration(rand.Intn(3600)) * time.Second
-				cutOffPoint := time.Now().Add(-jitter).Add(-12 * time.Hour)
-				if !h.LastScrape.Before(cutOffPoint) {
-					return
-				}
-				log.WithField("host", h.URL).Info("Starting work")
-				wg.Add(1)
-				result, err := a.Scrape(&h)
-				failedScrapes := 0
-				h.LastRunSuccessful = true
-				if err != nil {
-					log.WithFields(log.Fields{
-						"host": h.URL,
-						"err":  err,
-					}).Error("Scraping failed")
-					h.LastRunSuccessful = false
-					for _, s := range h.ScrapeResults {
-						if !s.Success {
-							failedScrapes++
-						}
-					}
-
-				} else {
-					log.WithFields(log.Fields{
-						"host":      h.URL,
-						"downloads": result.Downloads,
-						"duration":  time.Since(result.Start).String(),
-						"err":       err,
-					}).Info("Scraping done")
-				}
-				h.Downloads += result.Downloads
-				h.Scrapes++
-				if result.Downloads > 0 {
-					h.LastDownload = result.End
-				}
-				fails, dls := h.Stats(10)
-				log.WithFields(log.Fields{
-					"dls":            dls,
-					"fails":          fails,
-					"failedScrapes":  failedScrapes,
-					"result.success": result.Success,
-				}).Debug("info")
-				if dls == 0 && fails >= 5 && !h.LastRunSuccessful {
-					h.Active = false
-					err = db.Conn.UpdateField(&h, "Active", false)
-					log.WithFields(log.Fields{
-						"host":    h.URL,
-						"scrapes": h.Scrapes,
-					}).Warning("Disabling host because there were 5 failures and no new downloads")
-				}
-				h.LastScrape = result.End
-
-				h.ScrapeResults = append(h.ScrapeResults, *result)
-				err = db.Conn.Update(&h)
-				if err != nil {
-					log.WithFields(log.Fields{
-						"host": h.URL,
-						"err":  err,
-					}).Error("Could not store scrape result, exiting hard")
-				}
-				wg.Done()
-			}(h)
+		select {
+		case sig := <-signals:
+			log.WithField("signal", sig).Warning("Stopping right away")
+			os.Exit(1)
+		case <-done:
 		}
-		time.Sleep(5 * time.Second)
-		wg.Wait()
-	},
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// newApp validates the scrape flags, builds the app and starts its workers, which are stopped by closing a.Pool
+func newApp() (*lib.App, error) {
+	if onDuplicate != lib.DuplicateLink && onDuplicate != lib.DuplicateSkip && onDuplicate != lib.DuplicateKeep {
+		return nil, fmt.Errorf("on-duplicate should be link, skip or keep, not %q", onDuplicate)
+	}
+	a := &lib.App{
+		Store:           store,
+		UserAgent:       userAgent,
+		Timeout:         3 * time.Minute,
+		DownloadTimeout: 5 * time.Minute,
+		StepSize:        stepSize,
+		OutputDir:       outputDir,
+		Extension:       extension,
+		OnDuplicate:     onDuplicate,
+	}
+	if preload {
+		var err error
+		a.Preloaded, err = lib.LoadBookSet(store)
+		if err != nil {
+			return nil, fmt.Errorf("could not preload books: %w", err)
+		}
+		log.WithField("books", a.Preloaded.Len()).Debug("Preloaded books")
+	}
+
+	a.Pool = lib.NewPool(workers, queueSize, 5*time.Minute)
+	return a, nil
+}
+
+// addScrapeFlags adds the flags that configure how hosts are scraped
+func addScrapeFlags(c *cobra.Command) {
+	c.Flags().IntVarP(&stepSize, "stepsize", "n", 50, "number of books to request per query")
+	c.Flags().IntVarP(&workers, "workers", "w", 10, "number of workers to concurrently download books")
+	c.Flags().IntVar(&queueSize, "queue-size", 100, "number of requests per priority that can wait for a worker")
+	c.Flags().StringVarP(&userAgent, "useragent", "u", "demeter / v1", "user agent used to identify to calibre hosts")
+	c.Flags().StringVarP(&outputDir, "outputdir", "d", "books", "path to downloaded books to")
+	c.Flags().StringVarP(&extension, "extension", "e", "epub", "extension of files to download")
+	c.Flags().BoolVar(&preload, "preload", false, "keep all known books in memory during the run instead of looking them up in the database")
+	c.Flags().StringVar(&onDuplicate, "on-duplicate", lib.DuplicateLink, "what to do with downloads identical to an existing file: link, skip or keep")
 }
 
 func init() {
 	scrapeCmd.AddCommand(runCmd)
-
-	runCmd.Flags().IntVarP(&stepSize, "stepsize", "n", 50, "number of books to request per query")
-	runCmd.Flags().IntVarP(&workers, "workers", "w", 10, "number of workers to concurrently download books")
-	runCmd.Flags().StringVarP(&userAgent, "useragent", "u", "demeter / v1", "user agent used to identify to calibre hosts")
-	runCmd.Flags().StringVarP(&outputDir, "outputdir", "d", "books", "path to downloaded books to")
-	runCmd.Flags().StringVarP(&extension, "extension", "e", "epub", "extension of files to download")
+	addScrapeFlags(runCmd)
+	runCmd.Flags().DurationVar(&maxDuration, "max-duration", 0, "defer what is left after the run took this long")
+	runCmd.Flags().IntVar(&maxDownloads, "max-downloads", 0, "defer what is left after this many downloads in the run")
+	runCmd.Flags().StringVar(&maxBytes, "max-bytes", "", "defer what is left after downloading this much in the run, like 500MB")
+	runCmd.Flags().DurationVar(&hostMaxDuration, "host-max-duration", 0, "defer what is left of a host after it was scraped for this long")
+	runCmd.Flags().IntVar(&hostMaxDownloads, "host-max-downloads", 0, "defer what is left of a host after this many downloads from it")
+	runCmd.Flags().StringVar(&hostMaxBytes, "host-max-bytes", "", "defer what is left of a host after downloading this much from it, like 500MB")
+	runCmd.Flags().BoolVar(&jsonSummary, "json", false, "print the summary of the run as JSON")
 }
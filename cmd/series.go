@@ -0,0 +1,90 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gnur/demeter/lib"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var showComplete bool
+
+// seriesCmd represents the series command
+var seriesCmd = &cobra.Command{
+	Use:   "series [filter]",
+	Short: "list series with missing volumes",
+	Long: `List all series that have at least one volume in the database,
+together with the missing volumes and the hosts that had them in
+their last catalog snapshot.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		report, err := lib.SeriesReport(store)
+		if err != nil {
+			log.WithField("err", err).Error("could not build series report")
+			return
+		}
+		hosts, _ := store.Hosts()
+		hostURLs := make(map[int]string, len(hosts))
+		for _, h := range hosts {
+			hostURLs[h.ID] = h.URL
+		}
+
+		shown := 0
+		for _, s := range report {
+			if len(args) == 1 && !strings.Contains(strings.ToLower(s.Name), strings.ToLower(args[0])) {
+				continue
+			}
+			if len(s.Missing) == 0 && !showComplete {
+				continue
+			}
+			shown++
+			owned := make([]string, len(s.Owned))
+			for i, index := range s.Owned {
+				owned[i] = lib.FormatSeriesIndex(index)
+			}
+			fmt.Printf("%s (%s), have: %s", s.Name, s.Author, strings.Join(owned, ", "))
+			fmt.Println()
+			for _, m := range s.Missing {
+				urls := make([]string, len(m.Hosts))
+				for i, id := range m.Hosts {
+					urls[i] = hostURLs[id]
+				}
+				title := m.Title
+				if title == "" {
+					title = "unknown title"
+				}
+				available := "not available on any host"
+				if len(urls) > 0 {
+					available = strings.Join(urls, ", ")
+				}
+				fmt.Printf(" - missing %s: %s, %s", lib.FormatSeriesIndex(m.Index), title, available)
+				fmt.Println()
+			}
+		}
+		if shown == 0 {
+			log.Info("no series with missing volumes were found")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(seriesCmd)
+
+	seriesCmd.Flags().BoolVarP(&showComplete, "all", "a", false, "also show complete series")
+}
@@ -0,0 +1,59 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/gnur/demeter/lib"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Args:  cobra.NoArgs,
+	Short: "show scrapes that are in progress or were interrupted",
+	Long: `Show the hosts whose last scrape did not finish, together with how far
+it got. The next run resumes these scrapes where they stopped.
+
+An interrupted scrape was stopped by --max-duration or a signal, a failed
+scrape could not retrieve a page of books and retries it. A killed
+scrape was running when demeter stopped without cleaning up.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cps, err := store.Checkpoints()
+		if err != nil {
+			log.WithField("err", err).Error("Could not list scrape checkpoints")
+			return
+		}
+		if len(cps) == 0 {
+			fmt.Println("all scrapes finished")
+			return
+		}
+		fmt.Printf(`%5s|%30s|%12s|%17s|%17s|%4s| %s`, "id", "url", "state", "started", "updated", "runs", "progress")
+		fmt.Println()
+		for _, cp := range cps {
+			h, err := store.Host(cp.HostID)
+			if err != nil {
+				h = lib.Host{ID: cp.HostID}
+			}
+			cp.Print(h)
+		}
+	},
+}
+
+func init() {
+	scrapeCmd.AddCommand(statusCmd)
+}
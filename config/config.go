@@ -0,0 +1,68 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/gnur/demeter/lib"
+)
+
+// Config holds all user editable settings of demeter
+type Config struct {
+	Matching lib.Rules          `json:"matching"`
+	History  lib.Retention      `json:"history"`
+	Backups  lib.BackupSchedule `json:"backups"`
+	Trash    lib.TrashExpiry    `json:"trash"`
+	Schedule lib.Schedule       `json:"schedule"`
+	Adaptive lib.Adaptive       `json:"adaptive"`
+	Health   lib.HealthPolicy   `json:"health"`
+	Budgets  lib.Budgets        `json:"budgets"`
+}
+
+// Default returns the config that is used when no config file exists yet
+func Default() Config {
+	return Config{
+		Matching: lib.DefaultRules(),
+		History:  lib.DefaultRetention(),
+		Backups:  lib.DefaultBackupSchedule(),
+		Trash:    lib.DefaultTrashExpiry(),
+		Schedule: lib.DefaultSchedule(),
+		Adaptive: lib.DefaultAdaptive(),
+		Health:   lib.DefaultHealthPolicy(),
+	}
+}
+
+// Policy returns the settings that decide when and how hosts are scraped
+func (c Config) Policy() lib.Policy {
+	return lib.Policy{
+		Schedule: c.Schedule,
+		Adaptive: c.Adaptive,
+		History:  c.History,
+		Health:   c.Health,
+		Budgets:  c.Budgets,
+	}
+}
+
+// Load reads the config file at path, it is created with the default config if it doesn't exist
+func Load(path string) (Config, error) {
+	c := Default()
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, Save(path, c)
+	}
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(raw, &c)
+	return c, err
+}
+
+// Save writes the config to path
+func Save(path string, c Config) error {
+	raw, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, raw, 0644)
+}
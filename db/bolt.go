@@ -0,0 +1,672 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/codec/msgpack"
+	"github.com/gnur/demeter/lib"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	checkedBucket       = "checked"
+	legacyCheckedBucket = "checked_ids"
+	metaBucket          = "meta"
+	scrapeBucket        = "scrapes"
+	scrapeDayBucket     = "scrape_days"
+)
+
+// checkedValue is stored for every checked book ID, bbolt can't tell an empty value from a missing one
+var checkedValue = []byte{1}
+
+var _ lib.Store = &Bolt{}
+
+// Bolt is a lib.Store that keeps everything in a bbolt database
+type Bolt struct {
+	node storm.Node
+	db   *storm.DB
+	tx   *bolt.Tx
+	path string
+}
+
+// Open opens the database at path. It is created if it doesn't exist,
+// a new database starts at the current schema version.
+func Open(path string) (*Bolt, error) {
+	_, err := os.Stat(path)
+	created := os.IsNotExist(err)
+	s, err := openStorm(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bolt{
+		node: s,
+		db:   s,
+		path: path,
+	}
+	if created {
+		err = b.SetMeta(schemaKey, schemaVersion())
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// lockTimeout is how long Open waits for another process to close the database
+const lockTimeout = time.Second
+
+// openStorm opens the bbolt file at path with the options demeter uses everywhere
+func openStorm(path string) (*storm.DB, error) {
+	return storm.Open(path, storm.Codec(msgpack.Codec), storm.Batch(), storm.BoltOptions(0600, &bolt.Options{Timeout: lockTimeout}))
+}
+
+// Path returns the path of the database file
+func (b *Bolt) Path() string {
+	return b.path
+}
+
+// Hosts returns all hosts
+func (b *Bolt) Hosts() ([]lib.Host, error) {
+	var hosts []lib.Host
+	err := b.node.All(&hosts)
+	return hosts, convertErr(err)
+}
+
+// ActiveHosts returns all hosts that are active
+func (b *Bolt) ActiveHosts() ([]lib.Host, error) {
+	var hosts []lib.Host
+	err := b.node.Find("Active", true, &hosts)
+	if err == storm.ErrNotFound {
+		return hosts, nil
+	}
+	return hosts, convertErr(err)
+}
+
+// Host returns the host with the given ID
+func (b *Bolt) Host(id int) (lib.Host, error) {
+	var h lib.Host
+	err := b.node.One("ID", id, &h)
+	return h, convertErr(err)
+}
+
+// HostByURL returns the host with the given url
+func (b *Bolt) HostByURL(url string) (lib.Host, error) {
+	var h lib.Host
+	err := b.node.One("URL", url, &h)
+	return h, convertErr(err)
+}
+
+// SaveHost creates a host, or replaces it if it has an ID
+func (b *Bolt) SaveHost(h *lib.Host) error {
+	return convertErr(b.node.Save(h))
+}
+
+// DeleteHost removes a host
+func (b *Bolt) DeleteHost(id int) error {
+	return convertErr(b.node.DeleteStruct(&lib.Host{ID: id}))
+}
+
+// Books returns all books
+func (b *Bolt) Books() ([]lib.Book, error) {
+	var books []lib.Book
+	err := b.node.All(&books)
+	return books, convertErr(err)
+}
+
+// BookByHash returns the book with the given hash
+func (b *Bolt) BookByHash(hash string) (lib.Book, error) {
+	return b.oneBook("Hash", hash)
+}
+
+// BookByUUID returns the first book with the given calibre uuid
+func (b *Bolt) BookByUUID(uuid string) (lib.Book, error) {
+	return b.oneBook("UUID", uuid)
+}
+
+// BookByFileHash returns the first book with the given file hash
+func (b *Bolt) BookByFileHash(fileHash string) (lib.Book, error) {
+	return b.oneBook("FileHash", fileHash)
+}
+
+// BookByPath returns the first book that is stored in path
+func (b *Bolt) BookByPath(path string) (lib.Book, error) {
+	return b.oneBook("Path", path)
+}
+
+func (b *Bolt) oneBook(field string, value interface{}) (lib.Book, error) {
+	var book lib.Book
+	err := b.node.One(field, value, &book)
+	return book, convertErr(err)
+}
+
+// BooksInSeries returns all books with the given series key
+func (b *Bolt) BooksInSeries(key string) ([]lib.Book, error) {
+	var books []lib.Book
+	err := b.node.Find("SeriesKey", key, &books)
+	if err == storm.ErrNotFound {
+		return books, nil
+	}
+	return books, convertErr(err)
+}
+
+// SaveBook creates a book, or replaces it if it has an ID
+func (b *Bolt) SaveBook(book *lib.Book) error {
+	return convertErr(b.node.Save(book))
+}
+
+// DeleteBook removes a book
+func (b *Bolt) DeleteBook(id int) error {
+	return convertErr(b.node.DeleteStruct(&lib.Book{ID: id}))
+}
+
+// hostKey is the name of the bucket that holds the records of a host
+func hostKey(hostID int) []byte {
+	return []byte(strconv.Itoa(hostID))
+}
+
+// bookKey encodes a book ID so the keys of a host bucket are sorted by ID
+func bookKey(bookID int) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(bookID))
+	return k
+}
+
+// IsChecked returns true if a book ID of a host has been checked before
+func (b *Bolt) IsChecked(hostID, bookID int) (bool, error) {
+	var found bool
+	err := b.view(func(tx *bolt.Tx) error {
+		hb := hostBucket(tx, checkedBucket, hostID)
+		found = hb != nil && hb.Get(bookKey(bookID)) != nil
+		return nil
+	})
+	return found, err
+}
+
+// MarkChecked marks a book ID of a host as checked
+func (b *Bolt) MarkChecked(hostID, bookID int) error {
+	return b.update(func(tx *bolt.Tx) error {
+		hb, err := nestedBucket(tx, checkedBucket, hostKey(hostID))
+		if err != nil {
+			return err
+		}
+		return hb.Put(bookKey(bookID), checkedValue)
+	})
+}
+
+// CheckedIDs returns all checked book IDs of all hosts
+func (b *Bolt) CheckedIDs() ([]lib.CheckedID, error) {
+	var checked []lib.CheckedID
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(checkedBucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			hostID, err := strconv.Atoi(string(k))
+			hb := bucket.Bucket(k)
+			if err != nil || hb == nil {
+				return nil
+			}
+			return hb.ForEach(func(k, v []byte) error {
+				checked = append(checked, lib.CheckedID{
+					HostID: hostID,
+					BookID: int(binary.BigEndian.Uint64(k)),
+				})
+				return nil
+			})
+		})
+	})
+	return checked, err
+}
+
+// ClearChecked removes all checked book IDs of a host and returns how many were removed
+func (b *Bolt) ClearChecked(hostID int) (int, error) {
+	return b.deleteHostBucket(checkedBucket, hostID)
+}
+
+// deleteHostBucket removes the bucket of a host from a per host bucket and returns how many keys it held
+func (b *Bolt) deleteHostBucket(name string, hostID int) (int, error) {
+	removed := 0
+	err := b.update(func(tx *bolt.Tx) error {
+		hb := hostBucket(tx, name, hostID)
+		if hb == nil {
+			return nil
+		}
+		removed = hb.Stats().KeyN
+		return tx.Bucket([]byte(name)).DeleteBucket(hostKey(hostID))
+	})
+	return removed, err
+}
+
+// view runs fn in a read transaction, or in the current transaction if there is one
+func (b *Bolt) view(fn func(tx *bolt.Tx) error) error {
+	if b.tx != nil {
+		return fn(b.tx)
+	}
+	return b.db.Bolt.View(fn)
+}
+
+// update runs fn in a write transaction, or in the current transaction if there is one
+func (b *Bolt) update(fn func(tx *bolt.Tx) error) error {
+	if b.tx != nil {
+		return fn(b.tx)
+	}
+	return b.db.Bolt.Update(fn)
+}
+
+// timeKey encodes a time so the keys of a bucket are sorted by time
+func timeKey(t time.Time) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	return k
+}
+
+// AddScrapeResult adds a scrape result to the history of a host and to the aggregate of its day
+func (b *Bolt) AddScrapeResult(hostID int, r lib.ScrapeResult) error {
+	_, err := b.Host(hostID)
+	if err != nil {
+		return err
+	}
+	codec := b.db.Codec()
+	return b.update(func(tx *bolt.Tx) error {
+		runs, err := nestedBucket(tx, scrapeBucket, hostKey(hostID))
+		if err != nil {
+			return err
+		}
+		key := timeKey(r.Start)
+		if runs.Get(key) != nil {
+			return lib.ErrExists
+		}
+		raw, err := codec.Marshal(r)
+		if err != nil {
+			return err
+		}
+		err = runs.Put(key, raw)
+		if err != nil {
+			return err
+		}
+
+		days, err := nestedBucket(tx, scrapeDayBucket, hostKey(hostID))
+		if err != nil {
+			return err
+		}
+		var day lib.ScrapeDay
+		date := []byte(lib.ScrapeDate(r.Start))
+		if raw := days.Get(date); raw != nil {
+			err = codec.Unmarshal(raw, &day)
+			if err != nil {
+				return err
+			}
+		}
+		raw, err = codec.Marshal(day.Add(r))
+		if err != nil {
+			return err
+		}
+		return days.Put(date, raw)
+	})
+}
+
+// nestedBucket returns the bucket key within the top level bucket name, both are created if they don't exist
+func nestedBucket(tx *bolt.Tx, name string, key []byte) (*bolt.Bucket, error) {
+	parent, err := tx.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return nil, err
+	}
+	return parent.CreateBucketIfNotExists(key)
+}
+
+// hostBucket returns the bucket of a host within the top level bucket name, or nil if it doesn't exist
+func hostBucket(tx *bolt.Tx, name string, hostID int) *bolt.Bucket {
+	parent := tx.Bucket([]byte(name))
+	if parent == nil {
+		return nil
+	}
+	return parent.Bucket(hostKey(hostID))
+}
+
+// ScrapeResults returns the last n scrape results of a host, oldest first
+func (b *Bolt) ScrapeResults(hostID, n int) ([]lib.ScrapeResult, error) {
+	var results []lib.ScrapeResult
+	codec := b.db.Codec()
+	err := b.view(func(tx *bolt.Tx) error {
+		runs := hostBucket(tx, scrapeBucket, hostID)
+		if runs == nil {
+			return nil
+		}
+		c := runs.Cursor()
+		for k, v := c.Last(); k != nil && (n <= 0 || len(results) < n); k, v = c.Prev() {
+			var r lib.ScrapeResult
+			err := codec.Unmarshal(v, &r)
+			if err != nil {
+				return err
+			}
+			results = append(results, r)
+		}
+		return nil
+	})
+	for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+		results[i], results[j] = results[j], results[i]
+	}
+	return results, err
+}
+
+// ScrapeDays returns the daily aggregates of the scrape results of a host, oldest first
+func (b *Bolt) ScrapeDays(hostID int) ([]lib.ScrapeDay, error) {
+	var days []lib.ScrapeDay
+	codec := b.db.Codec()
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := hostBucket(tx, scrapeDayBucket, hostID)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var day lib.ScrapeDay
+			err := codec.Unmarshal(v, &day)
+			days = append(days, day)
+			return err
+		})
+	})
+	return days, err
+}
+
+// PruneScrapeResults removes the scrape results of a host that are not among the last keepRuns and started before keepSince
+func (b *Bolt) PruneScrapeResults(hostID, keepRuns int, keepSince time.Time) (int, error) {
+	removed := 0
+	since := timeKey(keepSince)
+	err := b.update(func(tx *bolt.Tx) error {
+		runs := hostBucket(tx, scrapeBucket, hostID)
+		if runs == nil {
+			return nil
+		}
+		var old [][]byte
+		c := runs.Cursor()
+		i := 0
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			if i >= keepRuns && bytes.Compare(k, since) < 0 {
+				old = append(old, k)
+			}
+			i++
+		}
+		for _, k := range old {
+			err := runs.Delete(k)
+			if err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// AddHostEvent adds a change of the health of a host to its history
+func (b *Bolt) AddHostEvent(e *lib.HostEvent) error {
+	return convertErr(b.node.Save(e))
+}
+
+// HostEvents returns the health changes of a host, oldest first
+func (b *Bolt) HostEvents(hostID int) ([]lib.HostEvent, error) {
+	var events []lib.HostEvent
+	err := b.node.Find("HostID", hostID, &events)
+	if err == storm.ErrNotFound {
+		return events, nil
+	}
+	return events, convertErr(err)
+}
+
+// DeleteHostEvent removes a health change from the history of its host
+func (b *Bolt) DeleteHostEvent(id int) error {
+	return convertErr(b.node.DeleteStruct(&lib.HostEvent{ID: id}))
+}
+
+// Alias returns the alias with the given key
+func (b *Bolt) Alias(key string) (lib.Alias, error) {
+	var a lib.Alias
+	err := b.node.One("Key", key, &a)
+	return a, convertErr(err)
+}
+
+// Aliases returns all aliases
+func (b *Bolt) Aliases() ([]lib.Alias, error) {
+	var aliases []lib.Alias
+	err := b.node.All(&aliases)
+	return aliases, convertErr(err)
+}
+
+// SaveAlias creates or replaces an alias
+func (b *Bolt) SaveAlias(a *lib.Alias) error {
+	return convertErr(b.node.Save(a))
+}
+
+// DeleteAlias removes an alias
+func (b *Bolt) DeleteAlias(key string) error {
+	return convertErr(b.node.DeleteStruct(&lib.Alias{Key: key}))
+}
+
+// ClearScrapeResults removes all scrape results and daily aggregates of a host and returns how many results were removed
+func (b *Bolt) ClearScrapeResults(hostID int) (int, error) {
+	var removed int
+	err := b.update(func(tx *bolt.Tx) error {
+		tb := b.withTx(tx)
+		var err error
+		removed, err = tb.deleteHostBucket(scrapeBucket, hostID)
+		if err != nil {
+			return err
+		}
+		_, err = tb.deleteHostBucket(scrapeDayBucket, hostID)
+		return err
+	})
+	return removed, err
+}
+
+// CatalogEntry returns a single entry of a catalog snapshot
+func (b *Bolt) CatalogEntry(id string) (lib.CatalogEntry, error) {
+	var e lib.CatalogEntry
+	err := b.node.One("ID", id, &e)
+	return e, convertErr(err)
+}
+
+// Catalog returns the catalog snapshot of a host
+func (b *Bolt) Catalog(hostID int) ([]lib.CatalogEntry, error) {
+	return b.findCatalog("HostID", hostID)
+}
+
+// CatalogEntries returns the catalog snapshots of all hosts
+func (b *Bolt) CatalogEntries() ([]lib.CatalogEntry, error) {
+	var entries []lib.CatalogEntry
+	err := b.node.All(&entries)
+	return entries, convertErr(err)
+}
+
+// CatalogInSeries returns all catalog entries with the given series key
+func (b *Bolt) CatalogInSeries(key string) ([]lib.CatalogEntry, error) {
+	return b.findCatalog("SeriesKey", key)
+}
+
+func (b *Bolt) findCatalog(field string, value interface{}) ([]lib.CatalogEntry, error) {
+	var entries []lib.CatalogEntry
+	err := b.node.Find(field, value, &entries)
+	if err == storm.ErrNotFound {
+		return entries, nil
+	}
+	return entries, convertErr(err)
+}
+
+// SaveCatalogEntry creates or replaces a catalog entry
+func (b *Bolt) SaveCatalogEntry(e *lib.CatalogEntry) error {
+	return convertErr(b.node.Save(e))
+}
+
+// DeleteCatalogEntry removes a catalog entry
+func (b *Bolt) DeleteCatalogEntry(id string) error {
+	return convertErr(b.node.DeleteStruct(&lib.CatalogEntry{ID: id}))
+}
+
+// CalibreLibrary returns the import state of a local calibre library
+func (b *Bolt) CalibreLibrary(path string) (lib.CalibreLibrary, error) {
+	var l lib.CalibreLibrary
+	err := b.node.One("Path", path, &l)
+	return l, convertErr(err)
+}
+
+// CalibreLibraries returns the import state of all local calibre libraries
+func (b *Bolt) CalibreLibraries() ([]lib.CalibreLibrary, error) {
+	var libs []lib.CalibreLibrary
+	err := b.node.All(&libs)
+	return libs, convertErr(err)
+}
+
+// SaveCalibreLibrary creates or replaces the import state of a local calibre library
+func (b *Bolt) SaveCalibreLibrary(l *lib.CalibreLibrary) error {
+	return convertErr(b.node.Save(l))
+}
+
+// Checkpoint returns the checkpoint of the scrape of a host
+func (b *Bolt) Checkpoint(hostID int) (lib.ScrapeCheckpoint, error) {
+	var cp lib.ScrapeCheckpoint
+	err := b.node.One("HostID", hostID, &cp)
+	return cp, convertErr(err)
+}
+
+// Checkpoints returns the checkpoints of all hosts
+func (b *Bolt) Checkpoints() ([]lib.ScrapeCheckpoint, error) {
+	var cps []lib.ScrapeCheckpoint
+	err := b.node.All(&cps)
+	return cps, convertErr(err)
+}
+
+// SaveCheckpoint creates or replaces the checkpoint of the scrape of a host
+func (b *Bolt) SaveCheckpoint(cp *lib.ScrapeCheckpoint) error {
+	return convertErr(b.node.Save(cp))
+}
+
+// DeleteCheckpoint removes the checkpoint of the scrape of a host
+func (b *Bolt) DeleteCheckpoint(hostID int) error {
+	return convertErr(b.node.DeleteStruct(&lib.ScrapeCheckpoint{HostID: hostID}))
+}
+
+// QueuedDownload returns the queued download with the given ID
+func (b *Bolt) QueuedDownload(id int) (lib.QueuedDownload, error) {
+	var d lib.QueuedDownload
+	err := b.node.One("ID", id, &d)
+	return d, convertErr(err)
+}
+
+// QueuedDownloadByHash returns the queued download of the book with the given hash
+func (b *Bolt) QueuedDownloadByHash(hash string) (lib.QueuedDownload, error) {
+	var d lib.QueuedDownload
+	err := b.node.One("Hash", hash, &d)
+	return d, convertErr(err)
+}
+
+// Queue returns all queued downloads, oldest first
+func (b *Bolt) Queue() ([]lib.QueuedDownload, error) {
+	var queue []lib.QueuedDownload
+	err := b.node.All(&queue)
+	return queue, convertErr(err)
+}
+
+// HostQueue returns the queued downloads of a host, oldest first
+func (b *Bolt) HostQueue(hostID int) ([]lib.QueuedDownload, error) {
+	var queue []lib.QueuedDownload
+	err := b.node.Find("HostID", hostID, &queue)
+	if err == storm.ErrNotFound {
+		return queue, nil
+	}
+	return queue, convertErr(err)
+}
+
+// SaveQueuedDownload creates a queued download, or replaces it if it has an ID
+func (b *Bolt) SaveQueuedDownload(d *lib.QueuedDownload) error {
+	return convertErr(b.node.Save(d))
+}
+
+// DeleteQueuedDownload removes a download from the queue
+func (b *Bolt) DeleteQueuedDownload(id int) error {
+	return convertErr(b.node.DeleteStruct(&lib.QueuedDownload{ID: id}))
+}
+
+// TrashedBook returns the trashed book with the given trash ID
+func (b *Bolt) TrashedBook(id int) (lib.TrashedBook, error) {
+	var t lib.TrashedBook
+	err := b.node.One("ID", id, &t)
+	return t, convertErr(err)
+}
+
+// Trash returns all trashed books, oldest first
+func (b *Bolt) Trash() ([]lib.TrashedBook, error) {
+	var trash []lib.TrashedBook
+	err := b.node.All(&trash)
+	return trash, convertErr(err)
+}
+
+// SaveTrashedBook creates a trashed book, or replaces it if it has an ID
+func (b *Bolt) SaveTrashedBook(t *lib.TrashedBook) error {
+	return convertErr(b.node.Save(t))
+}
+
+// DeleteTrashedBook removes a book from the trash
+func (b *Bolt) DeleteTrashedBook(id int) error {
+	return convertErr(b.node.DeleteStruct(&lib.TrashedBook{ID: id}))
+}
+
+// Meta reads a metadata value into v
+func (b *Bolt) Meta(key string, v interface{}) error {
+	return convertErr(b.node.Get(metaBucket, key, v))
+}
+
+// SetMeta stores a metadata value
+func (b *Bolt) SetMeta(key string, v interface{}) error {
+	return convertErr(b.node.Set(metaBucket, key, v))
+}
+
+// withTx returns a copy of b that runs everything in tx
+func (b *Bolt) withTx(tx *bolt.Tx) *Bolt {
+	return &Bolt{
+		node: b.db.WithTransaction(tx),
+		db:   b.db,
+		tx:   tx,
+		path: b.path,
+	}
+}
+
+// View runs fn in a single read-only transaction, or in the current transaction if there is one
+func (b *Bolt) View(fn func(lib.Store) error) error {
+	if b.tx != nil {
+		return fn(b)
+	}
+	tx, err := b.db.Bolt.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(b.withTx(tx))
+}
+
+// Update runs fn in a single transaction, nothing is stored when fn returns an error
+func (b *Bolt) Update(fn func(lib.Store) error) error {
+	if b.tx != nil {
+		return fn(b)
+	}
+	tx, err := b.db.Bolt.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = fn(b.withTx(tx))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close closes the database
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
@@ -1,8 +1,17 @@
 package db
 
-import "github.com/asdine/storm"
-
-var (
-	//Conn is the shared pointer to the initialized database
-	Conn *storm.DB
+import (
+	"github.com/asdine/storm"
+	"github.com/gnur/demeter/lib"
 )
+
+// convertErr translates storm errors into the errors of lib.Store
+func convertErr(err error) error {
+	switch err {
+	case storm.ErrNotFound:
+		return lib.ErrNotFound
+	case storm.ErrAlreadyExists:
+		return lib.ErrExists
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/lib"
+)
+
+// addRuns adds a scrape result every 6 hours, starting at start
+func addRuns(t *testing.T, s lib.Store, hostID, n int, start time.Time) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		r := lib.ScrapeResult{
+			Start:     start.Add(time.Duration(i) * 6 * time.Hour),
+			Success:   i%2 == 0,
+			Results:   i,
+			Downloads: 1,
+		}
+		r.End = r.Start.Add(time.Minute)
+		if err := s.AddScrapeResult(hostID, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStoreScrapeHistory(t *testing.T) {
+	start := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)
+	eachStore(t, func(t *testing.T, s lib.Store) {
+		h := lib.Host{URL: "http://calibre.example.com"}
+		if err := s.SaveHost(&h); err != nil {
+			t.Fatal(err)
+		}
+		addRuns(t, s, h.ID, 8, start)
+		err := s.AddScrapeResult(h.ID, lib.ScrapeResult{Start: start})
+		if err != lib.ErrExists {
+			t.Errorf("adding a result with the same start: err = %v, want %v", err, lib.ErrExists)
+		}
+
+		last, _ := s.ScrapeResults(h.ID, 3)
+		if len(last) != 3 || last[0].Results != 5 || last[2].Results != 7 {
+			t.Errorf("last 3 results = %+v", last)
+		}
+		days, _ := s.ScrapeDays(h.ID)
+		if len(days) != 2 {
+			t.Fatalf("days = %+v, want 2", days)
+		}
+		want := lib.ScrapeDay{Date: "2024-03-14", Runs: 4, Fails: 2, MaxResults: 3, Downloads: 4, Duration: 4 * time.Minute}
+		if days[0] != want {
+			t.Errorf("first day = %+v, want %+v", days[0], want)
+		}
+
+		removed, err := s.PruneScrapeResults(h.ID, 2, start.Add(30*time.Hour))
+		if err != nil || removed != 5 {
+			t.Errorf("pruned %d, %v, want 5", removed, err)
+		}
+		all, _ := s.ScrapeResults(h.ID, 0)
+		if len(all) != 3 || all[0].Results != 5 {
+			t.Errorf("results after prune = %+v", all)
+		}
+		if kept, _ := s.ScrapeDays(h.ID); len(kept) != 2 || kept[0] != want {
+			t.Errorf("days after prune = %+v", kept)
+		}
+
+		removed, err = s.ClearScrapeResults(h.ID)
+		if err != nil || removed != 3 {
+			t.Errorf("cleared %d, %v, want 3", removed, err)
+		}
+		if days, _ = s.ScrapeDays(h.ID); len(days) != 0 {
+			t.Errorf("days after clear = %+v", days)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gnur/demeter/lib"
+	bolt "go.etcd.io/bbolt"
+)
+
+// compactTxSize is the number of bytes that is copied in a single transaction while compacting
+const compactTxSize = 64 * 1024 * 1024
+
+// backupPrefix starts the file name of every scheduled backup, the rest is the time it was made
+const backupPrefix = "demeter-"
+
+// backupTimeFormat is used in the file names of backups
+const backupTimeFormat = "20060102-150405"
+
+// ErrInTransaction is returned by maintenance that can't run inside a transaction
+var ErrInTransaction = errors.New("not possible inside a transaction")
+
+// Backup writes a consistent copy of the database to path while it stays in use
+func (b *Bolt) Backup(path string) error {
+	err := b.view(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// Check verifies the integrity of all pages and buckets of the database file
+func (b *Bolt) Check() []error {
+	var errs []error
+	err := b.view(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Compact rewrites the database into a fresh file, which drops all free pages, and
+// replaces the database with it. It returns the size of the file before and after.
+func (b *Bolt) Compact() (int64, int64, error) {
+	if b.tx != nil {
+		return 0, 0, ErrInTransaction
+	}
+	src, err := os.Stat(b.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	//a fresh file, so a compaction that was interrupted before can't leave its pages in this one
+	f, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".compact-*")
+	if err != nil {
+		return 0, 0, err
+	}
+	tmp := f.Name()
+	f.Close()
+	err = os.Chmod(tmp, src.Mode())
+	if err != nil {
+		os.Remove(tmp)
+		return 0, 0, err
+	}
+	dst, err := bolt.Open(tmp, src.Mode(), nil)
+	if err != nil {
+		os.Remove(tmp)
+		return 0, 0, err
+	}
+	err = bolt.Compact(dst, b.db.Bolt, compactTxSize)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return src.Size(), 0, err
+	}
+
+	err = b.db.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return src.Size(), 0, err
+	}
+	err = os.Rename(tmp, b.path)
+	if err != nil {
+		os.Remove(tmp)
+	}
+	//the original file is opened again when the rename failed
+	s, oerr := openStorm(b.path)
+	if oerr != nil {
+		return src.Size(), 0, fmt.Errorf("could not reopen database: %w", oerr)
+	}
+	b.node = s
+	b.db = s
+	if err != nil {
+		return src.Size(), 0, err
+	}
+	dstInfo, err := os.Stat(b.path)
+	if err != nil {
+		return src.Size(), 0, err
+	}
+	return src.Size(), dstInfo.Size(), nil
+}
+
+// BackupDir returns the directory scheduled backups are written to
+func (b *Bolt) BackupDir(s lib.BackupSchedule) string {
+	if s.Dir != "" {
+		return s.Dir
+	}
+	return filepath.Join(filepath.Dir(b.path), "backups")
+}
+
+// Backups returns the paths of all scheduled backups, oldest first
+func (b *Bolt) Backups(s lib.BackupSchedule) ([]string, error) {
+	dir := b.BackupDir(s)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, e := range entries {
+		if e.IsDir() || !isBackupName(e.Name()) {
+			continue
+		}
+		backups = append(backups, filepath.Join(dir, e.Name()))
+	}
+	//the time in the name sorts in the order the backups were made
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// ScheduledBackup makes a backup when the last scheduled backup is older than the interval
+// of the schedule and removes the backups that are no longer kept. It returns the path of
+// the new backup, which is empty when no backup was due.
+func (b *Bolt) ScheduledBackup(s lib.BackupSchedule, now time.Time) (string, error) {
+	if s.EveryHours <= 0 {
+		return "", nil
+	}
+	backups, err := b.Backups(s)
+	if err != nil {
+		return "", err
+	}
+	if len(backups) > 0 {
+		last, err := backupTime(backups[len(backups)-1])
+		if err == nil && now.Sub(last) < s.Interval() {
+			return "", nil
+		}
+	}
+	dir := b.BackupDir(s)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, backupPrefix+now.Format(backupTimeFormat)+".db")
+	err = b.Backup(path)
+	if err != nil {
+		return "", err
+	}
+	backups = append(backups, path)
+	if s.Keep <= 0 || len(backups) <= s.Keep {
+		return path, nil
+	}
+	for _, old := range backups[:len(backups)-s.Keep] {
+		err = os.Remove(old)
+		if err != nil {
+			return path, err
+		}
+	}
+	return path, nil
+}
+
+// isBackupName returns true if name is the file name of a scheduled backup
+func isBackupName(name string) bool {
+	return strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, ".db")
+}
+
+// backupTime returns the time a scheduled backup was made from its file name
+func backupTime(path string) (time.Time, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), backupPrefix), ".db")
+	return time.ParseInLocation(backupTimeFormat, name, time.Local)
+}
@@ -0,0 +1,114 @@
+package db_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "demeter.db")
+	b, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	err = b.Update(func(tx lib.Store) error {
+		for i := 0; i < 2000; i++ {
+			book := lib.Book{Hash: fmt.Sprintf("book%d", i), Title: strings.Repeat("a long title ", 20)}
+			if err := tx.SaveBook(&book); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Update(func(tx lib.Store) error {
+		for i := 1; i <= 2000; i++ {
+			if i%8 == 0 {
+				continue
+			}
+			if err := tx.DeleteBook(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//an interrupted compaction must not end up in the database
+	err = os.WriteFile(path+".compact", []byte("left over"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, after, err := b.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after >= before {
+		t.Errorf("compacted from %d to %d bytes", before, after)
+	}
+	books, err := b.Books()
+	if err != nil || len(books) != 250 {
+		t.Errorf("books after compacting = %d, %v, want 250", len(books), err)
+	}
+	if errs := b.Check(); len(errs) != 0 {
+		t.Errorf("compacted database is corrupt: %v", errs)
+	}
+	files, _ := filepath.Glob(path + ".compact-*")
+	if len(files) != 0 {
+		t.Errorf("temporary files were left: %v", files)
+	}
+}
+
+func TestScheduledBackup(t *testing.T) {
+	dir := t.TempDir()
+	b, err := db.Open(filepath.Join(dir, "demeter.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	s := lib.BackupSchedule{EveryHours: 24, Keep: 2}
+	now := time.Now()
+
+	if path, _ := b.ScheduledBackup(lib.BackupSchedule{}, now); path != "" {
+		t.Errorf("backup %s was made without a schedule", path)
+	}
+	for i, c := range []struct {
+		at   time.Time
+		made bool
+	}{
+		{now, true},
+		{now.Add(time.Hour), false},
+		{now.Add(25 * time.Hour), true},
+		{now.Add(50 * time.Hour), true},
+	} {
+		path, err := b.ScheduledBackup(s, c.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if made := path != ""; made != c.made {
+			t.Errorf("backup %d made = %v, want %v", i, made, c.made)
+		}
+	}
+	backups, _ := b.Backups(s)
+	if len(backups) != 2 || filepath.Dir(backups[0]) != filepath.Join(dir, "backups") {
+		t.Errorf("backups = %v, want the last 2", backups)
+	}
+	restored, err := db.Open(backups[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.Close()
+}
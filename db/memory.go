@@ -0,0 +1,849 @@
+package db
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gnur/demeter/lib"
+)
+
+var _ lib.Store = &Memory{}
+
+// Memory is a lib.Store that keeps everything in memory, nothing is persisted
+type Memory struct {
+	mu   *sync.RWMutex
+	data *memoryData
+}
+
+type memoryData struct {
+	hosts       map[int]lib.Host
+	books       map[int]lib.Book
+	checked     map[lib.CheckedID]bool
+	aliases     map[string]lib.Alias
+	catalog     map[string]lib.CatalogEntry
+	libraries   map[string]lib.CalibreLibrary
+	trash       map[int]lib.TrashedBook
+	events      map[int]lib.HostEvent
+	checkpoints map[int]lib.ScrapeCheckpoint
+	queue       map[int]lib.QueuedDownload
+	scrapes     map[int][]lib.ScrapeResult
+	days        map[int]map[string]lib.ScrapeDay
+	meta        map[string][]byte
+	lastHost    int
+	lastBook    int
+	lastTrash   int
+	lastEvent   int
+	lastQueued  int
+}
+
+// NewMemory returns an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		mu: &sync.RWMutex{},
+		data: &memoryData{
+			hosts:       make(map[int]lib.Host),
+			books:       make(map[int]lib.Book),
+			checked:     make(map[lib.CheckedID]bool),
+			aliases:     make(map[string]lib.Alias),
+			catalog:     make(map[string]lib.CatalogEntry),
+			libraries:   make(map[string]lib.CalibreLibrary),
+			trash:       make(map[int]lib.TrashedBook),
+			events:      make(map[int]lib.HostEvent),
+			checkpoints: make(map[int]lib.ScrapeCheckpoint),
+			queue:       make(map[int]lib.QueuedDownload),
+			scrapes:     make(map[int][]lib.ScrapeResult),
+			days:        make(map[int]map[string]lib.ScrapeDay),
+			meta:        make(map[string][]byte),
+		},
+	}
+}
+
+// clone returns a copy of d. Records are values and the scrape history is never modified in place, so only the maps are copied.
+func (d *memoryData) clone() *memoryData {
+	c := *d
+	c.hosts = make(map[int]lib.Host, len(d.hosts))
+	for k, v := range d.hosts {
+		c.hosts[k] = v
+	}
+	c.books = make(map[int]lib.Book, len(d.books))
+	for k, v := range d.books {
+		c.books[k] = v
+	}
+	c.checked = make(map[lib.CheckedID]bool, len(d.checked))
+	for k, v := range d.checked {
+		c.checked[k] = v
+	}
+	c.aliases = make(map[string]lib.Alias, len(d.aliases))
+	for k, v := range d.aliases {
+		c.aliases[k] = v
+	}
+	c.catalog = make(map[string]lib.CatalogEntry, len(d.catalog))
+	for k, v := range d.catalog {
+		c.catalog[k] = v
+	}
+	c.libraries = make(map[string]lib.CalibreLibrary, len(d.libraries))
+	for k, v := range d.libraries {
+		c.libraries[k] = v
+	}
+	c.trash = make(map[int]lib.TrashedBook, len(d.trash))
+	for k, v := range d.trash {
+		c.trash[k] = v
+	}
+	c.events = make(map[int]lib.HostEvent, len(d.events))
+	for k, v := range d.events {
+		c.events[k] = v
+	}
+	c.checkpoints = make(map[int]lib.ScrapeCheckpoint, len(d.checkpoints))
+	for k, v := range d.checkpoints {
+		c.checkpoints[k] = v
+	}
+	c.queue = make(map[int]lib.QueuedDownload, len(d.queue))
+	for k, v := range d.queue {
+		c.queue[k] = v
+	}
+	c.scrapes = make(map[int][]lib.ScrapeResult, len(d.scrapes))
+	for k, v := range d.scrapes {
+		c.scrapes[k] = v
+	}
+	c.days = make(map[int]map[string]lib.ScrapeDay, len(d.days))
+	for k, v := range d.days {
+		c.days[k] = v
+	}
+	c.meta = make(map[string][]byte, len(d.meta))
+	for k, v := range d.meta {
+		c.meta[k] = v
+	}
+	return &c
+}
+
+// Hosts returns all hosts
+func (m *Memory) Hosts() ([]lib.Host, error) {
+	return m.findHosts(func(h lib.Host) bool {
+		return true
+	}), nil
+}
+
+// ActiveHosts returns all hosts that are active
+func (m *Memory) ActiveHosts() ([]lib.Host, error) {
+	return m.findHosts(func(h lib.Host) bool {
+		return h.Active
+	}), nil
+}
+
+func (m *Memory) findHosts(match func(lib.Host) bool) []lib.Host {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var hosts []lib.Host
+	for _, h := range m.data.hosts {
+		if match(h) {
+			hosts = append(hosts, h)
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].ID < hosts[j].ID
+	})
+	return hosts
+}
+
+// Host returns the host with the given ID
+func (m *Memory) Host(id int) (lib.Host, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	h, ok := m.data.hosts[id]
+	if !ok {
+		return h, lib.ErrNotFound
+	}
+	return h, nil
+}
+
+// HostByURL returns the host with the given url
+func (m *Memory) HostByURL(url string) (lib.Host, error) {
+	hosts := m.findHosts(func(h lib.Host) bool {
+		return h.URL == url
+	})
+	if len(hosts) == 0 {
+		return lib.Host{}, lib.ErrNotFound
+	}
+	return hosts[0], nil
+}
+
+// SaveHost creates a host, or replaces it if it has an ID
+func (m *Memory) SaveHost(h *lib.Host) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, other := range m.data.hosts {
+		if other.URL == h.URL && other.ID != h.ID {
+			return lib.ErrExists
+		}
+	}
+	if h.ID == 0 {
+		m.data.lastHost++
+		h.ID = m.data.lastHost
+	} else if h.ID > m.data.lastHost {
+		m.data.lastHost = h.ID
+	}
+	m.data.hosts[h.ID] = *h
+	return nil
+}
+
+// DeleteHost removes a host
+func (m *Memory) DeleteHost(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data.hosts[id]; !ok {
+		return lib.ErrNotFound
+	}
+	delete(m.data.hosts, id)
+	return nil
+}
+
+// Books returns all books
+func (m *Memory) Books() ([]lib.Book, error) {
+	return m.findBooks(func(b lib.Book) bool {
+		return true
+	}), nil
+}
+
+// BookByHash returns the book with the given hash
+func (m *Memory) BookByHash(hash string) (lib.Book, error) {
+	return m.oneBook(func(b lib.Book) bool {
+		return b.Hash == hash
+	})
+}
+
+// BookByUUID returns the first book with the given calibre uuid
+func (m *Memory) BookByUUID(uuid string) (lib.Book, error) {
+	return m.oneBook(func(b lib.Book) bool {
+		return b.UUID == uuid
+	})
+}
+
+// BookByFileHash returns the first book with the given file hash
+func (m *Memory) BookByFileHash(fileHash string) (lib.Book, error) {
+	return m.oneBook(func(b lib.Book) bool {
+		return b.FileHash == fileHash
+	})
+}
+
+// BookByPath returns the first book that is stored in path
+func (m *Memory) BookByPath(path string) (lib.Book, error) {
+	return m.oneBook(func(b lib.Book) bool {
+		return b.Path == path
+	})
+}
+
+// BooksInSeries returns all books with the given series key
+func (m *Memory) BooksInSeries(key string) ([]lib.Book, error) {
+	return m.findBooks(func(b lib.Book) bool {
+		return b.SeriesKey == key
+	}), nil
+}
+
+func (m *Memory) oneBook(match func(lib.Book) bool) (lib.Book, error) {
+	books := m.findBooks(match)
+	if len(books) == 0 {
+		return lib.Book{}, lib.ErrNotFound
+	}
+	return books[0], nil
+}
+
+func (m *Memory) findBooks(match func(lib.Book) bool) []lib.Book {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var books []lib.Book
+	for _, b := range m.data.books {
+		if match(b) {
+			books = append(books, b)
+		}
+	}
+	sort.Slice(books, func(i, j int) bool {
+		return books[i].ID < books[j].ID
+	})
+	return books
+}
+
+// SaveBook creates a book, or replaces it if it has an ID
+func (m *Memory) SaveBook(b *lib.Book) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, other := range m.data.books {
+		if other.Hash == b.Hash && other.ID != b.ID {
+			return lib.ErrExists
+		}
+	}
+	if b.ID == 0 {
+		m.data.lastBook++
+		b.ID = m.data.lastBook
+	} else if b.ID > m.data.lastBook {
+		m.data.lastBook = b.ID
+	}
+	m.data.books[b.ID] = *b
+	return nil
+}
+
+// DeleteBook removes a book
+func (m *Memory) DeleteBook(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data.books[id]; !ok {
+		return lib.ErrNotFound
+	}
+	delete(m.data.books, id)
+	return nil
+}
+
+// IsChecked returns true if a book ID of a host has been checked before
+func (m *Memory) IsChecked(hostID, bookID int) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.data.checked[lib.CheckedID{HostID: hostID, BookID: bookID}], nil
+}
+
+// MarkChecked marks a book ID of a host as checked
+func (m *Memory) MarkChecked(hostID, bookID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.checked[lib.CheckedID{HostID: hostID, BookID: bookID}] = true
+	return nil
+}
+
+// CheckedIDs returns all checked book IDs of all hosts
+func (m *Memory) CheckedIDs() ([]lib.CheckedID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	checked := make([]lib.CheckedID, 0, len(m.data.checked))
+	for c := range m.data.checked {
+		checked = append(checked, c)
+	}
+	sort.Slice(checked, func(i, j int) bool {
+		if checked[i].HostID == checked[j].HostID {
+			return checked[i].BookID < checked[j].BookID
+		}
+		return checked[i].HostID < checked[j].HostID
+	})
+	return checked, nil
+}
+
+// ClearChecked removes all checked book IDs of a host and returns how many were removed
+func (m *Memory) ClearChecked(hostID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed := 0
+	for c := range m.data.checked {
+		if c.HostID == hostID {
+			delete(m.data.checked, c)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// AddScrapeResult adds a scrape result to the history of a host and to the aggregate of its day
+func (m *Memory) AddScrapeResult(hostID int, r lib.ScrapeResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data.hosts[hostID]; !ok {
+		return lib.ErrNotFound
+	}
+	old := m.data.scrapes[hostID]
+	for _, other := range old {
+		if other.Start.Equal(r.Start) {
+			return lib.ErrExists
+		}
+	}
+	//slices and day maps are replaced instead of modified, clones share them
+	results := make([]lib.ScrapeResult, len(old), len(old)+1)
+	copy(results, old)
+	results = append(results, r)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Start.Before(results[j].Start)
+	})
+	m.data.scrapes[hostID] = results
+
+	days := make(map[string]lib.ScrapeDay, len(m.data.days[hostID])+1)
+	for k, v := range m.data.days[hostID] {
+		days[k] = v
+	}
+	date := lib.ScrapeDate(r.Start)
+	days[date] = days[date].Add(r)
+	m.data.days[hostID] = days
+	return nil
+}
+
+// ScrapeResults returns the last n scrape results of a host, oldest first
+func (m *Memory) ScrapeResults(hostID, n int) ([]lib.ScrapeResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	results := m.data.scrapes[hostID]
+	if n > 0 && n < len(results) {
+		results = results[len(results)-n:]
+	}
+	return append([]lib.ScrapeResult(nil), results...), nil
+}
+
+// ScrapeDays returns the daily aggregates of the scrape results of a host, oldest first
+func (m *Memory) ScrapeDays(hostID int) ([]lib.ScrapeDay, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var days []lib.ScrapeDay
+	for _, day := range m.data.days[hostID] {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Date < days[j].Date
+	})
+	return days, nil
+}
+
+// PruneScrapeResults removes the scrape results of a host that are not among the last keepRuns and started before keepSince
+func (m *Memory) PruneScrapeResults(hostID, keepRuns int, keepSince time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	results := m.data.scrapes[hostID]
+	var kept []lib.ScrapeResult
+	for i, r := range results {
+		if len(results)-i <= keepRuns || !r.Start.Before(keepSince) {
+			kept = append(kept, r)
+		}
+	}
+	m.data.scrapes[hostID] = kept
+	return len(results) - len(kept), nil
+}
+
+// ClearScrapeResults removes all scrape results and daily aggregates of a host and returns how many results were removed
+func (m *Memory) ClearScrapeResults(hostID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed := len(m.data.scrapes[hostID])
+	delete(m.data.scrapes, hostID)
+	delete(m.data.days, hostID)
+	return removed, nil
+}
+
+// AddHostEvent adds a change of the health of a host to its history
+func (m *Memory) AddHostEvent(e *lib.HostEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e.ID == 0 {
+		m.data.lastEvent++
+		e.ID = m.data.lastEvent
+	} else if e.ID > m.data.lastEvent {
+		m.data.lastEvent = e.ID
+	}
+	m.data.events[e.ID] = *e
+	return nil
+}
+
+// HostEvents returns the health changes of a host, oldest first
+func (m *Memory) HostEvents(hostID int) ([]lib.HostEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var events []lib.HostEvent
+	for _, e := range m.data.events {
+		if e.HostID == hostID {
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+	return events, nil
+}
+
+// DeleteHostEvent removes a health change from the history of its host
+func (m *Memory) DeleteHostEvent(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data.events[id]; !ok {
+		return lib.ErrNotFound
+	}
+	delete(m.data.events, id)
+	return nil
+}
+
+// Checkpoint returns the checkpoint of the scrape of a host
+func (m *Memory) Checkpoint(hostID int) (lib.ScrapeCheckpoint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	cp, ok := m.data.checkpoints[hostID]
+	if !ok {
+		return cp, lib.ErrNotFound
+	}
+	return cp, nil
+}
+
+// Checkpoints returns the checkpoints of all hosts
+func (m *Memory) Checkpoints() ([]lib.ScrapeCheckpoint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var cps []lib.ScrapeCheckpoint
+	for _, cp := range m.data.checkpoints {
+		cps = append(cps, cp)
+	}
+	sort.Slice(cps, func(i, j int) bool {
+		return cps[i].HostID < cps[j].HostID
+	})
+	return cps, nil
+}
+
+// SaveCheckpoint creates or replaces the checkpoint of the scrape of a host, the lists of the
+// checkpoint are copied because a scrape keeps appending to them
+func (m *Memory) SaveCheckpoint(cp *lib.ScrapeCheckpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := *cp
+	c.IDs = append([]int(nil), cp.IDs...)
+	c.New = append([]int(nil), cp.New...)
+	m.data.checkpoints[cp.HostID] = c
+	return nil
+}
+
+// DeleteCheckpoint removes the checkpoint of the scrape of a host
+func (m *Memory) DeleteCheckpoint(hostID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data.checkpoints[hostID]; !ok {
+		return lib.ErrNotFound
+	}
+	delete(m.data.checkpoints, hostID)
+	return nil
+}
+
+// Alias returns the alias with the given key
+func (m *Memory) Alias(key string) (lib.Alias, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.data.aliases[key]
+	if !ok {
+		return a, lib.ErrNotFound
+	}
+	return a, nil
+}
+
+// Aliases returns all aliases
+func (m *Memory) Aliases() ([]lib.Alias, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var aliases []lib.Alias
+	for _, a := range m.data.aliases {
+		aliases = append(aliases, a)
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].Key < aliases[j].Key
+	})
+	return aliases, nil
+}
+
+// SaveAlias creates or replaces an alias
+func (m *Memory) SaveAlias(a *lib.Alias) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.aliases[a.Key] = *a
+	return nil
+}
+
+// DeleteAlias removes an alias
+func (m *Memory) DeleteAlias(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data.aliases[key]; !ok {
+		return lib.ErrNotFound
+	}
+	delete(m.data.aliases, key)
+	return nil
+}
+
+// CatalogEntry returns a single entry of a catalog snapshot
+func (m *Memory) CatalogEntry(id string) (lib.CatalogEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.data.catalog[id]
+	if !ok {
+		return e, lib.ErrNotFound
+	}
+	return e, nil
+}
+
+// Catalog returns the catalog snapshot of a host
+func (m *Memory) Catalog(hostID int) ([]lib.CatalogEntry, error) {
+	return m.findCatalog(func(e lib.CatalogEntry) bool {
+		return e.HostID == hostID
+	}), nil
+}
+
+// CatalogEntries returns the catalog snapshots of all hosts
+func (m *Memory) CatalogEntries() ([]lib.CatalogEntry, error) {
+	return m.findCatalog(func(e lib.CatalogEntry) bool {
+		return true
+	}), nil
+}
+
+// CatalogInSeries returns all catalog entries with the given series key
+func (m *Memory) CatalogInSeries(key string) ([]lib.CatalogEntry, error) {
+	return m.findCatalog(func(e lib.CatalogEntry) bool {
+		return e.SeriesKey == key
+	}), nil
+}
+
+func (m *Memory) findCatalog(match func(lib.CatalogEntry) bool) []lib.CatalogEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var entries []lib.CatalogEntry
+	for _, e := range m.data.catalog {
+		if match(e) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.Compare(entries[i].ID, entries[j].ID) < 0
+	})
+	return entries
+}
+
+// SaveCatalogEntry creates or replaces a catalog entry
+func (m *Memory) SaveCatalogEntry(e *lib.CatalogEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.catalog[e.ID] = *e
+	return nil
+}
+
+// DeleteCatalogEntry removes a catalog entry
+func (m *Memory) DeleteCatalogEntry(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data.catalog[id]; !ok {
+		return lib.ErrNotFound
+	}
+	delete(m.data.catalog, id)
+	return nil
+}
+
+// CalibreLibrary returns the import state of a local calibre library
+func (m *Memory) CalibreLibrary(path string) (lib.CalibreLibrary, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	l, ok := m.data.libraries[path]
+	if !ok {
+		return l, lib.ErrNotFound
+	}
+	return l, nil
+}
+
+// CalibreLibraries returns the import state of all local calibre libraries
+func (m *Memory) CalibreLibraries() ([]lib.CalibreLibrary, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var libs []lib.CalibreLibrary
+	for _, l := range m.data.libraries {
+		libs = append(libs, l)
+	}
+	sort.Slice(libs, func(i, j int) bool {
+		return libs[i].Path < libs[j].Path
+	})
+	return libs, nil
+}
+
+// SaveCalibreLibrary creates or replaces the import state of a local calibre library
+func (m *Memory) SaveCalibreLibrary(l *lib.CalibreLibrary) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.libraries[l.Path] = *l
+	return nil
+}
+
+// QueuedDownload returns the queued download with the given ID
+func (m *Memory) QueuedDownload(id int) (lib.QueuedDownload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d, ok := m.data.queue[id]
+	if !ok {
+		return d, lib.ErrNotFound
+	}
+	return d, nil
+}
+
+// QueuedDownloadByHash returns the queued download of the book with the given hash
+func (m *Memory) QueuedDownloadByHash(hash string) (lib.QueuedDownload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, d := range m.data.queue {
+		if d.Hash == hash {
+			return d, nil
+		}
+	}
+	return lib.QueuedDownload{}, lib.ErrNotFound
+}
+
+// Queue returns all queued downloads, oldest first
+func (m *Memory) Queue() ([]lib.QueuedDownload, error) {
+	return m.queued(func(lib.QueuedDownload) bool { return true }), nil
+}
+
+// HostQueue returns the queued downloads of a host, oldest first
+func (m *Memory) HostQueue(hostID int) ([]lib.QueuedDownload, error) {
+	return m.queued(func(d lib.QueuedDownload) bool { return d.HostID == hostID }), nil
+}
+
+func (m *Memory) queued(match func(lib.QueuedDownload) bool) []lib.QueuedDownload {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var queue []lib.QueuedDownload
+	for _, d := range m.data.queue {
+		if match(d) {
+			queue = append(queue, d)
+		}
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		return queue[i].ID < queue[j].ID
+	})
+	return queue
+}
+
+// SaveQueuedDownload creates a queued download, or replaces it if it has an ID
+func (m *Memory) SaveQueuedDownload(d *lib.QueuedDownload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, other := range m.data.queue {
+		if other.Hash == d.Hash && other.ID != d.ID {
+			return lib.ErrExists
+		}
+	}
+	if d.ID == 0 {
+		m.data.lastQueued++
+		d.ID = m.data.lastQueued
+	} else if d.ID > m.data.lastQueued {
+		m.data.lastQueued = d.ID
+	}
+	m.data.queue[d.ID] = *d
+	return nil
+}
+
+// DeleteQueuedDownload removes a download from the queue
+func (m *Memory) DeleteQueuedDownload(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data.queue[id]; !ok {
+		return lib.ErrNotFound
+	}
+	delete(m.data.queue, id)
+	return nil
+}
+
+// TrashedBook returns the trashed book with the given trash ID
+func (m *Memory) TrashedBook(id int) (lib.TrashedBook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.data.trash[id]
+	if !ok {
+		return t, lib.ErrNotFound
+	}
+	return t, nil
+}
+
+// Trash returns all trashed books, oldest first
+func (m *Memory) Trash() ([]lib.TrashedBook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var trash []lib.TrashedBook
+	for _, t := range m.data.trash {
+		trash = append(trash, t)
+	}
+	sort.Slice(trash, func(i, j int) bool {
+		return trash[i].ID < trash[j].ID
+	})
+	return trash, nil
+}
+
+// SaveTrashedBook creates a trashed book, or replaces it if it has an ID
+func (m *Memory) SaveTrashedBook(t *lib.TrashedBook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t.ID == 0 {
+		m.data.lastTrash++
+		t.ID = m.data.lastTrash
+	} else if t.ID > m.data.lastTrash {
+		m.data.lastTrash = t.ID
+	}
+	m.data.trash[t.ID] = *t
+	return nil
+}
+
+// DeleteTrashedBook removes a book from the trash
+func (m *Memory) DeleteTrashedBook(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data.trash[id]; !ok {
+		return lib.ErrNotFound
+	}
+	delete(m.data.trash, id)
+	return nil
+}
+
+// Meta reads a metadata value into v
+func (m *Memory) Meta(key string, v interface{}) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	raw, ok := m.data.meta[key]
+	if !ok {
+		return lib.ErrNotFound
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// SetMeta stores a metadata value
+func (m *Memory) SetMeta(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.meta[key] = raw
+	return nil
+}
+
+// SchemaVersion returns the current schema version, a memory store never holds older data
+func (m *Memory) SchemaVersion() (int, error) {
+	return schemaVersion(), nil
+}
+
+// PendingMigrations returns nothing, a memory store is always up to date
+func (m *Memory) PendingMigrations() ([]lib.Migration, error) {
+	return nil, nil
+}
+
+// Migrate does nothing, a memory store is always up to date
+func (m *Memory) Migrate() (string, []lib.Migration, error) {
+	return "", nil, nil
+}
+
+// View runs fn on the store, every read is consistent on its own
+func (m *Memory) View(fn func(lib.Store) error) error {
+	return fn(m)
+}
+
+// Update runs fn on a copy of the store, the copy replaces the store when fn succeeds.
+// Like a bbolt write transaction only a single update runs at a time.
+func (m *Memory) Update(fn func(lib.Store) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx := &Memory{
+		mu:   &sync.RWMutex{},
+		data: m.data.clone(),
+	}
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	m.data = tx.data
+	return nil
+}
+
+// Close does nothing, everything is lost when the store is no longer used
+func (m *Memory) Close() error {
+	return nil
+}
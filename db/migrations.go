@@ -0,0 +1,215 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gnur/demeter/lib"
+)
+
+const schemaKey = "schema"
+
+// ErrSchemaTooNew is returned when the database was written by a newer version of demeter
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of demeter supports")
+
+// migration upgrades the database to the schema version of its lib.Migration
+type migration struct {
+	lib.Migration
+	run func(tx *Bolt) error
+}
+
+// migrations holds all migrations in the order they have to be applied,
+// the version of every migration is one higher than the one before it
+var migrations = []migration{
+	{
+		Migration: lib.Migration{Version: 1, Name: "store checked ids in a bucket per host"},
+		run:       migrateCheckedIDs,
+	},
+	{
+		Migration: lib.Migration{Version: 2, Name: "move scrape history out of hosts"},
+		run:       migrateScrapeHistory,
+	},
+	{
+		Migration: lib.Migration{Version: 3, Name: "turn off inactive hosts"},
+		run:       migrateDisabledHosts,
+	},
+}
+
+// schemaVersion is the schema version of a database after all migrations have run
+func schemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version of the schema the stored data is in
+func (b *Bolt) SchemaVersion() (int, error) {
+	var version int
+	err := b.Meta(schemaKey, &version)
+	if err == lib.ErrNotFound {
+		return 0, nil
+	}
+	return version, err
+}
+
+// PendingMigrations returns the migrations that have not been applied yet, oldest first
+func (b *Bolt) PendingMigrations() ([]lib.Migration, error) {
+	version, err := b.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version > schemaVersion() {
+		return nil, fmt.Errorf("%w: version %d, supported %d", ErrSchemaTooNew, version, schemaVersion())
+	}
+	var pending []lib.Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate backs up the database and applies all pending migrations, each in its own transaction
+func (b *Bolt) Migrate() (string, []lib.Migration, error) {
+	pending, err := b.PendingMigrations()
+	if err != nil || len(pending) == 0 {
+		return "", nil, err
+	}
+	version, err := b.SchemaVersion()
+	if err != nil {
+		return "", nil, err
+	}
+	backup := fmt.Sprintf("%s.v%d-%s.bak", b.path, version, time.Now().Format("20060102-150405"))
+	err = b.Backup(backup)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not back up database: %w", err)
+	}
+
+	var applied []lib.Migration
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		err = b.Update(func(tx lib.Store) error {
+			err := m.run(tx.(*Bolt))
+			if err != nil {
+				return err
+			}
+			return tx.SetMeta(schemaKey, m.Version)
+		})
+		if err != nil {
+			return backup, applied, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m.Migration)
+	}
+	return backup, applied, nil
+}
+
+// migrateCheckedIDs moves the "hostID_bookID" keys of the checked_ids bucket into a bucket per host
+func migrateCheckedIDs(b *Bolt) error {
+	tx := b.tx
+	old := tx.Bucket([]byte(legacyCheckedBucket))
+	if old == nil {
+		return nil
+	}
+	checked, err := tx.CreateBucketIfNotExists([]byte(checkedBucket))
+	if err != nil {
+		return err
+	}
+	err = old.ForEach(func(k, v []byte) error {
+		var hostID, bookID int
+		_, err := fmt.Sscanf(string(k), "%d_%d", &hostID, &bookID)
+		if err != nil {
+			return nil
+		}
+		hb, err := checked.CreateBucketIfNotExists(hostKey(hostID))
+		if err != nil {
+			return err
+		}
+		return hb.Put(bookKey(bookID), checkedValue)
+	})
+	if err != nil {
+		return err
+	}
+	return tx.DeleteBucket([]byte(legacyCheckedBucket))
+}
+
+// legacyHost holds the scrape history that used to be stored in every host
+type legacyHost struct {
+	ID            int
+	ScrapeResults []lib.ScrapeResult
+}
+
+// migrateScrapeHistory moves the scrape results of every host into the scrape history buckets
+func migrateScrapeHistory(b *Bolt) error {
+	bucket := b.tx.Bucket([]byte("Host"))
+	if bucket == nil {
+		return nil
+	}
+	var hosts []legacyHost
+	codec := b.db.Codec()
+	err := bucket.ForEach(func(k, v []byte) error {
+		//nested buckets hold the storm indexes
+		if v == nil {
+			return nil
+		}
+		var h legacyHost
+		err := codec.Unmarshal(v, &h)
+		hosts = append(hosts, h)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, legacy := range hosts {
+		for _, r := range legacy.ScrapeResults {
+			err = b.AddScrapeResult(legacy.ID, r)
+			if err != nil && err != lib.ErrExists {
+				return err
+			}
+		}
+		//storing the host again drops the history from its record
+		h, err := b.Host(legacy.ID)
+		if err != nil {
+			return err
+		}
+		err = b.SaveHost(&h)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateDisabledHosts turns off the hosts that were disabled before the health state machine existed,
+// so they are not probed and enabled again against the choice of the user
+func migrateDisabledHosts(b *Bolt) error {
+	hosts, err := b.Hosts()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, h := range hosts {
+		if h.Active || h.Health != "" {
+			continue
+		}
+		//there is no telling why these hosts were disabled, so they stay off until they are enabled by hand
+		h.Health = lib.HealthOff
+		h.DisabledSince = now
+		err = b.SaveHost(&h)
+		if err != nil {
+			return err
+		}
+		err = b.AddHostEvent(&lib.HostEvent{
+			HostID: h.ID,
+			Time:   now,
+			From:   lib.HealthHealthy,
+			To:     lib.HealthOff,
+			Reason: "disabled before health tracking",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/lib"
+	bolt "go.etcd.io/bbolt"
+)
+
+// baselineDB creates a database the way demeter stored it before schema versions existed
+func baselineDB(t *testing.T) string {
+	t.Helper()
+	// Host is named like the baseline struct, storm uses the name as bucket name
+	type Host struct {
+		ID            int    `storm:"id,increment"`
+		URL           string `storm:"unique"`
+		Active        bool
+		ScrapeResults []lib.ScrapeResult
+	}
+	path := filepath.Join(t.TempDir(), "demeter.db")
+	s, err := openStorm(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	start := time.Date(2024, 3, 14, 15, 0, 0, 0, time.UTC)
+	err = s.Save(&Host{
+		URL:    "http://calibre.example.com",
+		Active: true,
+		ScrapeResults: []lib.ScrapeResult{
+			{Start: start, End: start.Add(time.Minute), Success: true, Results: 3},
+			{Start: start.Add(time.Hour), End: start.Add(time.Hour + time.Minute), Results: 4},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Save(&Host{URL: "http://disabled.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Bolt.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(legacyCheckedBucket))
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"1_10", "1_11", "2_10"} {
+			if err = b.Put([]byte(k), []byte{0xc3}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	b, err := Open(baselineDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	pending, err := b.PendingMigrations()
+	if err != nil || len(pending) != len(migrations) {
+		t.Fatalf("pending = %+v, %v, want all migrations", pending, err)
+	}
+	backup, applied, err := b.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+	if _, err = os.Stat(backup); err != nil {
+		t.Errorf("no backup was made: %v", err)
+	}
+	if v, _ := b.SchemaVersion(); v != schemaVersion() {
+		t.Errorf("schema version = %d, want %d", v, schemaVersion())
+	}
+
+	for _, c := range []lib.CheckedID{{HostID: 1, BookID: 10}, {HostID: 1, BookID: 11}, {HostID: 2, BookID: 10}} {
+		if found, _ := b.IsChecked(c.HostID, c.BookID); !found {
+			t.Errorf("checked id %+v was lost", c)
+		}
+	}
+	h, err := b.HostByURL("http://calibre.example.com")
+	if err != nil || !h.Active {
+		t.Fatalf("host = %+v, %v", h, err)
+	}
+	results, _ := b.ScrapeResults(h.ID, 0)
+	if len(results) != 2 || results[1].Results != 4 {
+		t.Errorf("scrape results = %+v", results)
+	}
+
+	if h.State() != lib.HealthHealthy {
+		t.Errorf("active host is %s, want healthy", h.State())
+	}
+	disabled, err := b.HostByURL("http://disabled.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disabled.State() != lib.HealthOff || disabled.DisabledSince.IsZero() {
+		t.Errorf("inactive host is %s since %v, want off", disabled.State(), disabled.DisabledSince)
+	}
+	events, _ := b.HostEvents(disabled.ID)
+	if len(events) != 1 || events[0].To != lib.HealthOff {
+		t.Errorf("events of the inactive host = %+v", events)
+	}
+
+	backup, applied, err = b.Migrate()
+	if err != nil || backup != "" || len(applied) != 0 {
+		t.Errorf("second migrate = %s, %+v, %v, want nothing", backup, applied, err)
+	}
+}
+
+func TestMigrateFailureRollsBack(t *testing.T) {
+	b, err := Open(baselineDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	failed := errors.New("failed")
+	saved := migrations
+	defer func() {
+		migrations = saved
+	}()
+	migrations = append([]migration{}, saved...)
+	migrations[1].run = func(tx *Bolt) error {
+		return failed
+	}
+
+	_, applied, err := b.Migrate()
+	if !errors.Is(err, failed) {
+		t.Fatalf("err = %v, want %v", err, failed)
+	}
+	if len(applied) != 1 {
+		t.Errorf("applied %d migrations, want 1", len(applied))
+	}
+	if v, _ := b.SchemaVersion(); v != 1 {
+		t.Errorf("schema version = %d, want 1", v)
+	}
+}
+
+func TestNewDatabaseIsCurrent(t *testing.T) {
+	b, err := Open(filepath.Join(t.TempDir(), "demeter.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	pending, err := b.PendingMigrations()
+	if err != nil || len(pending) != 0 {
+		t.Errorf("pending = %+v, %v, want none", pending, err)
+	}
+
+	err = b.SetMeta(schemaKey, schemaVersion()+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.PendingMigrations(); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("err = %v, want %v", err, ErrSchemaTooNew)
+	}
+}
@@ -0,0 +1,166 @@
+package db_test
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+// eachStore runs fn against a new bbolt store and a new in-memory store
+func eachStore(t *testing.T, fn func(t *testing.T, s lib.Store)) {
+	t.Run("bolt", func(t *testing.T) {
+		b, err := db.Open(filepath.Join(t.TempDir(), "demeter.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		fn(t, b)
+	})
+	t.Run("memory", func(t *testing.T) {
+		fn(t, db.NewMemory())
+	})
+}
+
+func TestStoreHosts(t *testing.T) {
+	eachStore(t, func(t *testing.T, s lib.Store) {
+		a := lib.Host{URL: "http://a.example.com", Active: true}
+		b := lib.Host{URL: "http://b.example.com"}
+		for _, h := range []*lib.Host{&a, &b} {
+			if err := s.SaveHost(h); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if a.ID == 0 || a.ID == b.ID {
+			t.Fatalf("ids = %d, %d", a.ID, b.ID)
+		}
+		dup := lib.Host{URL: a.URL}
+		if err := s.SaveHost(&dup); err != lib.ErrExists {
+			t.Errorf("saving a duplicate url: err = %v, want %v", err, lib.ErrExists)
+		}
+
+		got, err := s.HostByURL(b.URL)
+		if err != nil || got.ID != b.ID {
+			t.Errorf("host by url = %+v, %v", got, err)
+		}
+		active, _ := s.ActiveHosts()
+		if len(active) != 1 || active[0].ID != a.ID {
+			t.Errorf("active hosts = %+v", active)
+		}
+		if err = s.DeleteHost(a.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = s.Host(a.ID); err != lib.ErrNotFound {
+			t.Errorf("deleted host: err = %v, want %v", err, lib.ErrNotFound)
+		}
+	})
+}
+
+func TestStoreBooks(t *testing.T) {
+	eachStore(t, func(t *testing.T, s lib.Store) {
+		b := lib.Book{
+			Hash:      "herbertdune",
+			SeriesKey: "herbertdune",
+			FileHash:  "abc",
+			Path:      "/books/herbertdune.epub",
+			UUID:      "uuid-dune",
+			Languages: []string{"eng"},
+		}
+		if err := s.SaveBook(&b); err != nil {
+			t.Fatal(err)
+		}
+		for name, find := range map[string]func() (lib.Book, error){
+			"hash":      func() (lib.Book, error) { return s.BookByHash("herbertdune") },
+			"uuid":      func() (lib.Book, error) { return s.BookByUUID("uuid-dune") },
+			"file hash": func() (lib.Book, error) { return s.BookByFileHash("abc") },
+			"path":      func() (lib.Book, error) { return s.BookByPath("/books/herbertdune.epub") },
+		} {
+			got, err := find()
+			if err != nil || !reflect.DeepEqual(got, b) {
+				t.Errorf("book by %s = %+v, %v", name, got, err)
+			}
+		}
+		series, _ := s.BooksInSeries("herbertdune")
+		if len(series) != 1 {
+			t.Errorf("books in series = %+v", series)
+		}
+		if _, err := s.BookByHash("herbertmessiah"); err != lib.ErrNotFound {
+			t.Errorf("missing book: err = %v, want %v", err, lib.ErrNotFound)
+		}
+		dup := lib.Book{Hash: "herbertdune"}
+		if err := s.SaveBook(&dup); err != lib.ErrExists {
+			t.Errorf("saving a duplicate hash: err = %v, want %v", err, lib.ErrExists)
+		}
+		if err := s.DeleteBook(b.ID); err != nil {
+			t.Fatal(err)
+		}
+		if books, _ := s.Books(); len(books) != 0 {
+			t.Errorf("books after delete = %+v", books)
+		}
+	})
+}
+
+func TestStoreCheckedIDs(t *testing.T) {
+	eachStore(t, func(t *testing.T, s lib.Store) {
+		for _, c := range []lib.CheckedID{{HostID: 1, BookID: 10}, {HostID: 1, BookID: 11}, {HostID: 2, BookID: 10}} {
+			if err := s.MarkChecked(c.HostID, c.BookID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if found, _ := s.IsChecked(1, 11); !found {
+			t.Error("checked id was not found")
+		}
+		if found, _ := s.IsChecked(2, 11); found {
+			t.Error("id of another host was found")
+		}
+		n, err := s.ClearChecked(1)
+		if err != nil || n != 2 {
+			t.Errorf("cleared %d, %v, want 2", n, err)
+		}
+		checked, _ := s.CheckedIDs()
+		if !reflect.DeepEqual(checked, []lib.CheckedID{{HostID: 2, BookID: 10}}) {
+			t.Errorf("checked ids = %+v", checked)
+		}
+	})
+}
+
+func TestStoreTransactions(t *testing.T) {
+	eachStore(t, func(t *testing.T, s lib.Store) {
+		failed := errors.New("failed")
+		err := s.Update(func(tx lib.Store) error {
+			if err := tx.SaveBook(&lib.Book{Hash: "herbertdune"}); err != nil {
+				return err
+			}
+			if _, err := tx.BookByHash("herbertdune"); err != nil {
+				t.Errorf("book is not visible within its transaction: %v", err)
+			}
+			return failed
+		})
+		if err != failed {
+			t.Fatalf("err = %v, want %v", err, failed)
+		}
+		if _, err = s.BookByHash("herbertdune"); err != lib.ErrNotFound {
+			t.Errorf("book of a failed transaction: err = %v, want %v", err, lib.ErrNotFound)
+		}
+
+		err = s.Update(func(tx lib.Store) error {
+			return tx.SetMeta("key", "value")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v string
+		err = s.View(func(tx lib.Store) error {
+			return tx.Meta("key", &v)
+		})
+		if err != nil || v != "value" {
+			t.Errorf("meta = %q, %v", v, err)
+		}
+		if err = s.Meta("other", &v); err != lib.ErrNotFound {
+			t.Errorf("missing meta: err = %v, want %v", err, lib.ErrNotFound)
+		}
+	})
+}
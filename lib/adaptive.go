@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Adaptive moves the interval of a host between Min and Max depending on how often its
+// catalog changes, so every scrape is expected to find about one change. Hosts with an
+// interval or cron expression of their own are not adapted.
+type Adaptive struct {
+	Enabled bool   `json:"enabled"`
+	Min     string `json:"min"`
+	Max     string `json:"max"`
+	// Runs is the number of recent scrapes the change rate is measured over
+	Runs int `json:"runs"`
+}
+
+// minAdaptiveRuns is the number of successful scrapes that is needed to measure a change rate
+const minAdaptiveRuns = 3
+
+// DefaultAdaptive returns the adaptive interval settings that are used when none are configured
+func DefaultAdaptive() Adaptive {
+	return Adaptive{
+		Min:  "1h",
+		Max:  "168h",
+		Runs: 10,
+	}
+}
+
+// Validate returns an error when the settings can't be used
+func (a Adaptive) Validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	min, err := time.ParseDuration(a.Min)
+	if err != nil {
+		return fmt.Errorf("invalid min: %w", err)
+	}
+	max, err := time.ParseDuration(a.Max)
+	if err != nil {
+		return fmt.Errorf("invalid max: %w", err)
+	}
+	if min <= 0 || max < min {
+		return errors.New("min should be positive and not larger than max")
+	}
+	if a.Runs < minAdaptiveRuns {
+		return fmt.Errorf("runs should be at least %d", minAdaptiveRuns)
+	}
+	return nil
+}
+
+// Interval returns the interval for a host with the given scrape results, oldest first,
+// together with the reason it was chosen. It returns 0 when there is not enough history.
+func (a Adaptive) Interval(results []ScrapeResult) (time.Duration, string) {
+	min, _ := time.ParseDuration(a.Min)
+	max, _ := time.ParseDuration(a.Max)
+	var runs []ScrapeResult
+	for _, r := range results {
+		if r.Success {
+			runs = append(runs, r)
+		}
+	}
+	if len(runs) > a.Runs {
+		runs = runs[len(runs)-a.Runs:]
+	}
+	if len(runs) < minAdaptiveRuns {
+		return 0, fmt.Sprintf("not enough history, %d of %d scrapes", len(runs), minAdaptiveRuns)
+	}
+
+	//the new books of the first run were added before the measured period
+	changes := 0
+	for i := 1; i < len(runs); i++ {
+		changes += runChanges(runs[i-1], runs[i])
+	}
+	period := runs[len(runs)-1].Start.Sub(runs[0].Start)
+	if changes == 0 || period <= 0 {
+		return max, fmt.Sprintf("no changes in the last %d scrapes", len(runs))
+	}
+	interval := period / time.Duration(changes)
+	perDay := float64(changes) / period.Hours() * 24
+	reason := fmt.Sprintf("%.1f changes per day over the last %d scrapes", perDay, len(runs))
+	if interval < min {
+		return min, reason + ", limited to the minimum"
+	}
+	if interval > max {
+		return max, reason + ", limited to the maximum"
+	}
+	return interval.Round(time.Minute), reason
+}
+
+// runChanges returns the number of changes a scrape found since the scrape before it,
+// removed books only show up in the total of the catalog
+func runChanges(prev, r ScrapeResult) int {
+	changes := r.Results
+	if prev.Total > 0 && r.Total > 0 {
+		diff := r.Total - prev.Total
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > changes {
+			changes = diff
+		}
+	}
+	return changes
+}
+
+// adapt updates the adapted interval of a host with its scrape history
+func (p Policy) adapt(s Store, h *Host) {
+	if !p.Adaptive.Enabled {
+		return
+	}
+	results, err := s.ScrapeResults(h.ID, 0)
+	if err != nil {
+		return
+	}
+	h.AdaptedInterval, h.IntervalReason = p.Adaptive.Interval(results)
+}
@@ -0,0 +1,113 @@
+package lib_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/lib"
+)
+
+// runs returns successful scrape results a day apart that found the given number of new books
+func runs(newBooks ...int) []lib.ScrapeResult {
+	start := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)
+	var results []lib.ScrapeResult
+	for i, n := range newBooks {
+		results = append(results, lib.ScrapeResult{
+			Start:   start.AddDate(0, 0, i),
+			Success: true,
+			Results: n,
+		})
+	}
+	return results
+}
+
+func TestAdaptiveInterval(t *testing.T) {
+	a := lib.DefaultAdaptive()
+	a.Enabled = true
+
+	tests := []struct {
+		name    string
+		results []lib.ScrapeResult
+		want    time.Duration
+		reason  string
+	}{
+		{"not enough history", runs(5, 5), 0, "not enough history"},
+		{"no changes", runs(5, 0, 0, 0), 168 * time.Hour, "no changes"},
+		//the books of the first run don't count, 3 changes over 3 days
+		{"daily change", runs(100, 1, 1, 1), 24 * time.Hour, "1.0 changes per day"},
+		{"busy host", runs(0, 50, 50, 50), time.Hour, "limited to the minimum"},
+		{"quiet host", runs(0, 1, 0, 0, 0, 0, 0, 0, 0, 0), 168 * time.Hour, "limited to the maximum"},
+	}
+	for _, tt := range tests {
+		got, reason := a.Interval(tt.results)
+		if got != tt.want {
+			t.Errorf("%s: interval %v, want %v", tt.name, got, tt.want)
+		}
+		if !strings.Contains(reason, tt.reason) {
+			t.Errorf("%s: reason %q, want it to mention %q", tt.name, reason, tt.reason)
+		}
+	}
+}
+
+func TestAdaptiveIntervalCountsRemovals(t *testing.T) {
+	a := lib.DefaultAdaptive()
+	results := runs(0, 0, 0)
+	//two books were removed between the last scrapes, which only shows in the total
+	for i, total := range []int{10, 10, 8} {
+		results[i].Total = total
+	}
+	if got, _ := a.Interval(results); got != 24*time.Hour {
+		t.Errorf("interval %v, want 24h", got)
+	}
+}
+
+func TestAdaptiveIntervalRecentRuns(t *testing.T) {
+	a := lib.DefaultAdaptive()
+	a.Runs = 3
+	//only the last three scrapes count, and failed scrapes never do
+	results := append(runs(50, 50, 50), runs(0, 0, 0)...)
+	for i := 3; i < 6; i++ {
+		results[i].Start = results[i].Start.AddDate(0, 0, 3)
+	}
+	results = append(results, lib.ScrapeResult{Start: results[5].Start.Add(time.Hour), Results: 50})
+	if got, reason := a.Interval(results); got != 168*time.Hour {
+		t.Errorf("interval %v (%s), want the maximum", got, reason)
+	}
+}
+
+func TestAdaptiveValidate(t *testing.T) {
+	for _, a := range []lib.Adaptive{
+		{Enabled: true, Min: "soon", Max: "1h", Runs: 3},
+		{Enabled: true, Min: "2h", Max: "1h", Runs: 3},
+		{Enabled: true, Min: "0s", Max: "1h", Runs: 3},
+		{Enabled: true, Min: "1h", Max: "2h", Runs: 2},
+	} {
+		if err := a.Validate(); err == nil {
+			t.Errorf("%+v is valid, want an error", a)
+		}
+	}
+	if err := (lib.Adaptive{Min: "soon"}).Validate(); err != nil {
+		t.Errorf("disabled settings are checked: %v", err)
+	}
+}
+
+func TestPolicyUsesAdaptedInterval(t *testing.T) {
+	p := lib.Policy{
+		Schedule: lib.Schedule{Interval: "12h"},
+		Adaptive: lib.Adaptive{Enabled: true, Min: "1h", Max: "168h", Runs: 10},
+	}
+	h := lib.Host{ID: 1, AdaptedInterval: 48 * time.Hour}
+	if got := p.ScheduleFor(h).Interval; got != "48h0m0s" {
+		t.Errorf("interval %q, want the adapted 48h", got)
+	}
+	h.Schedule = lib.Schedule{Interval: "6h"}
+	if got := p.ScheduleFor(h).Interval; got != "6h" {
+		t.Errorf("interval %q, want the own interval of the host", got)
+	}
+	p.Adaptive.Enabled = false
+	h.Schedule = lib.Schedule{}
+	if got := p.ScheduleFor(h).Interval; got != "12h" {
+		t.Errorf("interval %q, want the default when adapting is disabled", got)
+	}
+}
@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// maxAliasDepth limits how many aliases are followed when resolving an author
+const maxAliasDepth = 5
+
+// ErrSelfAlias is returned when an alias would resolve to itself
+var ErrSelfAlias = errors.New("alias and canonical author are the same")
+
+// authorKey converts an author name into the key that is used to look up aliases
+func authorKey(author string) string {
+	author = fix(author, true, true)
+	author = removeAccents(strings.ToLower(author))
+	return alphaNumeric.ReplaceAllString(author, "")
+}
+
+// AddAlias stores alias as an alternative name for the canonical author. The stored books are
+// rehashed in the same transaction, so books that were stored under either name keep matching.
+func AddAlias(s Store, alias, canonical string) (Alias, error) {
+	a := newAlias(alias, canonical)
+	if a.Key == authorKey(a.Canonical) {
+		return a, ErrSelfAlias
+	}
+	err := s.Update(func(tx Store) error {
+		err := tx.SaveAlias(&a)
+		if err != nil {
+			return err
+		}
+		_, err = rehash(tx)
+		return err
+	})
+	return a, err
+}
+
+// ImportAliases stores a list of aliases and rehashes the stored books once, all in a single
+// transaction. Aliases that would resolve to themselves are skipped and returned.
+func ImportAliases(s Store, aliases []Alias) (added int, skipped []Alias, err error) {
+	err = s.Update(func(tx Store) error {
+		added = 0
+		skipped = nil
+		for _, in := range aliases {
+			a := newAlias(in.Name, in.Canonical)
+			if a.Key == authorKey(a.Canonical) {
+				skipped = append(skipped, a)
+				continue
+			}
+			err := tx.SaveAlias(&a)
+			if err != nil {
+				return err
+			}
+			added++
+		}
+		if added == 0 {
+			return nil
+		}
+		_, err := rehash(tx)
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return added, skipped, nil
+}
+
+func newAlias(alias, canonical string) Alias {
+	return Alias{
+		Key:       authorKey(alias),
+		Name:      strings.TrimSpace(alias),
+		Canonical: strings.TrimSpace(canonical),
+		Added:     time.Now(),
+	}
+}
+
+// RemoveAlias removes an alias from the database and rehashes the stored books in the same transaction
+func RemoveAlias(s Store, alias string) error {
+	return s.Update(func(tx Store) error {
+		err := tx.DeleteAlias(authorKey(alias))
+		if err != nil {
+			return err
+		}
+		_, err = rehash(tx)
+		return err
+	})
+}
+
+// ListAliases returns all aliases that are stored in the database
+func ListAliases(s Store) ([]Alias, error) {
+	return s.Aliases()
+}
+
+// ParseAliases reads an alias list, every line holds a single `alias = canonical` pair.
+// Empty lines and lines starting with # are ignored.
+func ParseAliases(r io.Reader) ([]Alias, error) {
+	var aliases []Alias
+	scanner := bufio.NewScanner(r)
+	lineNr := 0
+	for scanner.Scan() {
+		lineNr++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return aliases, fmt.Errorf("line %d: expected `alias = canonical`", lineNr)
+		}
+		aliases = append(aliases, Alias{
+			Name:      strings.TrimSpace(parts[0]),
+			Canonical: strings.TrimSpace(parts[1]),
+		})
+	}
+	return aliases, scanner.Err()
+}
+
+// WriteAliases writes aliases in the format that is understood by ParseAliases
+func WriteAliases(w io.Writer, aliases []Alias) error {
+	for _, a := range aliases {
+		_, err := fmt.Fprintf(w, "%s = %s\n", a.Name, a.Canonical)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveAuthor returns the canonical name for author, or author itself if no alias is known
+func resolveAuthor(s Store, author string) string {
+	for i := 0; i < maxAliasDepth; i++ {
+		a, err := s.Alias(authorKey(author))
+		if err != nil {
+			return author
+		}
+		author = fix(a.Canonical, true, true)
+	}
+	return author
+}
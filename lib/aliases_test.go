@@ -0,0 +1,146 @@
+package lib_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+// hashOf returns the hash a scrape would give a book by author and title
+func hashOf(t *testing.T, s lib.Store, author, title string) string {
+	t.Helper()
+	e, err := lib.Explain(s, lib.CalibreBook{
+		Authors: []string{author},
+		Title:   title,
+	})
+	if err != nil {
+		t.Fatalf("explain %s - %s: %v", author, title, err)
+	}
+	return e.Book.Hash
+}
+
+func TestAliasResolvesBeforeHashing(t *testing.T) {
+	s := db.NewMemory()
+	before := hashOf(t, s, "Robert Galbraith", "The Cuckoo's Calling")
+	canonical := hashOf(t, s, "J. K. Rowling", "The Cuckoo's Calling")
+	if before == canonical {
+		t.Fatalf("hashes of different authors are equal: %s", before)
+	}
+
+	_, err := lib.AddAlias(s, "Robert Galbraith", "J. K. Rowling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hashOf(t, s, "Robert Galbraith", "The Cuckoo's Calling"); got != canonical {
+		t.Errorf("hash with alias = %s, want %s", got, canonical)
+	}
+	if got := hashOf(t, s, "galbraith, robert", "The Cuckoo's Calling"); got != canonical {
+		t.Errorf("hash of reordered alias = %s, want %s", got, canonical)
+	}
+}
+
+func TestAddSelfAlias(t *testing.T) {
+	s := db.NewMemory()
+	_, err := lib.AddAlias(s, "Iain Banks", "iain banks")
+	if err != lib.ErrSelfAlias {
+		t.Fatalf("err = %v, want %v", err, lib.ErrSelfAlias)
+	}
+	aliases, _ := s.Aliases()
+	if len(aliases) != 0 {
+		t.Errorf("self alias was stored: %v", aliases)
+	}
+}
+
+func TestAliasChangesRehashBooks(t *testing.T) {
+	s := db.NewMemory()
+	b := lib.Book{
+		Author: "Robert Galbraith",
+		Title:  "The Cuckoo's Calling",
+		Hash:   hashOf(t, s, "Robert Galbraith", "The Cuckoo's Calling"),
+	}
+	if err := s.SaveBook(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := lib.AddAlias(s, "Robert Galbraith", "J. K. Rowling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical := hashOf(t, s, "J. K. Rowling", "The Cuckoo's Calling")
+	if _, err := s.BookByHash(canonical); err != nil {
+		t.Errorf("stored book was not rehashed after adding the alias: %v", err)
+	}
+
+	err = lib.RemoveAlias(s, "Robert Galbraith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.BookByHash(b.Hash); err != nil {
+		t.Errorf("stored book was not rehashed after removing the alias: %v", err)
+	}
+}
+
+func TestImportAliases(t *testing.T) {
+	s := db.NewMemory()
+	b := lib.Book{
+		Author: "Iain M. Banks",
+		Title:  "Consider Phlebas",
+		Hash:   hashOf(t, s, "Iain M. Banks", "Consider Phlebas"),
+	}
+	if err := s.SaveBook(&b); err != nil {
+		t.Fatal(err)
+	}
+	list := `# curated list
+Iain M. Banks = Iain Banks
+
+Robert Galbraith = J. K. Rowling
+Iain Banks = Iain Banks
+`
+	aliases, err := lib.ParseAliases(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, skipped, err := lib.ImportAliases(s, aliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 || len(skipped) != 1 {
+		t.Errorf("added %d and skipped %d, want 2 and 1", added, len(skipped))
+	}
+	if _, err := s.BookByHash(hashOf(t, s, "Iain Banks", "Consider Phlebas")); err != nil {
+		t.Errorf("stored book was not rehashed by the import: %v", err)
+	}
+}
+
+func TestAliasListRoundTrip(t *testing.T) {
+	in := []lib.Alias{
+		{Name: "Robert Galbraith", Canonical: "J. K. Rowling"},
+		{Name: "Iain M. Banks", Canonical: "Iain Banks"},
+	}
+	var buf bytes.Buffer
+	if err := lib.WriteAliases(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+	out, err := lib.ParseAliases(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != len(in) {
+		t.Fatalf("got %d aliases, want %d", len(out), len(in))
+	}
+	for i := range in {
+		if out[i].Name != in[i].Name || out[i].Canonical != in[i].Canonical {
+			t.Errorf("alias %d = %+v, want %+v", i, out[i], in[i])
+		}
+	}
+}
+
+func TestParseAliasesReportsLine(t *testing.T) {
+	_, err := lib.ParseAliases(strings.NewReader("a = b\n\nno separator\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("err = %v, want an error on line 3", err)
+	}
+}
@@ -1,42 +1,58 @@
 package lib
 
 import (
+	"context"
 	"net/url"
 )
 
-func (a *App) getIDSAsync(u url.URL, offset int, num int) ([]int, error) {
-	ch := make(chan GetIDSResponse)
-	a.Queues.IDS <- GetIDSRequest{
-		Num:    num,
-		Offset: offset,
-		U:      u,
-		Resp:   ch,
+func (a *App) getIDSAsync(ctx context.Context, hostID int, u url.URL, offset int, num int) ([]int, error) {
+	var ids []int
+	var err error
+	done := make(chan struct{})
+	serr := a.Pool.Submit(ctx, hostID, PriorityMetadata, func(ctx context.Context) {
+		ids, err = a.getIDS(ctx, u, offset, num)
+		close(done)
+	})
+	if serr != nil {
+		return nil, serr
 	}
-	resp := <-ch
-	close(ch)
-	return resp.IDs, resp.Err
+	<-done
+	return ids, err
 }
 
-func (a *App) getBooksAsync(u url.URL, ids []int) (BooksQueryResult, error) {
-	ch := make(chan GetBooksResponse)
-	a.Queues.Books <- GetBooksRequest{
-		IDs:  ids,
-		U:    u,
-		Resp: ch,
+func (a *App) getBooksAsync(ctx context.Context, hostID int, u url.URL, ids []int) (BooksQueryResult, error) {
+	var books BooksQueryResult
+	var err error
+	done := make(chan struct{})
+	serr := a.Pool.Submit(ctx, hostID, PriorityMetadata, func(ctx context.Context) {
+		books, err = a.getBooks(ctx, u, ids)
+		close(done)
+	})
+	if serr != nil {
+		return nil, serr
 	}
-	resp := <-ch
-	close(ch)
-	return resp.Books, resp.Err
+	<-done
+	return books, err
 }
 
-func (a *App) downloadBookAsync(url string, path string) error {
-	ch := make(chan DownloadBookResponse)
-	a.Queues.DlBook <- DownloadBookRequest{
-		URL:  url,
-		Path: path,
-		Resp: ch,
-	}
-	resp := <-ch
-	close(ch)
-	return resp.Err
+// downloadBookAsync queues a download, the result is sent on resp once a worker has made it.
+// The download is only made when the allowance isn't exhausted by then.
+func (a *App) downloadBookAsync(ctx context.Context, hostID int, prio Priority, url string, path string, allowance *Allowance, resp chan<- DownloadBookResponse) error {
+	return a.Pool.Submit(ctx, hostID, prio, func(ctx context.Context) {
+		if !allowance.Reserve() {
+			resp <- DownloadBookResponse{
+				Path: path,
+				Err:  ErrBudgetExhausted,
+			}
+			return
+		}
+		fileHash, size, err := a.downloadBook(ctx, url, path)
+		allowance.Spend(size, err == nil)
+		resp <- DownloadBookResponse{
+			Path:     path,
+			FileHash: fileHash,
+			Size:     size,
+			Err:      err,
+		}
+	})
 }
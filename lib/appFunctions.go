@@ -1,6 +1,9 @@
 package lib
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,15 +12,16 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"time"
 
-	"github.com/gnur/demeter/db"
+	log "github.com/sirupsen/logrus"
 )
 
-func (a *App) getBody(u string, v interface{}) error {
+func (a *App) getBody(ctx context.Context, u string, v interface{}) error {
 	c := http.Client{
 		Timeout: a.Timeout,
 	}
-	req, err := http.NewRequest(http.MethodGet, u, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return err
 	}
@@ -41,7 +45,7 @@ func (a *App) getBody(u string, v interface{}) error {
 	return nil
 }
 
-func (a *App) getIDS(u url.URL, offset int, num int) ([]int, error) {
+func (a *App) getIDS(ctx context.Context, u url.URL, offset int, num int) ([]int, error) {
 	v := url.Values{}
 	v.Set("num", strconv.Itoa(num))
 	v.Set("offset", strconv.Itoa(offset))
@@ -49,93 +53,128 @@ func (a *App) getIDS(u url.URL, offset int, num int) ([]int, error) {
 	u.RawQuery = v.Encode()
 
 	r := SearchResult{}
-	err := a.getBody(u.String(), &r)
+	err := a.getBody(ctx, u.String(), &r)
 	if err != nil {
 		return nil, err
 	}
 	return r.BookIds, nil
 }
 
-func (a *App) getBooks(u url.URL, ids []int) (BooksQueryResult, error) {
+func (a *App) getBooks(ctx context.Context, u url.URL, ids []int) (BooksQueryResult, error) {
 	u.Path = "/ajax/books"
 	v := url.Values{}
 	v.Set("ids", intSliceToString(ids))
 	u.RawQuery = v.Encode()
 
 	r := BooksQueryResult{}
-	err := a.getBody(u.String(), &r)
+	err := a.getBody(ctx, u.String(), &r)
 	return r, err
 }
 
-func (a *App) downloadBook(url string, path string) error {
+// downloadBook stores the book at url in path and returns the sha256 and size of the downloaded file
+func (a *App) downloadBook(ctx context.Context, url string, path string) (string, int64, error) {
 	c := http.Client{
 		Timeout: a.DownloadTimeout,
 	}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return "", 0, err
 	}
 	req.Header.Set("User-Agent", a.UserAgent)
 
 	response, err := c.Do(req)
 	if err != nil {
-		return err
+		return "", 0, err
 	}
 	defer response.Body.Close()
 	if response.StatusCode != 200 {
-		return fmt.Errorf("Got %d statuscode", response.StatusCode)
+		return "", 0, fmt.Errorf("Got %d statuscode", response.StatusCode)
 
 	}
 	file, err := os.Create(path)
-	defer file.Close()
 	if err != nil {
-		return err
+		return "", 0, err
 	}
-	_, err = io.Copy(file, response.Body)
+	defer file.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, h), response.Body)
 	if err != nil {
-		return err
+		//a cancelled or broken download leaves no partial file behind
+		file.Close()
+		os.Remove(path)
+		return "", size, err
 	}
 
-	return nil
+	return hex.EncodeToString(h.Sum(nil)), size, nil
 }
 
-func (a *App) getAllIDS(u url.URL) ([]int, error) {
-
-	ids := []int{}
+// getAllIDS retrieves the book ids of a host into the checkpoint, starting at the first page it doesn't
+// have yet. Pages that can't be retrieved are skipped and make the checkpoint incomplete, ctx.Err()
+// is returned when ctx is done before all pages are retrieved.
+func (a *App) getAllIDS(ctx context.Context, cp *ScrapeCheckpoint, u url.URL) error {
 	u.Path = "/ajax/search"
 
-	v := url.Values{}
-	v.Set("num", "0")
-	u.RawQuery = v.Encode()
+	if cp.IDOffset == 0 {
+		v := url.Values{}
+		v.Set("num", "0")
+		u.RawQuery = v.Encode()
 
-	r := SearchResult{}
-	err := a.getBody(u.String(), &r)
-	if err != nil {
-		return ids, err
+		r := SearchResult{}
+		err := a.getBody(ctx, u.String(), &r)
+		if err != nil {
+			return err
+		}
+		cp.Total = r.TotalNum
+		cp.Complete = true
 	}
 
-	for i := 0; i < r.TotalNum; i += a.StepSize {
-		stepIDs, err := a.getIDSAsync(u, i, a.StepSize)
-
+	for cp.IDOffset < cp.Total {
+		stepIDs, err := a.getIDSAsync(ctx, cp.HostID, u, cp.IDOffset, a.StepSize)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if err != nil {
-			continue
+			cp.Complete = false
 		}
-		ids = append(ids, stepIDs...)
-
+		cp.IDs = append(cp.IDs, stepIDs...)
+		cp.IDOffset += a.StepSize
+		a.saveCheckpoint(cp, false)
 	}
-	return ids, nil
+	return nil
 }
 
-func (a *App) filterOldIDs(ids []int, hostID int) (filtered []int) {
-	var checkID string
-	var found bool
-	for _, id := range ids {
-		checkID = fmt.Sprintf("%d_%d", hostID, id)
-		err := db.Conn.Get("checked_ids", checkID, &found)
-		if err != nil || !found {
-			db.Conn.Set("checked_ids", checkID, true)
-			filtered = append(filtered, id)
+// filterOldIDs keeps the IDs of the checkpoint that have not been checked before as its new IDs and marks
+// them as checked. All IDs are handled in a single transaction that stores the checkpoint as well, so an
+// ID is never marked without being in the checkpoint until it has been handled.
+func (a *App) filterOldIDs(cp *ScrapeCheckpoint) {
+	var filtered []int
+	err := a.Store.Update(func(tx Store) error {
+		filtered = nil
+		for _, id := range cp.IDs {
+			found, err := tx.IsChecked(cp.HostID, id)
+			if err != nil || !found {
+				filtered = append(filtered, id)
+			}
 		}
+		for _, id := range filtered {
+			err := tx.MarkChecked(cp.HostID, id)
+			if err != nil {
+				return err
+			}
+		}
+		cp.IDsDone = true
+		cp.New = filtered
+		cp.IDs = nil
+		cp.Updated = time.Now()
+		return tx.SaveCheckpoint(cp)
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"host": cp.HostID,
+			"err":  err,
+		}).Warning("Could not mark IDs as checked")
 	}
-	return
+	cp.IDsDone = true
+	cp.New = filtered
+	cp.IDs = nil
 }
@@ -1,17 +1,22 @@
 package lib
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
-	"os"
 	"path"
+	"strconv"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// Scrape performs the actual scrape
-func (a *App) Scrape(h *Host) (*ScrapeResult, error) {
+// Scrape performs the actual scrape, it resumes from the checkpoint of the host when there is one.
+// The books that are not in the database are added to the download queue, see Drain. When ctx is done
+// no new requests are made, the rest of the work is kept in the checkpoint and ctx.Err() is returned.
+// A page of books that can't be retrieved fails the scrape, the next scrape resumes from that page.
+func (a *App) Scrape(ctx context.Context, h *Host) (*ScrapeResult, error) {
 	parsed, err := url.Parse(h.URL)
 	if err != nil {
 		return nil, err
@@ -26,68 +31,141 @@ func (a *App) Scrape(h *Host) (*ScrapeResult, error) {
 		r.End = time.Now()
 	}()
 
-	err = os.MkdirAll(a.OutputDir, 0755)
-	if err != nil {
-		return &r, err
-	}
-
-	allIDs, err := a.getAllIDS(*parsed)
-	if err != nil {
-		return &r, err
+	cp := a.loadCheckpoint(h.ID)
+	if !cp.IDsDone {
+		err = a.getAllIDS(ctx, cp, *parsed)
+		if err != nil {
+			if ctx.Err() != nil {
+				a.interruptCheckpoint(ctx, cp)
+			}
+			return &r, err
+		}
+		if cp.Complete {
+			err = pruneCatalog(a.Store, h.ID, cp.IDs)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"host": h.URL,
+					"err":  err,
+				}).Warning("Could not prune catalog snapshot")
+			}
+		}
+		total := len(cp.IDs)
+		a.filterOldIDs(cp)
+		log.WithFields(log.Fields{
+			"host":  h.URL,
+			"total": total,
+			"new":   len(cp.New),
+		}).Info("Found results")
+		//a resumed scrape doesn't find the new books of the run that started it again
+		r.Results = len(cp.New)
 	}
-	ids := a.filterOldIDs(allIDs, h.ID)
-	log.WithFields(log.Fields{
-		"host":  h.URL,
-		"total": len(allIDs),
-		"new":   len(ids),
-	}).Info("Found results")
+	r.Total = cp.Total
 
-	i := 0
-	toDownload := 0
-	dlResultQueue := make(chan DownloadBookResponse, len(ids))
-	r.Results = len(ids)
-	for i < len(ids) {
-		max := i + a.StepSize
-		if max > len(ids) {
-			max = len(ids)
+	queued := 0
+	for cp.MetadataDone < len(cp.New) && ctx.Err() == nil {
+		max := cp.MetadataDone + a.StepSize
+		if max > len(cp.New) {
+			max = len(cp.New)
+		}
+		bs, err := a.getBooksAsync(ctx, h.ID, *parsed, cp.New[cp.MetadataDone:max])
+		if ctx.Err() != nil {
+			break
 		}
-		bs, err := a.getBooksAsync(*parsed, ids[i:max])
-		i += a.StepSize
 		if err != nil {
-			log.WithField("err", err).Error("Could not get books")
-			continue
+			return &r, a.failCheckpoint(cp, fmt.Errorf("could not get books: %w", err))
 		}
-		for _, b := range bs {
-			if present, hash := bookInDatabase(&b); !present {
-				if fPath, ok := b.MainFormat[a.Extension]; ok {
+		page, err := a.checkPage(bs)
+		if err != nil {
+			return &r, a.failCheckpoint(cp, fmt.Errorf("could not look up books: %w", err))
+		}
+		entries := make([]CatalogEntry, 0, len(page))
+		var downloads []QueuedDownload
+		for _, p := range page {
+			book := p.book
+			calibreID, err := strconv.Atoi(p.key)
+			if err != nil {
+				calibreID = p.calibre.ApplicationID
+			}
+			entries = append(entries, newCatalogEntry(h.ID, calibreID, book))
+			if !p.present {
+				if fPath, ok := p.calibre.MainFormat[a.Extension]; ok {
 					rawPath, err := url.QueryUnescape(fPath)
 					if err != nil {
 						continue
 					}
 					parsed.Path = rawPath
-					output := fmt.Sprintf("%s.%s", hash, a.Extension)
-					output = path.Join(a.OutputDir, output)
-					//TODO: dit in go routine
-					a.Queues.DlBook <- DownloadBookRequest{
-						URL:  parsed.String(),
-						Path: output,
-						Resp: dlResultQueue,
+					output := fmt.Sprintf("%s.%s", book.Hash, a.Extension)
+					book.SourceID = h.ID
+					prio := PriorityBulk
+					if p.wanted {
+						prio = PriorityWanted
 					}
-					toDownload++
+					downloads = append(downloads, QueuedDownload{
+						HostID:    h.ID,
+						CalibreID: calibreID,
+						Hash:      book.Hash,
+						URL:       parsed.String(),
+						Format:    a.Extension,
+						Path:      path.Join(a.OutputDir, output),
+						Priority:  prio,
+						State:     QueueWaiting,
+						Added:     time.Now(),
+						Book:      book,
+					})
 				}
 			}
 		}
-	}
-
-	// ######################################################
-	for i := 0; i < toDownload; i++ {
-		res := <-dlResultQueue
-		if res.Err == nil {
-			r.Downloads++
+		err = saveCatalog(a.Store, entries)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"host": h.URL,
+				"err":  err,
+			}).Warning("Could not store catalog snapshot")
 		}
+		added, err := a.enqueue(downloads)
+		if err != nil {
+			//the page is retrieved again when the scrape resumes
+			a.interruptCheckpoint(ctx, cp)
+			return &r, err
+		}
+		queued += added
+		cp.MetadataDone = max
+		a.saveCheckpoint(cp, false)
+	}
+	if queued > 0 {
+		log.WithFields(log.Fields{
+			"host":   h.URL,
+			"queued": queued,
+		}).Info("Queued downloads")
 	}
-	close(dlResultQueue)
 
+	if cp.MetadataDone < len(cp.New) {
+		a.interruptCheckpoint(ctx, cp)
+		return &r, ctx.Err()
+	}
+	err = a.Store.DeleteCheckpoint(h.ID)
+	if err != nil && err != ErrNotFound {
+		log.WithFields(log.Fields{
+			"host": h.URL,
+			"err":  err,
+		}).Warning("Could not remove scrape checkpoint")
+	}
 	return &r, nil
 
 }
+
+// failCheckpoint stores the checkpoint of a scrape that failed on its current page of books and returns err
+func (a *App) failCheckpoint(cp *ScrapeCheckpoint, err error) error {
+	cp.State = CheckpointFailed
+	a.saveCheckpoint(cp, true)
+	return err
+}
+
+// interruptCheckpoint stores the checkpoint of a scrape that was stopped, ctx tells why it was stopped
+func (a *App) interruptCheckpoint(ctx context.Context, cp *ScrapeCheckpoint) {
+	cp.State = CheckpointDeferred
+	if errors.Is(ctx.Err(), context.Canceled) {
+		cp.State = CheckpointInterrupted
+	}
+	a.saveCheckpoint(cp, true)
+}
@@ -1,57 +1,30 @@
 package lib
 
 import (
-	"net/url"
+	"sync"
 	"time"
 )
 
 // App holds all the config for the V2 demeter type
 type App struct {
+	Store           Store
+	Preloaded       *BookSet
 	UserAgent       string
+	OnDuplicate     string
 	Timeout         time.Duration
 	DownloadTimeout time.Duration
-	WorkerInterval  time.Duration
 	Extension       string
 	StepSize        int
 	OutputDir       string
-	Queues          WorkerQueues
-}
-
-// GetIDSRequest holds the information to retrieve the book ids from a calibre host
-type GetIDSRequest struct {
-	Num    int
-	Offset int
-	U      url.URL
-	Resp   chan GetIDSResponse
-}
-
-// GetIDSResponse is the response for IDS request
-type GetIDSResponse struct {
-	IDs []int
-	Err error
-}
-
-// GetBooksRequest converts IDs into books
-type GetBooksRequest struct {
-	IDs  []int
-	U    url.URL
-	Resp chan GetBooksResponse
-}
-
-// GetBooksResponse is the response
-type GetBooksResponse struct {
-	Books BooksQueryResult
-	Err   error
-}
-
-// DownloadBookRequest holds the request to DL a book
-type DownloadBookRequest struct {
-	URL  string
-	Path string
-	Resp chan DownloadBookResponse
+	Pool            *Pool
+	// inFlight holds the hashes of the books that drains are downloading right now
+	inFlight sync.Map
 }
 
 // DownloadBookResponse holds the result of a book dl
 type DownloadBookResponse struct {
-	Err error
+	Path     string
+	FileHash string
+	Size     int64
+	Err      error
 }
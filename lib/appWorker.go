@@ -1,63 +1,275 @@
 package lib
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// WorkerQueues holds all the queues that the worker can act upon
-type WorkerQueues struct {
-	IDS    chan GetIDSRequest
-	Books  chan GetBooksRequest
-	DlBook chan DownloadBookRequest
+// Priority is the class of a job, the workers take jobs of the lowest class first
+type Priority int
+
+const (
+	// PriorityMetadata is for ID pages and book metadata, scrapes wait for these before they can continue
+	PriorityMetadata Priority = iota
+	// PriorityManual is for downloads that were moved to the front of the queue by hand
+	PriorityManual
+	// PriorityWanted is for downloads of books that continue a series that is in the database
+	PriorityWanted
+	// PriorityBulk is for all other downloads
+	PriorityBulk
+	priorities
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityMetadata:
+		return "metadata"
+	case PriorityManual:
+		return "manual"
+	case PriorityWanted:
+		return "wanted"
+	case PriorityBulk:
+		return "bulk"
+	}
+	return fmt.Sprintf("priority %d", int(p))
+}
+
+// ErrPoolClosed is returned when a job is submitted to a pool that has been closed
+var ErrPoolClosed = errors.New("worker pool is closed")
+
+// job is a single request that a worker makes. A job whose context is done still runs,
+// the request then fails right away and the job can report that to whoever waits for it.
+type job struct {
+	ctx context.Context
+	run func(ctx context.Context)
+}
+
+// hostQueues holds the queued jobs of a priority class per host, the hosts take turns
+type hostQueues struct {
+	jobs map[int][]job
+	// hosts are the hosts with queued jobs, in the order they take turns
+	hosts []int
+	next  int
+	// slots bounds the number of queued jobs of the class
+	slots chan struct{}
+}
+
+func (q *hostQueues) push(hostID int, j job) {
+	if len(q.jobs[hostID]) == 0 {
+		q.hosts = append(q.hosts, hostID)
+	}
+	q.jobs[hostID] = append(q.jobs[hostID], j)
+}
+
+// pop returns the first job of the host whose turn it is
+func (q *hostQueues) pop() (job, bool) {
+	if len(q.hosts) == 0 {
+		return job{}, false
+	}
+	if q.next >= len(q.hosts) {
+		q.next = 0
+	}
+	hostID := q.hosts[q.next]
+	jobs := q.jobs[hostID]
+	j := jobs[0]
+	jobs[0] = job{}
+	if len(jobs) == 1 {
+		delete(q.jobs, hostID)
+		q.hosts = append(q.hosts[:q.next], q.hosts[q.next+1:]...)
+	} else {
+		q.jobs[hostID] = jobs[1:]
+		q.next++
+	}
+	return j, true
+}
+
+func (q *hostQueues) len() int {
+	n := 0
+	for _, jobs := range q.jobs {
+		n += len(jobs)
+	}
+	return n
 }
 
 // WorkerCounter counts all the work a worker did
 type WorkerCounter struct {
-	GetIDS   int
-	GetBooks int
-	DlBook   int
+	Metadata int
+	Manual   int
+	Wanted   int
+	Bulk     int
 	ID       int
 }
 
-// Worker is the only unit that actually makes requests
-func (a *App) Worker(id int, q WorkerQueues) {
-	c := WorkerCounter{
-		ID: id,
+func (c *WorkerCounter) add(p Priority) {
+	switch p {
+	case PriorityMetadata:
+		c.Metadata++
+	case PriorityManual:
+		c.Manual++
+	case PriorityWanted:
+		c.Wanted++
+	default:
+		c.Bulk++
+	}
+}
+
+// Pool is the only unit that actually makes requests. Its workers take metadata jobs before
+// downloads and manual and wanted downloads before the others, within a class the hosts take turns so
+// a single large host can't keep the others waiting. Every class holds a limited number of
+// jobs, submitting more blocks until a worker takes one.
+type Pool struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	classes  [priorities]*hostQueues
+	counters []WorkerCounter
+	closed   bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewPool starts a pool with the given number of workers that can queue queueSize jobs per class,
+// the work of the workers is logged every statsEvery
+func NewPool(workers, queueSize int, statsEvery time.Duration) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	p := &Pool{
+		counters: make([]WorkerCounter, workers),
+		done:     make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	for i := range p.classes {
+		p.classes[i] = &hostQueues{
+			jobs:  make(map[int][]job),
+			slots: make(chan struct{}, queueSize),
+		}
+	}
+	for i := range p.counters {
+		p.counters[i].ID = i
+		p.wg.Add(1)
+		go p.worker(i)
+	}
+	if statsEvery > 0 {
+		go p.logStats(statsEvery)
+	}
+	return p
+}
+
+// Submit queues a job of a host. It blocks while the class of the job is full,
+// ctx.Err() is returned when ctx is done before the job could be queued.
+func (p *Pool) Submit(ctx context.Context, hostID int, prio Priority, run func(ctx context.Context)) error {
+	if prio < 0 || prio >= priorities {
+		return fmt.Errorf("unknown priority %d", prio)
+	}
+	q := p.classes[prio]
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	ticker := time.Tick(a.WorkerInterval)
-	l := log.WithField("worker", fmt.Sprintf("worker_%02d", c.ID))
-	defer l.Info("Ending work routine")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		<-q.slots
+		return ErrPoolClosed
+	}
+	q.push(hostID, job{
+		ctx: ctx,
+		run: run,
+	})
+	p.cond.Signal()
+	return nil
+}
+
+// Close stops accepting jobs and waits until the workers have run all queued jobs and exited
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// take returns the next job for a worker, false is returned when the pool is closed and empty
+func (p *Pool) take(id int) (job, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	for {
-		select {
-		case re := <-q.IDS:
-			ids, err := a.getIDS(re.U, re.Offset, re.Num)
-			re.Resp <- GetIDSResponse{
-				IDs: ids,
-				Err: err,
-			}
-			c.GetIDS++
-		case re := <-q.Books:
-			books, err := a.getBooks(re.U, re.IDs)
-			re.Resp <- GetBooksResponse{
-				Books: books,
-				Err:   err,
-			}
-			c.GetBooks++
-		case re := <-q.DlBook:
-			err := a.downloadBook(re.URL, re.Path)
-			re.Resp <- DownloadBookResponse{
-				Err: err,
+		for prio, q := range p.classes {
+			if j, ok := q.pop(); ok {
+				<-q.slots
+				p.counters[id].add(Priority(prio))
+				return j, true
 			}
-			c.DlBook++
-		case _ = <-ticker:
-			l.WithFields(log.Fields{
-				"GetIDS":   c.GetIDS,
-				"GetBooks": c.GetBooks,
-				"DlBook":   c.DlBook,
-			}).Info("Worker update")
 		}
+		if p.closed {
+			return job{}, false
+		}
+		p.cond.Wait()
+	}
+}
+
+func (p *Pool) worker(id int) {
+	defer p.wg.Done()
+	l := log.WithField("worker", fmt.Sprintf("worker_%02d", id))
+	for {
+		j, ok := p.take(id)
+		if !ok {
+			break
+		}
+		j.run(j.ctx)
+	}
+	p.mu.Lock()
+	c := p.counters[id]
+	p.mu.Unlock()
+	l.WithFields(log.Fields{
+		"metadata": c.Metadata,
+		"manual":   c.Manual,
+		"wanted":   c.Wanted,
+		"bulk":     c.Bulk,
+	}).Debug("Ending work routine")
+}
+
+func (p *Pool) logStats(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		var c WorkerCounter
+		for _, w := range p.counters {
+			c.Metadata += w.Metadata
+			c.Manual += w.Manual
+			c.Wanted += w.Wanted
+			c.Bulk += w.Bulk
+		}
+		queued := log.Fields{}
+		for prio, q := range p.classes {
+			queued["queued_"+Priority(prio).String()] = q.len()
+		}
+		p.mu.Unlock()
+		log.WithFields(queued).WithFields(log.Fields{
+			"metadata": c.Metadata,
+			"manual":   c.Manual,
+			"wanted":   c.Wanted,
+			"bulk":     c.Bulk,
+		}).Info("Worker update")
 	}
 }
@@ -0,0 +1,151 @@
+package lib_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/lib"
+)
+
+// blockedPool returns a pool with a single worker that is busy until the returned function is called
+func blockedPool(t *testing.T, queueSize int) (*lib.Pool, func()) {
+	t.Helper()
+	p := lib.NewPool(1, queueSize, 0)
+	started := make(chan struct{})
+	gate := make(chan struct{})
+	err := p.Submit(context.Background(), 0, lib.PriorityMetadata, func(ctx context.Context) {
+		close(started)
+		<-gate
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	var once sync.Once
+	release := func() { once.Do(func() { close(gate) }) }
+	t.Cleanup(func() {
+		release()
+		p.Close()
+	})
+	return p, release
+}
+
+// recorder keeps the order in which jobs ran
+type recorder struct {
+	mu    sync.Mutex
+	order []string
+}
+
+func (r *recorder) job(name string) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		r.mu.Lock()
+		r.order = append(r.order, name)
+		r.mu.Unlock()
+	}
+}
+
+func (r *recorder) check(t *testing.T, want ...string) {
+	t.Helper()
+	if len(r.order) != len(want) {
+		t.Fatalf("ran %v, want %v", r.order, want)
+	}
+	for i := range want {
+		if r.order[i] != want[i] {
+			t.Fatalf("ran %v, want %v", r.order, want)
+		}
+	}
+}
+
+func TestPoolPriorities(t *testing.T) {
+	p, release := blockedPool(t, 10)
+	var r recorder
+	for _, j := range []struct {
+		prio lib.Priority
+		name string
+	}{
+		{lib.PriorityBulk, "bulk"},
+		{lib.PriorityWanted, "wanted"},
+		{lib.PriorityManual, "manual"},
+		{lib.PriorityMetadata, "metadata"},
+	} {
+		if err := p.Submit(context.Background(), 1, j.prio, r.job(j.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release()
+	p.Close()
+	r.check(t, "metadata", "manual", "wanted", "bulk")
+}
+
+func TestPoolHostsTakeTurns(t *testing.T) {
+	p, release := blockedPool(t, 10)
+	var r recorder
+	for _, j := range []struct {
+		host int
+		name string
+	}{
+		{1, "a1"}, {1, "a2"}, {1, "a3"}, {2, "b1"}, {3, "c1"}, {2, "b2"},
+	} {
+		if err := p.Submit(context.Background(), j.host, lib.PriorityBulk, r.job(j.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release()
+	p.Close()
+	r.check(t, "a1", "b1", "c1", "a2", "b2", "a3")
+}
+
+func TestPoolBoundedQueue(t *testing.T) {
+	p, release := blockedPool(t, 1)
+	var r recorder
+	if err := p.Submit(context.Background(), 1, lib.PriorityBulk, r.job("first")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := p.Submit(ctx, 1, lib.PriorityBulk, r.job("second"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("submit to a full class = %v, want the deadline", err)
+	}
+	//other classes have their own room
+	if err = p.Submit(context.Background(), 1, lib.PriorityWanted, r.job("wanted")); err != nil {
+		t.Errorf("submit to another class = %v", err)
+	}
+	release()
+	p.Close()
+	r.check(t, "wanted", "first")
+}
+
+func TestPoolClose(t *testing.T) {
+	p := lib.NewPool(4, 10, 0)
+	var r recorder
+	for i := 0; i < 3; i++ {
+		err := p.Submit(context.Background(), i, lib.PriorityBulk, r.job("bulk"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		p.Close()
+		p.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the workers didn't exit")
+	}
+	if len(r.order) != 3 {
+		t.Errorf("ran %d jobs before closing, want 3", len(r.order))
+	}
+	if err := p.Submit(context.Background(), 1, lib.PriorityBulk, r.job("late")); !errors.Is(err, lib.ErrPoolClosed) {
+		t.Errorf("submit after close = %v, want %v", err, lib.ErrPoolClosed)
+	}
+	if err := p.Submit(context.Background(), 1, lib.Priority(10), r.job("unknown")); err == nil {
+		t.Error("submit with an unknown priority succeeded")
+	}
+}
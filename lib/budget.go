@@ -0,0 +1,240 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExhausted is returned when a scrape stopped downloading because its budget ran out,
+// the downloads that are left are deferred to the next run
+var ErrBudgetExhausted = errors.New("budget exhausted")
+
+// Budget limits how much is downloaded, fields that are not set are not limited.
+// MaxBytes is a size like "500MB" or "2G", MaxDuration a duration like "2h".
+type Budget struct {
+	MaxDownloads int    `json:"max_downloads,omitempty"`
+	MaxBytes     string `json:"max_bytes,omitempty"`
+	MaxDuration  string `json:"max_duration,omitempty"`
+}
+
+// Budgets holds the budget of a whole run, the budget of every host in a run
+// and the budgets of single hosts by their URL, which replace the host budget
+type Budgets struct {
+	Run   Budget            `json:"run"`
+	Host  Budget            `json:"host"`
+	Hosts map[string]Budget `json:"hosts,omitempty"`
+}
+
+// Or fills the fields that are not set in b from def
+func (b Budget) Or(def Budget) Budget {
+	if b.MaxDownloads == 0 {
+		b.MaxDownloads = def.MaxDownloads
+	}
+	if b.MaxBytes == "" {
+		b.MaxBytes = def.MaxBytes
+	}
+	if b.MaxDuration == "" {
+		b.MaxDuration = def.MaxDuration
+	}
+	return b
+}
+
+// Validate returns an error when a field of the budget can't be used
+func (b Budget) Validate() error {
+	if b.MaxDownloads < 0 {
+		return errors.New("max_downloads can't be negative")
+	}
+	if b.MaxBytes != "" {
+		if _, err := ParseBytes(b.MaxBytes); err != nil {
+			return fmt.Errorf("invalid max_bytes: %w", err)
+		}
+	}
+	if b.MaxDuration != "" {
+		d, err := time.ParseDuration(b.MaxDuration)
+		if err != nil {
+			return fmt.Errorf("invalid max_duration: %w", err)
+		}
+		if d < 0 {
+			return errors.New("invalid max_duration: can't be negative")
+		}
+	}
+	return nil
+}
+
+// Validate returns an error when one of the budgets can't be used
+func (b Budgets) Validate() error {
+	if err := b.Run.Validate(); err != nil {
+		return fmt.Errorf("run: %w", err)
+	}
+	if err := b.Host.Validate(); err != nil {
+		return fmt.Errorf("host: %w", err)
+	}
+	for u, hb := range b.Hosts {
+		if err := hb.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", u, err)
+		}
+	}
+	return nil
+}
+
+// For returns the budget of a single host
+func (b Budgets) For(h Host) Budget {
+	return b.Hosts[h.URL].Or(b.Host)
+}
+
+// Duration returns the maximum duration of the budget, 0 means no limit
+func (b Budget) Duration() time.Duration {
+	d, _ := time.ParseDuration(b.MaxDuration)
+	return d
+}
+
+// String describes the budget
+func (b Budget) String() string {
+	var parts []string
+	if b.MaxDownloads > 0 {
+		parts = append(parts, fmt.Sprintf("%d downloads", b.MaxDownloads))
+	}
+	if b.MaxBytes != "" {
+		parts = append(parts, b.MaxBytes)
+	}
+	if b.MaxDuration != "" {
+		parts = append(parts, b.MaxDuration)
+	}
+	if len(parts) == 0 {
+		return "unlimited"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ParseBytes reads a size like "1500", "500KB", "20M" or "2GB", the units are powers of 1024
+func ParseBytes(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(v, "B")
+	mult := int64(1)
+	for i, unit := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(v, unit) {
+			v = strings.TrimSuffix(v, unit)
+			mult = 1 << (10 * uint(i+1))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size like 500MB", s)
+	}
+	return n * mult, nil
+}
+
+// FormatBytes formats a size with the largest unit that fits it
+func FormatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", f, units[i])
+}
+
+// Allowance is what is left of a budget while it is spent, a host allowance spends from
+// the allowance of its run as well. It is safe for concurrent use, a nil allowance is unlimited.
+type Allowance struct {
+	mu           sync.Mutex
+	parent       *Allowance
+	maxDownloads int
+	maxBytes     int64
+	downloads    int
+	// reserved are downloads that were started but didn't finish yet
+	reserved int
+	bytes    int64
+}
+
+// Start returns an allowance for the download limits of the budget, which spends from parent as well.
+// The duration of the budget is not tracked by the allowance, see WithDeadline.
+func (b Budget) Start(parent *Allowance) *Allowance {
+	maxBytes, _ := ParseBytes(b.MaxBytes)
+	return &Allowance{
+		parent:       parent,
+		maxDownloads: b.MaxDownloads,
+		maxBytes:     maxBytes,
+	}
+}
+
+// WithDeadline returns a context that is done when the duration of the budget has passed
+func (b Budget) WithDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d := b.Duration(); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Exhausted returns true if no more downloads can be started
+func (a *Allowance) Exhausted() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	exhausted := a.exhausted()
+	a.mu.Unlock()
+	return exhausted || a.parent.Exhausted()
+}
+
+func (a *Allowance) exhausted() bool {
+	if a.maxDownloads > 0 && a.downloads+a.reserved >= a.maxDownloads {
+		return true
+	}
+	return a.maxBytes > 0 && a.bytes >= a.maxBytes
+}
+
+// Reserve starts a download, it returns false when the budget is exhausted. Every reserved
+// download is ended by Spend. The size of a download is only known when it is done, so the
+// downloads that are running when the byte limit is reached can go over it.
+func (a *Allowance) Reserve() bool {
+	if a == nil {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.exhausted() {
+		return false
+	}
+	if !a.parent.Reserve() {
+		return false
+	}
+	a.reserved++
+	return true
+}
+
+// Spend ends a reserved download, a download that failed gives its reservation back
+func (a *Allowance) Spend(size int64, ok bool) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.reserved--
+	if ok {
+		a.downloads++
+		a.bytes += size
+	}
+	a.mu.Unlock()
+	a.parent.Spend(size, ok)
+}
+
+// Spent returns the number of downloads and bytes that were spent
+func (a *Allowance) Spent() (int, int64) {
+	if a == nil {
+		return 0, 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.downloads, a.bytes
+}
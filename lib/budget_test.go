@@ -0,0 +1,134 @@
+package lib_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+func TestParseBytes(t *testing.T) {
+	for in, want := range map[string]int64{
+		"1500":   1500,
+		"500KB":  500 << 10,
+		"20m":    20 << 20,
+		" 2GB ":  2 << 30,
+		"1T":     1 << 40,
+		"0":      0,
+		"100 MB": 100 << 20,
+	} {
+		got, err := lib.ParseBytes(in)
+		if err != nil || got != want {
+			t.Errorf("ParseBytes(%q) = %d, %v, want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "lots", "-5MB", "1.5GB"} {
+		if _, err := lib.ParseBytes(in); err == nil {
+			t.Errorf("ParseBytes(%q) succeeded", in)
+		}
+	}
+	for n, want := range map[int64]string{512: "512 B", 1536: "1.5 KB", 3 << 30: "3.0 GB"} {
+		if got := lib.FormatBytes(n); got != want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	b := lib.Budgets{
+		Host:  lib.Budget{MaxDownloads: 10, MaxDuration: "1h"},
+		Hosts: map[string]lib.Budget{"http://big.example.com": {MaxDownloads: 100}},
+	}
+	if got := b.For(lib.Host{URL: "http://big.example.com"}); got != (lib.Budget{MaxDownloads: 100, MaxDuration: "1h"}) {
+		t.Errorf("budget of a configured host %+v", got)
+	}
+	if got := b.For(lib.Host{URL: "http://small.example.com"}); got != b.Host {
+		t.Errorf("budget of another host %+v", got)
+	}
+	if err := b.Validate(); err != nil {
+		t.Error(err)
+	}
+	for _, bad := range []lib.Budget{{MaxDownloads: -1}, {MaxBytes: "lots"}, {MaxDuration: "-1h"}, {MaxDuration: "long"}} {
+		b.Hosts["http://big.example.com"] = bad
+		if err := b.Validate(); err == nil {
+			t.Errorf("%+v is valid, want an error", bad)
+		}
+	}
+	if s := (lib.Budget{}).String(); s != "unlimited" {
+		t.Errorf("empty budget is %q", s)
+	}
+}
+
+func TestAllowance(t *testing.T) {
+	run := lib.Budget{MaxDownloads: 3}.Start(nil)
+	host := lib.Budget{MaxBytes: "1KB"}.Start(run)
+
+	if !host.Reserve() {
+		t.Fatal("could not reserve a download")
+	}
+	//a failed download gives its reservation back
+	host.Spend(0, false)
+	for i := 0; i < 2; i++ {
+		if !host.Reserve() {
+			t.Fatalf("could not reserve download %d", i)
+		}
+		host.Spend(600, true)
+	}
+	if !host.Exhausted() || host.Reserve() {
+		t.Error("the byte limit of the host is not reached")
+	}
+	if run.Exhausted() {
+		t.Error("the run is exhausted after 2 of 3 downloads")
+	}
+	other := lib.Budget{}.Start(run)
+	if !other.Reserve() {
+		t.Fatal("another host could not use the rest of the run")
+	}
+	if !run.Exhausted() || !other.Exhausted() {
+		t.Error("a running download doesn't count towards the run")
+	}
+	other.Spend(10, true)
+	if n, bytes := run.Spent(); n != 3 || bytes != 1210 {
+		t.Errorf("run spent %d downloads and %d bytes", n, bytes)
+	}
+
+	var unlimited *lib.Allowance
+	if !unlimited.Reserve() || unlimited.Exhausted() {
+		t.Error("a nil allowance is limited")
+	}
+}
+
+func TestScrapeHostBudget(t *testing.T) {
+	f := newFakeHost(t, "Dune", "Dune Messiah", "Children of Dune")
+	s := db.NewMemory()
+	h := saveHosts(t, s, f.URL)[0]
+	a := testApp(t, s)
+	p := lib.Policy{
+		Health:  lib.DefaultHealthPolicy(),
+		Budgets: lib.Budgets{Hosts: map[string]lib.Budget{f.URL: {MaxDownloads: 2}}},
+	}
+
+	r, err := a.ScrapeHost(context.Background(), &h, p, nil)
+	if !errors.Is(err, lib.ErrBudgetExhausted) {
+		t.Fatalf("err = %v, want %v", err, lib.ErrBudgetExhausted)
+	}
+	if !r.Success || r.Downloads != 2 {
+		t.Errorf("result %+v, want 2 downloads in a successful scrape", r)
+	}
+	queue, _ := s.HostQueue(h.ID)
+	if len(queue) != 1 || queue[0].State != lib.QueueWaiting || queue[0].Attempts != 0 {
+		t.Errorf("queue %+v, want the deferred download waiting", queue)
+	}
+
+	//the run has room for a single download, which is the one that was deferred
+	run := lib.Budget{MaxDownloads: 1}.Start(nil)
+	r, err = a.DrainHost(context.Background(), &h, p, run)
+	if err != nil || r.Downloads != 1 {
+		t.Errorf("drain %+v, %v, want the deferred download", r, err)
+	}
+	if f.downloads != 3 {
+		t.Errorf("the host served %d downloads, want 3", f.downloads)
+	}
+}
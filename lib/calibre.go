@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"time"
+)
+
+const calibreBooksQuery = `
+SELECT b.id, b.title, COALESCE(b.series_index, 0), COALESCE(b.uuid, ''), b.path,
+	COALESCE((SELECT a.name FROM books_authors_link l JOIN authors a ON a.id = l.author
+		WHERE l.book = b.id ORDER BY l.id LIMIT 1), ''),
+	COALESCE((SELECT s.name FROM books_series_link l JOIN series s ON s.id = l.series
+		WHERE l.book = b.id LIMIT 1), '')
+FROM books b WHERE b.id > ? ORDER BY b.id`
+
+const calibreIdentifiersQuery = `SELECT book, type, val FROM identifiers WHERE book > ?`
+
+const calibreFormatsQuery = `SELECT book, LOWER(format) FROM data WHERE book > ?`
+
+const calibreLanguagesQuery = `
+SELECT l.book, g.lang_code FROM books_languages_link l JOIN languages g ON g.id = l.lang_code
+WHERE l.book > ? ORDER BY l.book, l.item_order`
+
+// ErrCalibreUnsupported is returned when calibre libraries can't be read on this platform
+var ErrCalibreUnsupported = errors.New("reading calibre libraries is not supported on this platform")
+
+// openCalibre opens the metadata.db of a calibre library read-only
+func openCalibre(libDir string) (*sql.DB, error) {
+	if !sqliteSupported {
+		return nil, ErrCalibreUnsupported
+	}
+	dbPath := filepath.Join(libDir, "metadata.db")
+	u := url.URL{
+		Scheme:   "file",
+		Path:     filepath.ToSlash(dbPath),
+		RawQuery: "mode=ro",
+	}
+	conn, err := sql.Open("sqlite", u.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn, conn.Ping()
+}
+
+// ImportCalibre adds all books of a local calibre library to the database as locally owned books.
+// With resync only the books that were added since the previous import are read.
+func ImportCalibre(s Store, libDir string, resync, dryRun bool) (ImportResult, error) {
+	var r ImportResult
+	libDir, err := filepath.Abs(libDir)
+	if err != nil {
+		return r, err
+	}
+	lib := CalibreLibrary{
+		Path: libDir,
+	}
+	if resync {
+		if stored, err := s.CalibreLibrary(libDir); err == nil {
+			lib = stored
+		}
+	}
+
+	conn, err := openCalibre(libDir)
+	if err != nil {
+		return r, fmt.Errorf("could not open calibre library: %w", err)
+	}
+	defer conn.Close()
+
+	identifiers := make(map[int]map[string]string)
+	err = queryCalibre(conn, calibreIdentifiersQuery, lib.LastID, func(rows *sql.Rows) error {
+		var id int
+		var kind, val string
+		err := rows.Scan(&id, &kind, &val)
+		if identifiers[id] == nil {
+			identifiers[id] = make(map[string]string)
+		}
+		identifiers[id][kind] = val
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+	formats := make(map[int][]string)
+	err = queryCalibre(conn, calibreFormatsQuery, lib.LastID, func(rows *sql.Rows) error {
+		var id int
+		var format string
+		err := rows.Scan(&id, &format)
+		formats[id] = append(formats[id], format)
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+	languages := make(map[int][]string)
+	err = queryCalibre(conn, calibreLanguagesQuery, lib.LastID, func(rows *sql.Rows) error {
+		var id int
+		var lang string
+		err := rows.Scan(&id, &lang)
+		languages[id] = append(languages[id], lang)
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+
+	im := newImporter(s, dryRun)
+	lastID := lib.LastID
+	err = queryCalibre(conn, calibreBooksQuery, lib.LastID, func(rows *sql.Rows) error {
+		var id int
+		var cb CalibreBook
+		var bookPath, author string
+		err := rows.Scan(&id, &cb.Title, &cb.SeriesIndex, &cb.UUID, &bookPath, &author, &cb.Series)
+		if err != nil {
+			return err
+		}
+		if author != "" {
+			cb.Authors = []string{author}
+		}
+		cb.Languages = languages[id]
+		book := newBook(s, &cb)
+		book.Added = time.Now()
+		book.Local = true
+		book.Path = filepath.Join(libDir, filepath.FromSlash(bookPath))
+		book.Identifiers = identifiers[id]
+		book.Formats = formats[id]
+		lastID = id
+		return im.add(book)
+	})
+	if err == nil {
+		err = im.flush()
+	}
+	if err != nil || dryRun {
+		return im.r, err
+	}
+
+	lib.LastID = lastID
+	lib.LastImport = time.Now()
+	lib.Books += im.r.Imported
+	return im.r, s.SaveCalibreLibrary(&lib)
+}
+
+// queryCalibre runs a query that selects everything after the given book id and calls fn for every row
+func queryCalibre(conn *sql.DB, query string, after int, fn func(*sql.Rows) error) error {
+	rows, err := conn.Query(query, after)
+	if err != nil {
+		return fmt.Errorf("could not query calibre library: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = fn(rows)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// CalibreLibraries returns all calibre libraries that have been imported
+func CalibreLibraries(s Store) ([]CalibreLibrary, error) {
+	return s.CalibreLibraries()
+}
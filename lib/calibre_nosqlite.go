@@ -0,0 +1,7 @@
+//go:build windows && 386
+// +build windows,386
+
+package lib
+
+// the sqlite driver is not available for 32 bit windows
+const sqliteSupported = false
@@ -0,0 +1,11 @@
+//go:build !(windows && 386)
+// +build !windows !386
+
+package lib
+
+import (
+	// pure go sqlite driver, so releases can be built without cgo
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSupported = true
@@ -0,0 +1,128 @@
+//go:build !(windows && 386)
+// +build !windows !386
+
+package lib_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+const calibreSchema = `
+CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT, series_index REAL, uuid TEXT, path TEXT);
+CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE books_authors_link (id INTEGER PRIMARY KEY, book INTEGER, author INTEGER);
+CREATE TABLE series (id INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE books_series_link (id INTEGER PRIMARY KEY, book INTEGER, series INTEGER);
+CREATE TABLE identifiers (id INTEGER PRIMARY KEY, book INTEGER, type TEXT, val TEXT);
+CREATE TABLE data (id INTEGER PRIMARY KEY, book INTEGER, format TEXT);
+CREATE TABLE languages (id INTEGER PRIMARY KEY, lang_code TEXT);
+CREATE TABLE books_languages_link (id INTEGER PRIMARY KEY, book INTEGER, lang_code INTEGER, item_order INTEGER);
+INSERT INTO authors VALUES (1, 'Frank Herbert'), (2, 'Brian Herbert');
+INSERT INTO series VALUES (1, 'Dune');
+INSERT INTO languages VALUES (1, 'eng');
+INSERT INTO books VALUES (1, 'Dune', 1, 'uuid-dune', 'Frank Herbert/Dune (1)');
+INSERT INTO books_authors_link VALUES (1, 1, 1), (2, 1, 2);
+INSERT INTO books_series_link VALUES (1, 1, 1);
+INSERT INTO identifiers VALUES (1, 1, 'isbn', '9780441013593');
+INSERT INTO data VALUES (1, 1, 'EPUB'), (2, 1, 'MOBI');
+INSERT INTO books_languages_link VALUES (1, 1, 1, 0);
+INSERT INTO books VALUES (2, 'Dune Messiah', 2, 'uuid-messiah', 'Frank Herbert/Dune Messiah (2)');
+INSERT INTO books_authors_link VALUES (3, 2, 1);
+INSERT INTO books_series_link VALUES (2, 2, 1);
+`
+
+// calibreLibrary creates a calibre library with a metadata.db that is set up with the given statements
+func calibreLibrary(t *testing.T, statements string) (string, *sql.DB) {
+	t.Helper()
+	dir := t.TempDir()
+	conn, err := sql.Open("sqlite", filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	_, err = conn.Exec(statements)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, conn
+}
+
+func TestImportCalibre(t *testing.T) {
+	s := db.NewMemory()
+	dir, conn := calibreLibrary(t, calibreSchema)
+
+	r, err := lib.ImportCalibre(s, dir, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Imported != 2 {
+		t.Errorf("dry run imported %d books, want 2", r.Imported)
+	}
+	if books, _ := s.Books(); len(books) != 0 {
+		t.Fatalf("dry run stored %d books", len(books))
+	}
+
+	r, err = lib.ImportCalibre(s, dir, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Imported != 2 {
+		t.Errorf("imported %d books, want 2", r.Imported)
+	}
+	b, err := s.BookByUUID("uuid-dune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Author != "Frank Herbert" || b.Series != "Dune" || b.SeriesIndex != 1 || !b.Local {
+		t.Errorf("book = %+v", b)
+	}
+	if b.Path != filepath.Join(dir, "Frank Herbert", "Dune (1)") {
+		t.Errorf("path = %s", b.Path)
+	}
+	if !reflect.DeepEqual(b.Formats, []string{"epub", "mobi"}) || b.Identifiers["isbn"] != "9780441013593" {
+		t.Errorf("formats %v, identifiers %v", b.Formats, b.Identifiers)
+	}
+	if !reflect.DeepEqual(b.Languages, []string{"eng"}) {
+		t.Errorf("languages = %v, want [eng]", b.Languages)
+	}
+
+	_, err = conn.Exec(`INSERT INTO books VALUES (3, 'Children of Dune', 3, 'uuid-children', 'Frank Herbert/Children of Dune (3)');
+		INSERT INTO books_authors_link VALUES (4, 3, 1);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = lib.ImportCalibre(s, dir, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Scanned != 1 || r.Imported != 1 {
+		t.Errorf("resync = %+v, want only the new book", r)
+	}
+	libs, _ := lib.CalibreLibraries(s)
+	if len(libs) != 1 || libs[0].LastID != 3 || libs[0].Books != 3 {
+		t.Errorf("libraries = %+v", libs)
+	}
+
+	r, err = lib.ImportCalibre(s, dir, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Imported != 0 || r.Present != 3 {
+		t.Errorf("full import of a known library = %+v, want every book present", r)
+	}
+}
+
+func TestImportCalibreMissingLibrary(t *testing.T) {
+	_, err := lib.ImportCalibre(db.NewMemory(), t.TempDir(), false, false)
+	if err == nil {
+		t.Error("importing a directory without metadata.db succeeded")
+	}
+}
@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckpointState tells why a checkpoint still exists
+type CheckpointState string
+
+const (
+	// CheckpointRunning checkpoints belong to a scrape that is running, or to one that was killed
+	// because a checkpoint of a scrape that stops on its own is removed or gets another state
+	CheckpointRunning CheckpointState = "running"
+	// CheckpointInterrupted checkpoints belong to a scrape that was cancelled
+	CheckpointInterrupted CheckpointState = "interrupted"
+	// CheckpointDeferred checkpoints belong to a scrape that ran out of its budget
+	CheckpointDeferred CheckpointState = "deferred"
+	// CheckpointFailed checkpoints belong to a scrape that could not retrieve a page of books,
+	// the page is retrieved again when the scrape resumes
+	CheckpointFailed CheckpointState = "failed"
+)
+
+// checkpointEvery is how often the progress of a scrape is stored
+const checkpointEvery = 5 * time.Second
+
+// ScrapeCheckpoint is the progress of the scrape of a host. A scrape that is stopped
+// resumes from its checkpoint in the next run, the checkpoint is removed when it finishes.
+type ScrapeCheckpoint struct {
+	HostID  int `storm:"id"`
+	State   CheckpointState
+	Start   time.Time
+	Updated time.Time
+	// Runs is the number of runs that worked on the scrape
+	Runs int
+	// Total is the number of books in the catalog of the host
+	Total int
+	// IDOffset is the offset of the first ID page that was not retrieved yet, IDs are the IDs of the pages before it
+	IDOffset int
+	IDs      []int
+	// Complete is false when ID pages could not be retrieved or the paging was resumed
+	Complete bool
+	// IDsDone is set once all ID pages were retrieved, New are the IDs that were not checked before
+	IDsDone bool
+	New     []int
+	// MetadataDone is the number of IDs of New whose metadata was handled, the books to download were queued by then
+	MetadataDone int
+}
+
+// Progress describes how far the scrape got
+func (cp ScrapeCheckpoint) Progress() string {
+	if !cp.IDsDone {
+		done := cp.IDOffset
+		if done > cp.Total {
+			done = cp.Total
+		}
+		return fmt.Sprintf("retrieving IDs, %d of %d", done, cp.Total)
+	}
+	return fmt.Sprintf("retrieving metadata, %d of %d new books", cp.MetadataDone, len(cp.New))
+}
+
+// Print prints a checkpoint in a nicely formatted way
+func (cp ScrapeCheckpoint) Print(h Host) {
+	state := string(cp.State)
+	if cp.State == CheckpointRunning {
+		//nothing else can open the database while a scrape runs, so the run was killed
+		state = "killed"
+	}
+	fmt.Printf(`%5d|%30s|%12s|%17s|%17s|%4d| %s`, h.ID, h.URL, state, cp.Start.Local().Format("2006-01-02 15:04"), cp.Updated.Local().Format("2006-01-02 15:04"), cp.Runs, cp.Progress())
+	fmt.Println()
+}
+
+// saveCheckpoint stores a checkpoint when force is set or when it wasn't stored for checkpointEvery
+func (a *App) saveCheckpoint(cp *ScrapeCheckpoint, force bool) {
+	if !force && time.Since(cp.Updated) < checkpointEvery {
+		return
+	}
+	cp.Updated = time.Now()
+	err := a.Store.SaveCheckpoint(cp)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"host": cp.HostID,
+			"err":  err,
+		}).Warning("Could not store scrape checkpoint")
+	}
+}
+
+// loadCheckpoint returns the checkpoint of a host, or a new one when there is none
+func (a *App) loadCheckpoint(hostID int) *ScrapeCheckpoint {
+	cp, err := a.Store.Checkpoint(hostID)
+	if err != nil {
+		now := time.Now()
+		return &ScrapeCheckpoint{
+			HostID:  hostID,
+			State:   CheckpointRunning,
+			Start:   now,
+			Updated: now,
+			Runs:    1,
+		}
+	}
+	log.WithFields(log.Fields{
+		"host":     hostID,
+		"state":    cp.State,
+		"started":  cp.Start.Format(time.RFC3339),
+		"progress": cp.Progress(),
+	}).Info("Resuming scrape")
+	if !cp.IDsDone && cp.IDOffset > 0 {
+		//the catalog may have changed since, so the IDs can't be used to prune it
+		cp.Complete = false
+	}
+	cp.State = CheckpointRunning
+	cp.Runs++
+	return &cp
+}
@@ -0,0 +1,119 @@
+package lib_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+// queuedIDs returns the calibre IDs of the queued downloads of a host
+func queuedIDs(t *testing.T, s lib.Store, hostID int) map[int]bool {
+	t.Helper()
+	queue, err := s.HostQueue(hostID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[int]bool)
+	for _, d := range queue {
+		ids[d.CalibreID] = true
+	}
+	return ids
+}
+
+func TestScrapeResumesFailedPage(t *testing.T) {
+	f := newFakeHost(t, "Dune", "Dune Messiah", "Children of Dune", "God Emperor of Dune", "Heretics of Dune")
+	f.set(func(f *fakeHost) { f.failBooks[3] = true })
+	s := db.NewMemory()
+	h := saveHosts(t, s, f.URL)[0]
+	a := testApp(t, s)
+
+	r, err := a.Scrape(context.Background(), &h)
+	if err == nil {
+		t.Fatal("scrape with a broken page succeeded")
+	}
+	if r.Results != 5 {
+		t.Errorf("found %d books, want 5", r.Results)
+	}
+	cp, err := s.Checkpoint(h.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.State != lib.CheckpointFailed || cp.MetadataDone != 2 || len(cp.New) != 5 {
+		t.Errorf("checkpoint %+v, want it failed at the second page", cp)
+	}
+	if ids := queuedIDs(t, s, h.ID); len(ids) != 2 || !ids[1] || !ids[2] {
+		t.Errorf("queued %v, want the books of the first page", ids)
+	}
+
+	f.set(func(f *fakeHost) { f.failBooks = map[int]bool{} })
+	if _, err = a.Scrape(context.Background(), &h); err != nil {
+		t.Fatal(err)
+	}
+	if ids := queuedIDs(t, s, h.ID); len(ids) != 5 {
+		t.Errorf("queued %v, want all books", ids)
+	}
+	if _, err = s.Checkpoint(h.ID); err != lib.ErrNotFound {
+		t.Errorf("checkpoint was kept: %v", err)
+	}
+}
+
+func TestScrapeResumesCheckpoint(t *testing.T) {
+	f := newFakeHost(t, "Dune", "Dune Messiah", "Children of Dune", "God Emperor of Dune")
+	s := db.NewMemory()
+	h := saveHosts(t, s, f.URL)[0]
+	a := testApp(t, s)
+
+	//a killed run had looked up the first two new books already
+	start := time.Now().Add(-time.Hour)
+	cp := lib.ScrapeCheckpoint{
+		HostID:       h.ID,
+		State:        lib.CheckpointRunning,
+		Start:        start,
+		Runs:         1,
+		Total:        4,
+		IDsDone:      true,
+		New:          []int{1, 2, 3, 4},
+		MetadataDone: 2,
+	}
+	if err := s.SaveCheckpoint(&cp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Scrape(context.Background(), &h); err != nil {
+		t.Fatal(err)
+	}
+	if ids := queuedIDs(t, s, h.ID); len(ids) != 2 || !ids[3] || !ids[4] {
+		t.Errorf("queued %v, want the books the killed run didn't look up", ids)
+	}
+	if _, err := s.Checkpoint(h.ID); err != lib.ErrNotFound {
+		t.Errorf("checkpoint was kept: %v", err)
+	}
+}
+
+func TestScrapeInterrupted(t *testing.T) {
+	f := newFakeHost(t, "Dune")
+	s := db.NewMemory()
+	h := saveHosts(t, s, f.URL)[0]
+	a := testApp(t, s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := a.Scrape(ctx, &h)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+	cp, err := s.Checkpoint(h.ID)
+	if err != nil || cp.State != lib.CheckpointInterrupted {
+		t.Errorf("checkpoint %+v, %v, want it interrupted", cp, err)
+	}
+
+	if _, err = a.Scrape(context.Background(), &h); err != nil {
+		t.Fatal(err)
+	}
+	if ids := queuedIDs(t, s, h.ID); len(ids) != 1 {
+		t.Errorf("queued %v after resuming, want the book", ids)
+	}
+}
@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Daemon keeps scraping every active host when it is due, until its context is cancelled.
+// Hosts that are not due are drained when they have waiting downloads.
+type Daemon struct {
+	App *App
+	// Policy decides when and how hosts are scraped
+	Policy Policy
+	// Concurrency is the number of hosts that are scraped at the same time
+	Concurrency int
+	// Tick is how often the daemon looks for hosts that are due
+	Tick time.Duration
+	// Backups and Trash are maintained every MaintenanceInterval, see Maintain
+	Backups BackupSchedule
+	Trash   TrashExpiry
+
+	mu      sync.Mutex
+	running map[int]bool
+}
+
+// MaintenanceInterval is how often the daemon makes the scheduled backup and empties the trash
+const MaintenanceInterval = time.Hour
+
+// Run schedules scrapes until ctx is cancelled. Running scrapes get ctx as well, they
+// stop downloading and save their checkpoint before Run returns.
+func (d *Daemon) Run(ctx context.Context) {
+	d.running = make(map[int]bool)
+	var maintained time.Time
+	slots := make(chan struct{}, d.Concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ticker := time.NewTicker(d.Tick)
+	defer ticker.Stop()
+	for {
+		if time.Since(maintained) >= MaintenanceInterval {
+			maintained = time.Now()
+			Maintain(d.App.Store, d.Backups, d.Trash, maintained)
+		}
+		err := d.App.ProbeHosts(ctx, d.Policy)
+		if err != nil {
+			log.WithField("err", err).Error("Could not probe disabled hosts")
+		}
+		hosts, err := d.App.Store.ActiveHosts()
+		if err != nil {
+			log.WithField("err", err).Error("Could not list hosts")
+		}
+		now := time.Now()
+		for _, h := range hosts {
+			scrape := d.due(h, now)
+			if !scrape && !d.drainable(h, now) {
+				continue
+			}
+			select {
+			case slots <- struct{}{}:
+			default:
+				//all slots are taken, the host is picked up on a later tick
+				continue
+			}
+			d.setRunning(h.ID, true)
+			wg.Add(1)
+			go func(h Host) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				defer d.setRunning(h.ID, false)
+				if scrape {
+					d.App.ScrapeHost(ctx, &h, d.Policy, nil)
+				} else {
+					d.App.DrainHost(ctx, &h, d.Policy, nil)
+				}
+			}(h)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.WithField("running", d.runningCount()).Info("Stopping, waiting for running scrapes to save their progress")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// due returns true if a host should be scraped now and isn't being scraped already
+func (d *Daemon) due(h Host, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.running[h.ID] {
+		return false
+	}
+	return d.Policy.Due(h, now)
+}
+
+// drainable returns true if a host has waiting downloads and isn't being scraped or drained already
+func (d *Daemon) drainable(h Host, now time.Time) bool {
+	d.mu.Lock()
+	running := d.running[h.ID]
+	d.mu.Unlock()
+	return !running && drainable(d.App.Store, h, now)
+}
+
+func (d *Daemon) setRunning(hostID int, running bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if running {
+		d.running[hostID] = true
+	} else {
+		delete(d.running, hostID)
+	}
+}
+
+func (d *Daemon) runningCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.running)
+}
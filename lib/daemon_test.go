@@ -0,0 +1,92 @@
+package lib_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+func TestDaemonStopsRunningScrapes(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requested <- struct{}{}:
+		default:
+		}
+		//the host hangs until demeter gives up on the request
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	s := db.NewMemory()
+	h := lib.Host{URL: srv.URL, Active: true}
+	if err := s.SaveHost(&h); err != nil {
+		t.Fatal(err)
+	}
+	a := &lib.App{
+		Store:           s,
+		Timeout:         time.Hour,
+		DownloadTimeout: time.Hour,
+		StepSize:        10,
+		OutputDir:       t.TempDir(),
+		Pool:            lib.NewPool(1, 1, time.Hour),
+	}
+	defer a.Pool.Close()
+	d := lib.Daemon{App: a, Concurrency: 1, Tick: time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(stopped)
+	}()
+	select {
+	case <-requested:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon didn't scrape the host")
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon kept waiting for the running scrape")
+	}
+}
+
+func TestDaemonMaintains(t *testing.T) {
+	s := db.NewMemory()
+	old := lib.TrashedBook{Book: lib.Book{Hash: "herbertdune"}, Deleted: time.Now().AddDate(0, 0, -2)}
+	recent := lib.TrashedBook{Book: lib.Book{Hash: "asimovfoundation"}, Deleted: time.Now()}
+	for _, tb := range []*lib.TrashedBook{&old, &recent} {
+		if err := s.SaveTrashedBook(tb); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := lib.Daemon{
+		App:         &lib.App{Store: s},
+		Concurrency: 1,
+		Tick:        time.Hour,
+		Trash:       lib.TrashExpiry{KeepDays: 1},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d.Run(ctx)
+
+	trash, err := s.Trash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].ID != recent.ID {
+		t.Errorf("trash holds %+v, want only the recent book", trash)
+	}
+}
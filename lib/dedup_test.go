@@ -0,0 +1,245 @@
+package lib_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+var duneTitles = []string{"Dune", "Dune Messiah", "Children of Dune"}
+
+func TestSharedBooksDownloadedOnce(t *testing.T) {
+	a1 := newFakeHost(t, duneTitles...)
+	a2 := newFakeHost(t, duneTitles...)
+	s := db.NewMemory()
+	hosts := saveHosts(t, s, a1.URL, a2.URL)
+	o := lib.Orchestrator{App: testApp(t, s), Policy: lib.Policy{Health: lib.DefaultHealthPolicy()}}
+
+	summary := o.Run(context.Background(), hosts)
+	if !summary.OK() || summary.Downloads != 3 {
+		t.Errorf("summary %+v, want 3 downloads", summary)
+	}
+	if n := a1.downloads + a2.downloads; n != 3 {
+		t.Errorf("the hosts served %d downloads, want 3", n)
+	}
+	if queue, _ := s.Queue(); len(queue) != 0 {
+		t.Errorf("queue holds %v", hashes(queue))
+	}
+}
+
+// claimedBooks scrapes the first host and then the second, so the first claims every book
+func claimedBooks(t *testing.T, s lib.Store, a *lib.App, first, second *lib.Host) {
+	t.Helper()
+	for _, h := range []*lib.Host{first, second} {
+		if _, err := a.Scrape(context.Background(), h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queue, _ := s.Queue()
+	for _, d := range queue {
+		if d.HostID != first.ID || len(d.Fallbacks) != 1 || d.Fallbacks[0].HostID != second.ID {
+			t.Fatalf("queued %+v, want it claimed by host %d with host %d as fallback", d, first.ID, second.ID)
+		}
+	}
+}
+
+func TestFallbackTakesOverFailedDownload(t *testing.T) {
+	broken := newFakeHost(t, duneTitles...)
+	broken.set(func(f *fakeHost) { f.failDownloads = true })
+	working := newFakeHost(t, duneTitles...)
+	s := db.NewMemory()
+	hosts := saveHosts(t, s, broken.URL, working.URL)
+	claimedBooks(t, s, testApp(t, s), &hosts[0], &hosts[1])
+
+	//the fallbacks are stored, so a later run still has them
+	a := testApp(t, s)
+	r := &lib.ScrapeResult{}
+	if err := a.Drain(context.Background(), &hosts[0], nil, r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Downloads != 3 || working.downloads != 3 {
+		t.Errorf("%d downloads, %d from the fallback host, want 3", r.Downloads, working.downloads)
+	}
+	books, _ := s.Books()
+	for _, b := range books {
+		if b.SourceID != hosts[1].ID {
+			t.Errorf("book %s came from host %d, want the fallback host", b.Title, b.SourceID)
+		}
+	}
+	if queue, _ := s.Queue(); len(queue) != 0 {
+		t.Errorf("queue holds %v", hashes(queue))
+	}
+}
+
+func TestInactiveFallbackIsSkipped(t *testing.T) {
+	broken := newFakeHost(t, duneTitles...)
+	broken.set(func(f *fakeHost) { f.failDownloads = true })
+	disabled := newFakeHost(t, duneTitles...)
+	s := db.NewMemory()
+	hosts := saveHosts(t, s, broken.URL, disabled.URL)
+	a := testApp(t, s)
+	claimedBooks(t, s, a, &hosts[0], &hosts[1])
+
+	hosts[1].Active = false
+	if err := s.SaveHost(&hosts[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Drain(context.Background(), &hosts[0], nil, &lib.ScrapeResult{}); err != nil {
+		t.Fatal(err)
+	}
+	if disabled.downloads != 0 {
+		t.Errorf("the disabled host served %d downloads", disabled.downloads)
+	}
+	queue, _ := s.Queue()
+	for _, d := range queue {
+		if d.HostID != hosts[0].ID || d.Attempts != 1 || len(d.Fallbacks) != 0 {
+			t.Errorf("queued %+v, want a failed attempt without fallbacks", d)
+		}
+	}
+}
+
+func TestClaimOfInactiveHostIsTakenOver(t *testing.T) {
+	gone := newFakeHost(t, duneTitles...)
+	fallback := newFakeHost(t, duneTitles...)
+	later := newFakeHost(t, duneTitles...)
+	s := db.NewMemory()
+	hosts := saveHosts(t, s, gone.URL, fallback.URL, later.URL)
+	a := testApp(t, s)
+	claimedBooks(t, s, a, &hosts[0], &hosts[1])
+
+	hosts[0].Active = false
+	if err := s.SaveHost(&hosts[0]); err != nil {
+		t.Fatal(err)
+	}
+	r, err := a.Scrape(context.Background(), &hosts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, _ := s.Queue()
+	if len(queue) != 3 {
+		t.Fatalf("queue holds %v", hashes(queue))
+	}
+	for _, d := range queue {
+		if d.HostID != hosts[2].ID || len(d.Fallbacks) != 1 || d.Fallbacks[0].HostID != hosts[1].ID {
+			t.Errorf("queued %+v, want it taken over with the other fallback kept", d)
+		}
+	}
+	if r.Results != 3 {
+		t.Errorf("found %d books, want 3", r.Results)
+	}
+}
+
+func TestHandedOverDownloadIsNotDrainedTwice(t *testing.T) {
+	broken := newFakeHost(t, duneTitles...)
+	broken.set(func(f *fakeHost) { f.failDownloads = true })
+	slow := newFakeHost(t, duneTitles...)
+	hold := make(chan struct{})
+	slow.set(func(f *fakeHost) { f.hold = hold })
+	s := db.NewMemory()
+	hosts := saveHosts(t, s, broken.URL, slow.URL)
+	a := testApp(t, s)
+	claimedBooks(t, s, a, &hosts[0], &hosts[1])
+
+	drained := make(chan error)
+	go func() {
+		drained <- a.Drain(context.Background(), &hosts[0], nil, &lib.ScrapeResult{})
+	}()
+	//wait until every book is handed over and downloading from the slow host
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		queue, _ := s.HostQueue(hosts[1].ID)
+		if len(queue) == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			close(hold)
+			t.Fatalf("queue of the fallback host holds %v, want every book", hashes(queue))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	r := &lib.ScrapeResult{}
+	done := make(chan error)
+	go func() {
+		done <- a.Drain(context.Background(), &hosts[1], nil, r)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the drain of the fallback host waits for the downloads of the other drain")
+		close(hold)
+		<-done
+		hold = nil
+	}
+	if hold != nil {
+		close(hold)
+	}
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+	if r.Downloads != 0 || slow.downloads != 3 {
+		t.Errorf("the second drain made %d downloads and the slow host served %d, want 0 and 3", r.Downloads, slow.downloads)
+	}
+	if books, _ := s.Books(); len(books) != 3 {
+		t.Errorf("%d books, want 3", len(books))
+	}
+}
+
+func TestBackingOffHostPassesClaims(t *testing.T) {
+	backingOff := newFakeHost(t, duneTitles...)
+	fallback := newFakeHost(t, duneTitles...)
+	s := db.NewMemory()
+	hosts := saveHosts(t, s, backingOff.URL, fallback.URL)
+	a := testApp(t, s)
+	claimedBooks(t, s, a, &hosts[0], &hosts[1])
+
+	now := time.Now()
+	hosts[0].BackoffUntil = now.Add(time.Hour)
+	if err := s.SaveHost(&hosts[0]); err != nil {
+		t.Fatal(err)
+	}
+	passed, err := lib.PassClaims(s, hosts[0].ID, now)
+	if err != nil || passed != 3 {
+		t.Fatalf("passed %d, %v, want 3", passed, err)
+	}
+	queue, _ := s.HostQueue(hosts[1].ID)
+	if len(queue) != 3 {
+		t.Fatalf("queue of the fallback host holds %v, want every book", hashes(queue))
+	}
+	if err := a.Drain(context.Background(), &hosts[1], nil, &lib.ScrapeResult{}); err != nil {
+		t.Fatal(err)
+	}
+	if backingOff.downloads != 0 || fallback.downloads != 3 {
+		t.Errorf("the hosts served %d and %d downloads, want 0 and 3", backingOff.downloads, fallback.downloads)
+	}
+}
+
+func TestClaimOfBackingOffHostIsTakenOver(t *testing.T) {
+	backingOff := newFakeHost(t, duneTitles...)
+	later := newFakeHost(t, duneTitles...)
+	s := db.NewMemory()
+	hosts := saveHosts(t, s, backingOff.URL, later.URL)
+	a := testApp(t, s)
+	if _, err := a.Scrape(context.Background(), &hosts[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts[0].BackoffUntil = time.Now().Add(time.Hour)
+	if err := s.SaveHost(&hosts[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Scrape(context.Background(), &hosts[1]); err != nil {
+		t.Fatal(err)
+	}
+	queue, _ := s.Queue()
+	for _, d := range queue {
+		if d.HostID != hosts[1].ID {
+			t.Errorf("queued %+v, want it taken over from the host that backs off", d)
+		}
+	}
+}
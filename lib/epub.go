@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type opfPackage struct {
+	Metadata struct {
+		Titles   []string `xml:"title"`
+		Creators []struct {
+			Name string `xml:",chardata"`
+			Role string `xml:"role,attr"`
+		} `xml:"creator"`
+		Languages []string `xml:"language"`
+		Meta      []struct {
+			Name     string `xml:"name,attr"`
+			Content  string `xml:"content,attr"`
+			Property string `xml:"property,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+}
+
+// ErrNoMetadata is returned when a book file does not contain usable metadata
+var ErrNoMetadata = errors.New("no metadata found")
+
+// languageCodes maps two letter language codes to the three letter codes calibre uses
+var languageCodes = map[string]string{
+	"en":  "eng",
+	"nl":  "nld",
+	"fr":  "fra",
+	"de":  "deu",
+	"es":  "spa",
+	"it":  "ita",
+	"pt":  "por",
+	"sv":  "swe",
+	"da":  "dan",
+	"no":  "nor",
+	"dut": "nld",
+	"fre": "fra",
+	"ger": "deu",
+}
+
+func languageCode(l string) string {
+	l = strings.ToLower(strings.TrimSpace(l))
+	if i := strings.IndexAny(l, "-_"); i > 0 {
+		l = l[:i]
+	}
+	if code, ok := languageCodes[l]; ok {
+		return code
+	}
+	return l
+}
+
+// readEpub reads the metadata from the OPF file of an epub
+func readEpub(filePath string) (CalibreBook, error) {
+	var b CalibreBook
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return b, err
+	}
+	defer r.Close()
+
+	var c epubContainer
+	err = decodeZipXML(&r.Reader, "META-INF/container.xml", &c)
+	if err != nil {
+		return b, err
+	}
+	if len(c.Rootfiles) == 0 {
+		return b, ErrNoMetadata
+	}
+	var opf opfPackage
+	err = decodeZipXML(&r.Reader, c.Rootfiles[0].FullPath, &opf)
+	if err != nil {
+		return b, err
+	}
+
+	m := opf.Metadata
+	if len(m.Titles) == 0 {
+		return b, ErrNoMetadata
+	}
+	b.Title = strings.TrimSpace(m.Titles[0])
+	for _, c := range m.Creators {
+		if c.Role == "" || c.Role == "aut" {
+			b.Authors = append(b.Authors, strings.TrimSpace(c.Name))
+		}
+	}
+	for _, l := range m.Languages {
+		b.Languages = append(b.Languages, languageCode(l))
+	}
+	for _, meta := range m.Meta {
+		switch {
+		case meta.Name == "calibre:series":
+			b.Series = meta.Content
+		case meta.Name == "calibre:series_index":
+			b.SeriesIndex, _ = strconv.ParseFloat(meta.Content, 64)
+		case meta.Property == "belongs-to-collection" && b.Series == "":
+			b.Series = strings.TrimSpace(meta.Value)
+		case meta.Property == "group-position" && b.SeriesIndex == 0:
+			b.SeriesIndex, _ = strconv.ParseFloat(strings.TrimSpace(meta.Value), 64)
+		}
+	}
+	return b, nil
+}
+
+func decodeZipXML(r *zip.Reader, name string, v interface{}) error {
+	name = strings.TrimPrefix(path.Clean(name), "/")
+	for _, f := range r.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return xml.NewDecoder(io.LimitReader(rc, 10<<20)).Decode(v)
+	}
+	return ErrNoMetadata
+}
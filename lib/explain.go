@@ -0,0 +1,131 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// Step is a single normalisation step together with its result
+type Step struct {
+	Name  string
+	Value string
+}
+
+// trace records the intermediate values of the normalisation steps, a nil trace records nothing
+type trace []Step
+
+func (t *trace) add(name, value string) {
+	if t == nil {
+		return
+	}
+	*t = append(*t, Step{
+		Name:  name,
+		Value: value,
+	})
+}
+
+// Explanation describes how a book was turned into its hash and which books match it
+type Explanation struct {
+	Book    Book
+	Steps   []Step
+	Matches []Book
+	Similar []SimilarBook
+}
+
+// SimilarBook is a book with a hash that is close to the hash of another book
+type SimilarBook struct {
+	Book     Book
+	Distance int
+}
+
+// Explain normalises a book the same way a scrape does and records every step
+func Explain(s Store, b CalibreBook) (Explanation, error) {
+	var t trace
+	e := Explanation{
+		Book: normaliseBook(s, &b, &t),
+	}
+	e.Steps = t
+
+	books, err := s.Books()
+	if err != nil {
+		return e, err
+	}
+	maxDistance := len(e.Book.Hash) / 5
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	for _, book := range books {
+		if book.Hash == e.Book.Hash {
+			e.Matches = append(e.Matches, book)
+			continue
+		}
+		if e.Book.SeriesKey != "" && book.SeriesKey == e.Book.SeriesKey && book.SeriesIndex == e.Book.SeriesIndex {
+			e.Matches = append(e.Matches, book)
+			continue
+		}
+		d := levenshtein(book.Hash, e.Book.Hash)
+		if d <= maxDistance {
+			e.Similar = append(e.Similar, SimilarBook{
+				Book:     book,
+				Distance: d,
+			})
+		}
+	}
+	sort.Slice(e.Similar, func(i, j int) bool {
+		return e.Similar[i].Distance < e.Similar[j].Distance
+	})
+	return e, nil
+}
+
+// GetBook retrieves the metadata of a single book from a calibre host
+func (a *App) GetBook(hostURL string, id int) (CalibreBook, error) {
+	u, err := url.Parse(hostURL)
+	if err != nil {
+		return CalibreBook{}, err
+	}
+	bs, err := a.getBooks(context.Background(), *u, []int{id})
+	if err != nil {
+		return CalibreBook{}, err
+	}
+	b, ok := bs[strconv.Itoa(id)]
+	if !ok {
+		return b, fmt.Errorf("book %d was not found on %s", id, hostURL)
+	}
+	return b, nil
+}
+
+// levenshtein calculates the edit distance between two strings
+func levenshtein(a, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
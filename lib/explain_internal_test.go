@@ -0,0 +1,23 @@
+package lib
+
+import "testing"
+
+func TestLevenshtein(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"dune", "", 4},
+		{"", "dune", 4},
+		{"dune", "dune", 0},
+		{"dune", "dunes", 1},
+		{"kitten", "sitting", 3},
+		{"herbertdune", "herbertdnue", 2},
+		{"brontë", "bronte", 1},
+	} {
+		if got := levenshtein(c.a, c.b); got != c.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
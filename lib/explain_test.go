@@ -0,0 +1,60 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+func TestExplainSteps(t *testing.T) {
+	s := db.NewMemory()
+	e, err := lib.Explain(s, lib.CalibreBook{
+		Authors: []string{"Frank Herbert"},
+		Title:   "Dune (1965)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Steps) == 0 {
+		t.Fatal("no steps were recorded")
+	}
+	if first := e.Steps[0]; first.Value != "Dune (1965)" {
+		t.Errorf("first step = %s: %s, want the input title", first.Name, first.Value)
+	}
+	if last := e.Steps[len(e.Steps)-1]; last.Value != e.Book.Hash {
+		t.Errorf("last step = %s: %s, want the hash %s", last.Name, last.Value, e.Book.Hash)
+	}
+	if e.Book.Hash != "herbertdune" {
+		t.Errorf("hash = %s, want herbertdune", e.Book.Hash)
+	}
+}
+
+func TestExplainMatchesAndSimilar(t *testing.T) {
+	s := db.NewMemory()
+	for _, b := range []lib.Book{
+		{Hash: "herbertdune", Author: "Frank Herbert", Title: "Dune"},
+		{Hash: "herbertdunes", Author: "Frank Herbert", Title: "Dunes"},
+		{Hash: "herbertchildrenofdune", Author: "Frank Herbert", Title: "Children of Dune"},
+	} {
+		b := b
+		err := s.SaveBook(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, err := lib.Explain(s, lib.CalibreBook{
+		Authors: []string{"Frank Herbert"},
+		Title:   "Dune",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Matches) != 1 || e.Matches[0].Hash != "herbertdune" {
+		t.Errorf("matches = %v, want herbertdune", e.Matches)
+	}
+	if len(e.Similar) != 1 || e.Similar[0].Book.Hash != "herbertdunes" || e.Similar[0].Distance != 1 {
+		t.Errorf("similar = %v, want herbertdunes at distance 1", e.Similar)
+	}
+}
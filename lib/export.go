@@ -0,0 +1,499 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Buckets that can be exported and imported, in the order they are processed
+const (
+	BucketHosts   = "hosts"
+	BucketScrapes = "scrapes"
+	BucketBooks   = "books"
+	BucketChecked = "checked_ids"
+	BucketAliases = "aliases"
+	BucketCatalog = "catalog"
+)
+
+// AllBuckets holds all buckets that can be exported
+var AllBuckets = []string{BucketHosts, BucketScrapes, BucketBooks, BucketChecked, BucketAliases, BucketCatalog}
+
+// Merge policies that decide what happens when an imported record already exists
+const (
+	MergeSkip      = "skip"
+	MergeOverwrite = "overwrite"
+	MergeNewest    = "newest-wins"
+)
+
+// MergeCount counts what happened to the imported records of a bucket
+type MergeCount struct {
+	Added   int
+	Updated int
+	Skipped int
+}
+
+type exportLine struct {
+	Bucket string          `json:"bucket"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// recordWriter writes the records of a single bucket
+type recordWriter func(bucket string, v interface{}) error
+
+// recordReader reads the next record of a bucket into v, it returns io.EOF when there are no more records
+type recordReader func(v interface{}) error
+
+// ValidBucket returns true if bucket can be exported
+func ValidBucket(bucket string) bool {
+	for _, b := range AllBuckets {
+		if b == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+func wanted(buckets []string, bucket string) bool {
+	for _, b := range buckets {
+		if b == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// exportBuckets writes all records of the requested buckets
+func exportBuckets(s Store, buckets []string, write recordWriter) (map[string]int, error) {
+	counts := make(map[string]int)
+	for _, bucket := range AllBuckets {
+		if !wanted(buckets, bucket) {
+			continue
+		}
+		var records []interface{}
+		switch bucket {
+		case BucketHosts:
+			hosts, err := s.Hosts()
+			if err != nil {
+				return counts, err
+			}
+			for _, h := range hosts {
+				records = append(records, h)
+			}
+		case BucketScrapes:
+			hosts, err := s.Hosts()
+			if err != nil {
+				return counts, err
+			}
+			for _, h := range hosts {
+				results, err := s.ScrapeResults(h.ID, 0)
+				if err != nil {
+					return counts, err
+				}
+				for _, r := range results {
+					records = append(records, ScrapeRecord{
+						HostID:       h.ID,
+						ScrapeResult: r,
+					})
+				}
+			}
+		case BucketBooks:
+			books, err := s.Books()
+			if err != nil {
+				return counts, err
+			}
+			for _, b := range books {
+				records = append(records, b)
+			}
+		case BucketChecked:
+			checked, err := s.CheckedIDs()
+			if err != nil {
+				return counts, err
+			}
+			for _, c := range checked {
+				records = append(records, c)
+			}
+		case BucketAliases:
+			aliases, err := s.Aliases()
+			if err != nil {
+				return counts, err
+			}
+			for _, a := range aliases {
+				records = append(records, a)
+			}
+		case BucketCatalog:
+			entries, err := s.CatalogEntries()
+			if err != nil {
+				return counts, err
+			}
+			for _, e := range entries {
+				records = append(records, e)
+			}
+		}
+		for _, r := range records {
+			err := write(bucket, r)
+			if err != nil {
+				return counts, err
+			}
+			counts[bucket]++
+		}
+	}
+	return counts, nil
+}
+
+// ExportJSONL writes the requested buckets as JSON lines, every line holds the bucket and a single record
+func ExportJSONL(s Store, w io.Writer, buckets []string) (map[string]int, error) {
+	enc := json.NewEncoder(w)
+	return exportBuckets(s, buckets, func(bucket string, v interface{}) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(exportLine{
+			Bucket: bucket,
+			Data:   data,
+		})
+	})
+}
+
+// ExportCSV writes every requested bucket to a separate csv file in dir
+func ExportCSV(s Store, dir string, buckets []string) (map[string]int, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*os.File)
+	writers := make(map[string]*csv.Writer)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	counts, err := exportBuckets(s, buckets, func(bucket string, v interface{}) error {
+		w, ok := writers[bucket]
+		if !ok {
+			f, err := os.Create(filepath.Join(dir, bucket+".csv"))
+			if err != nil {
+				return err
+			}
+			files[bucket] = f
+			w = csv.NewWriter(f)
+			writers[bucket] = w
+			err = w.Write(csvHeader(v))
+			if err != nil {
+				return err
+			}
+		}
+		row, err := csvRow(v)
+		if err != nil {
+			return err
+		}
+		return w.Write(row)
+	})
+	for _, w := range writers {
+		w.Flush()
+		if err == nil {
+			err = w.Error()
+		}
+	}
+	return counts, err
+}
+
+// ImportJSONL reads records written by ExportJSONL and merges them into the database
+func ImportJSONL(s Store, r io.Reader, buckets []string, policy string) (map[string]MergeCount, error) {
+	lines := make(map[string][]json.RawMessage)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var l exportLine
+		err := json.Unmarshal(scanner.Bytes(), &l)
+		if err != nil {
+			return nil, err
+		}
+		lines[l.Bucket] = append(lines[l.Bucket], l.Data)
+	}
+	if scanner.Err() != nil {
+		return nil, scanner.Err()
+	}
+	return importBuckets(s, buckets, policy, func(bucket string) recordReader {
+		records := lines[bucket]
+		return func(v interface{}) error {
+			if len(records) == 0 {
+				return io.EOF
+			}
+			next := records[0]
+			records = records[1:]
+			return json.Unmarshal(next, v)
+		}
+	})
+}
+
+// ImportCSV reads the csv files written by ExportCSV and merges them into the database
+func ImportCSV(s Store, dir string, buckets []string, policy string) (map[string]MergeCount, error) {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	return importBuckets(s, buckets, policy, func(bucket string) recordReader {
+		f, err := os.Open(filepath.Join(dir, bucket+".csv"))
+		if os.IsNotExist(err) {
+			return func(v interface{}) error {
+				return io.EOF
+			}
+		}
+		if err != nil {
+			return func(v interface{}) error {
+				return err
+			}
+		}
+		files = append(files, f)
+		r := csv.NewReader(f)
+		header, err := r.Read()
+		return func(v interface{}) error {
+			if err != nil {
+				return err
+			}
+			row, err := r.Read()
+			if err != nil {
+				return err
+			}
+			return csvDecode(header, row, v)
+		}
+	})
+}
+
+// importBuckets merges the records of all requested buckets into the database,
+// host and book IDs are remapped because they differ between databases
+func importBuckets(s Store, buckets []string, policy string, open func(bucket string) recordReader) (map[string]MergeCount, error) {
+	counts := make(map[string]MergeCount)
+	hostIDs := make(map[int]int)
+	bookIDs := make(map[int]int)
+
+	err := s.Update(func(tx Store) error {
+		for _, bucket := range AllBuckets {
+			if !wanted(buckets, bucket) {
+				continue
+			}
+			c, err := importBucket(tx, bucket, policy, open(bucket), hostIDs, bookIDs)
+			counts[bucket] = c
+			if err != nil {
+				return fmt.Errorf("%s: %w", bucket, err)
+			}
+		}
+		//imported aliases apply to the books that are stored already, like ImportAliases
+		if c := counts[BucketAliases]; c.Added+c.Updated > 0 {
+			_, err := rehash(tx)
+			return err
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// importBucket merges all records of a single bucket, the IDs of merged hosts and books are added to hostIDs and bookIDs
+func importBucket(tx Store, bucket, policy string, next recordReader, hostIDs, bookIDs map[int]int) (MergeCount, error) {
+	var c MergeCount
+	for {
+		var err error
+		switch bucket {
+		case BucketHosts:
+			var h Host
+			if err = next(&h); err != nil {
+				break
+			}
+			existing, findErr := tx.HostByURL(h.URL)
+			oldID := h.ID
+			h.ID = existing.ID
+			err = mergeRecord(findErr == nil, policy, h.LastScrape.After(existing.LastScrape), &c, func() error {
+				return tx.SaveHost(&h)
+			})
+			hostIDs[oldID] = h.ID
+		case BucketScrapes:
+			var r ScrapeRecord
+			if err = next(&r); err != nil {
+				break
+			}
+			err = tx.AddScrapeResult(mapID(hostIDs, r.HostID), r.ScrapeResult)
+			if err == ErrExists {
+				c.Skipped++
+				continue
+			}
+			if err == nil {
+				c.Added++
+			}
+		case BucketBooks:
+			var b Book
+			if err = next(&b); err != nil {
+				break
+			}
+			existing, findErr := tx.BookByHash(b.Hash)
+			oldID := b.ID
+			b.ID = existing.ID
+			b.SourceID = mapID(hostIDs, b.SourceID)
+			if b.DuplicateOf != 0 {
+				b.DuplicateOf = mapID(bookIDs, b.DuplicateOf)
+			}
+			err = mergeRecord(findErr == nil, policy, b.Added.After(existing.Added), &c, func() error {
+				return tx.SaveBook(&b)
+			})
+			bookIDs[oldID] = b.ID
+		case BucketChecked:
+			var ch CheckedID
+			if err = next(&ch); err != nil {
+				break
+			}
+			hostID := mapID(hostIDs, ch.HostID)
+			if found, _ := tx.IsChecked(hostID, ch.BookID); found {
+				c.Skipped++
+				continue
+			}
+			err = tx.MarkChecked(hostID, ch.BookID)
+			c.Added++
+		case BucketAliases:
+			var a Alias
+			if err = next(&a); err != nil {
+				break
+			}
+			existing, findErr := tx.Alias(a.Key)
+			err = mergeRecord(findErr == nil, policy, a.Added.After(existing.Added), &c, func() error {
+				return tx.SaveAlias(&a)
+			})
+		case BucketCatalog:
+			var e CatalogEntry
+			if err = next(&e); err != nil {
+				break
+			}
+			e.HostID = mapID(hostIDs, e.HostID)
+			e.ID = fmt.Sprintf("%d_%d", e.HostID, e.CalibreID)
+			existing, findErr := tx.CatalogEntry(e.ID)
+			err = mergeRecord(findErr == nil, policy, e.Seen.After(existing.Seen), &c, func() error {
+				return tx.SaveCatalogEntry(&e)
+			})
+		}
+		if err == io.EOF {
+			return c, nil
+		}
+		if err != nil {
+			return c, err
+		}
+	}
+}
+
+// mapID returns the new ID of a remapped record, or id itself if it was not remapped
+func mapID(ids map[int]int, id int) int {
+	if mapped, ok := ids[id]; ok {
+		return mapped
+	}
+	return id
+}
+
+// mergeRecord stores v when it is new, or when it exists and the policy allows replacing it
+func mergeRecord(found bool, policy string, newer bool, c *MergeCount, save func() error) error {
+	if found && (policy == MergeSkip || (policy == MergeNewest && !newer)) {
+		c.Skipped++
+		return nil
+	}
+	if found {
+		c.Updated++
+	} else {
+		c.Added++
+	}
+	return save()
+}
+
+func csvHeader(v interface{}) []string {
+	t := reflect.TypeOf(v)
+	header := make([]string, t.NumField())
+	for i := range header {
+		header[i] = t.Field(i).Name
+	}
+	return header
+}
+
+// csvRow formats all fields of a struct, fields that are not a string, number, bool or time are stored as json.
+// Durations are stored as a number of nanoseconds.
+func csvRow(v interface{}) ([]string, error) {
+	rv := reflect.ValueOf(v)
+	row := make([]string, rv.NumField())
+	for i := range row {
+		f := rv.Field(i)
+		switch val := f.Interface().(type) {
+		case time.Time:
+			row[i] = val.Format(time.RFC3339Nano)
+			continue
+		case string:
+			row[i] = val
+			continue
+		}
+		switch f.Kind() {
+		case reflect.Int, reflect.Int64:
+			row[i] = strconv.FormatInt(f.Int(), 10)
+		case reflect.Bool, reflect.Float64:
+			row[i] = fmt.Sprint(f.Interface())
+		default:
+			raw, err := json.Marshal(f.Interface())
+			if err != nil {
+				return nil, err
+			}
+			row[i] = string(raw)
+		}
+	}
+	return row, nil
+}
+
+// csvDecode sets the fields of the struct v points to from a row written by csvRow
+func csvDecode(header, row []string, v interface{}) error {
+	rv := reflect.ValueOf(v).Elem()
+	for i, name := range header {
+		if i >= len(row) {
+			break
+		}
+		f := rv.FieldByName(name)
+		if !f.IsValid() || row[i] == "" {
+			continue
+		}
+		var err error
+		switch f.Interface().(type) {
+		case time.Time:
+			var t time.Time
+			t, err = time.Parse(time.RFC3339Nano, row[i])
+			f.Set(reflect.ValueOf(t))
+		case string:
+			f.SetString(row[i])
+		default:
+			switch f.Kind() {
+			case reflect.Int, reflect.Int64:
+				var n int64
+				n, err = strconv.ParseInt(row[i], 10, 64)
+				f.SetInt(n)
+			case reflect.Bool:
+				var b bool
+				b, err = strconv.ParseBool(row[i])
+				f.SetBool(b)
+			case reflect.Float64:
+				var n float64
+				n, err = strconv.ParseFloat(row[i], 64)
+				f.SetFloat(n)
+			default:
+				err = json.Unmarshal([]byte(row[i]), f.Addr().Interface())
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("field %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package lib_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+var exported = time.Date(2024, 3, 14, 15, 9, 26, 535897932, time.UTC)
+
+// exportFixture fills a store with a record in every bucket, with every field set
+func exportFixture(t *testing.T) lib.Store {
+	t.Helper()
+	s := db.NewMemory()
+	h := lib.Host{
+		URL:               "http://calibre.example.com",
+		Downloads:         12,
+		Scrapes:           3,
+		LastScrape:        exported,
+		LastDownload:      exported.Add(-time.Hour),
+		Added:             exported.Add(-48 * time.Hour),
+		Active:            true,
+		LastRunSuccessful: true,
+		Schedule:          lib.Schedule{Interval: "6h", Jitter: "30m", Window: "01:00-06:00"},
+		AdaptedInterval:   2 * time.Hour,
+		IntervalReason:    "catalog changes often",
+		Health:            lib.HealthDegraded,
+		ConsecutiveFails:  2,
+		BackoffUntil:      exported.Add(time.Hour),
+		DisabledSince:     exported.Add(-time.Minute),
+		LastProbe:         exported.Add(-time.Second),
+	}
+	err := s.SaveHost(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddScrapeResult(h.ID, lib.ScrapeResult{
+		Start:      exported.Add(-time.Minute),
+		End:        exported,
+		Success:    true,
+		Results:    120,
+		Total:      4000,
+		Downloads:  12,
+		Duplicates: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := lib.Book{
+		Added:    exported.Add(-time.Hour),
+		Hash:     "herbertdune",
+		Author:   "Frank Herbert",
+		Title:    "Dune",
+		Path:     "/books/herbertdune.epub",
+		FileHash: "abc",
+	}
+	err = s.SaveBook(&original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := lib.Book{
+		Added:       exported,
+		Hash:        "herbertchildrenofdunethethird",
+		SourceID:    h.ID,
+		Author:      "Frank Herbert",
+		Title:       "Children of Dune, \"the third\"",
+		Languages:   []string{"eng", "nld"},
+		Series:      "Dune",
+		SeriesIndex: 3.5,
+		SeriesKey:   "herbertdune",
+		Path:        "/books/herbertchildrenofdune.epub",
+		FileHash:    "abc",
+		DuplicateOf: original.ID,
+		Local:       true,
+		UUID:        "uuid-children",
+		Identifiers: map[string]string{"isbn": "9780441104024"},
+		Formats:     []string{"epub", "mobi"},
+	}
+	err = s.SaveBook(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.MarkChecked(h.ID, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SaveAlias(&lib.Alias{
+		Key:       "galbraithrobert",
+		Name:      "Robert Galbraith",
+		Canonical: "J. K. Rowling",
+		Added:     exported,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SaveCatalogEntry(&lib.CatalogEntry{
+		ID:          "1_42",
+		HostID:      h.ID,
+		CalibreID:   42,
+		Hash:        "herbertchildrenofdune",
+		Author:      "Frank Herbert",
+		Title:       "Children of Dune",
+		Languages:   []string{"eng"},
+		Series:      "Dune",
+		SeriesIndex: 3,
+		SeriesKey:   "herbertdune",
+		Seen:        exported,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// assertSameRecords compares every bucket of two stores, host and book IDs are compared through their records
+func assertSameRecords(t *testing.T, want, got lib.Store) {
+	t.Helper()
+	wantHosts, _ := want.Hosts()
+	gotHosts, _ := got.Hosts()
+	if !reflect.DeepEqual(gotHosts, wantHosts) {
+		t.Errorf("hosts = %+v, want %+v", gotHosts, wantHosts)
+	}
+	for _, h := range wantHosts {
+		wantResults, _ := want.ScrapeResults(h.ID, 0)
+		gotResults, _ := got.ScrapeResults(h.ID, 0)
+		if !reflect.DeepEqual(gotResults, wantResults) {
+			t.Errorf("scrapes = %+v, want %+v", gotResults, wantResults)
+		}
+	}
+	wantBooks, _ := want.Books()
+	gotBooks, _ := got.Books()
+	if !reflect.DeepEqual(gotBooks, wantBooks) {
+		t.Errorf("books = %+v, want %+v", gotBooks, wantBooks)
+	}
+	wantChecked, _ := want.CheckedIDs()
+	gotChecked, _ := got.CheckedIDs()
+	if !reflect.DeepEqual(gotChecked, wantChecked) {
+		t.Errorf("checked ids = %+v, want %+v", gotChecked, wantChecked)
+	}
+	wantAliases, _ := want.Aliases()
+	gotAliases, _ := got.Aliases()
+	if !reflect.DeepEqual(gotAliases, wantAliases) {
+		t.Errorf("aliases = %+v, want %+v", gotAliases, wantAliases)
+	}
+	wantEntries, _ := want.CatalogEntries()
+	gotEntries, _ := got.CatalogEntries()
+	if !reflect.DeepEqual(gotEntries, wantEntries) {
+		t.Errorf("catalog = %+v, want %+v", gotEntries, wantEntries)
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	s := exportFixture(t)
+	dir := t.TempDir()
+	counts, err := lib.ExportCSV(s, dir, lib.AllBuckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bucket := range lib.AllBuckets {
+		if _, err := os.Stat(filepath.Join(dir, bucket+".csv")); err != nil {
+			t.Errorf("%s was not exported: %v", bucket, err)
+		}
+	}
+
+	imported := db.NewMemory()
+	merged, err := lib.ImportCSV(imported, dir, lib.AllBuckets, lib.MergeSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for bucket, n := range counts {
+		if merged[bucket].Added != n {
+			t.Errorf("%s: imported %d of %d records", bucket, merged[bucket].Added, n)
+		}
+	}
+	assertSameRecords(t, s, imported)
+}
+
+func TestJSONLRoundTrip(t *testing.T) {
+	s := exportFixture(t)
+	var buf bytes.Buffer
+	_, err := lib.ExportJSONL(s, &buf, lib.AllBuckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := db.NewMemory()
+	_, err = lib.ImportJSONL(imported, &buf, lib.AllBuckets, lib.MergeSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameRecords(t, s, imported)
+}
+
+func TestImportMergePolicies(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := lib.ExportJSONL(exportFixture(t), &buf, []string{lib.BucketAliases})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+
+	for _, c := range []struct {
+		policy    string
+		added     time.Time
+		canonical string
+	}{
+		{lib.MergeSkip, exported.Add(-time.Hour), "Someone Else"},
+		{lib.MergeOverwrite, exported.Add(time.Hour), "J. K. Rowling"},
+		{lib.MergeNewest, exported.Add(-time.Hour), "J. K. Rowling"},
+		{lib.MergeNewest, exported.Add(time.Hour), "Someone Else"},
+	} {
+		s := db.NewMemory()
+		err = s.SaveAlias(&lib.Alias{
+			Key:       "galbraithrobert",
+			Name:      "Robert Galbraith",
+			Canonical: "Someone Else",
+			Added:     c.added,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = lib.ImportJSONL(s, bytes.NewReader(raw), []string{lib.BucketAliases}, c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, _ := s.Alias("galbraithrobert")
+		if a.Canonical != c.canonical {
+			t.Errorf("%s with existing alias added at %s: canonical = %s, want %s", c.policy, c.added, a.Canonical, c.canonical)
+		}
+	}
+}
+
+func TestImportAliasesRehashesBooks(t *testing.T) {
+	src := db.NewMemory()
+	if _, err := lib.AddAlias(src, "Robert Galbraith", "J. K. Rowling"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := lib.ExportJSONL(src, &buf, []string{lib.BucketAliases}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := db.NewMemory()
+	b := lib.Book{
+		Author: "Robert Galbraith",
+		Title:  "The Cuckoo's Calling",
+		Hash:   hashOf(t, s, "Robert Galbraith", "The Cuckoo's Calling"),
+	}
+	if err := s.SaveBook(&b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.ImportJSONL(s, &buf, []string{lib.BucketAliases}, lib.MergeSkip); err != nil {
+		t.Fatal(err)
+	}
+	canonical := hashOf(t, s, "J. K. Rowling", "The Cuckoo's Calling")
+	if _, err := s.BookByHash(canonical); err != nil {
+		t.Errorf("stored book was not rehashed after importing the aliases: %v", err)
+	}
+}
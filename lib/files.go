@@ -0,0 +1,200 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DuplicateLink replaces a duplicate file with a hardlink to the original
+	DuplicateLink = "link"
+	// DuplicateSkip removes a duplicate file
+	DuplicateSkip = "skip"
+	// DuplicateKeep keeps duplicate files as they are
+	DuplicateKeep = "keep"
+)
+
+// DuplicateFiles is a set of files with the exact same content
+type DuplicateFiles struct {
+	FileHash   string
+	Size       int64
+	Keep       string
+	Duplicates []string
+}
+
+// FileHash calculates the sha256 of a file
+func FileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// linkFile replaces dst with a hardlink to src
+func linkFile(src, dst string) error {
+	tmp := dst + ".link"
+	err := os.Link(src, tmp)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// dedupeDownload checks if a file with the same content as the downloaded book already exists.
+// If it does the new file is linked or removed depending on mode and the book is merged into the
+// original: it keeps its hash so it is still recognized, but a removed file leaves it without a path.
+func dedupeDownload(s Store, book *Book, mode string) (bool, error) {
+	if book.FileHash == "" || mode == DuplicateKeep {
+		return false, nil
+	}
+	original, err := s.BookByFileHash(book.FileHash)
+	if err != nil {
+		return false, nil
+	}
+	if original.Path == "" || original.Path == book.Path {
+		return false, nil
+	}
+	if _, err := os.Stat(original.Path); err != nil {
+		return false, nil
+	}
+	book.DuplicateOf = original.ID
+	if mode == DuplicateLink {
+		return true, linkFile(original.Path, book.Path)
+	}
+	err = os.Remove(book.Path)
+	book.Path = ""
+	return true, err
+}
+
+// FindDuplicateFiles returns all sets of files in dir that have the exact same content
+func FindDuplicateFiles(dir string) ([]DuplicateFiles, error) {
+	bySize := make(map[int64][]string)
+	modTimes := make(map[string]time.Time)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], path)
+		modTimes[path] = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var sets []DuplicateFiles
+	for size, paths := range bySize {
+		if len(paths) < 2 {
+			continue
+		}
+		//the oldest file is kept
+		sort.Slice(paths, func(i, j int) bool {
+			if modTimes[paths[i]].Equal(modTimes[paths[j]]) {
+				return paths[i] < paths[j]
+			}
+			return modTimes[paths[i]].Before(modTimes[paths[j]])
+		})
+		byHash := make(map[string][]string)
+		for _, p := range paths {
+			fileHash, err := FileHash(p)
+			if err != nil {
+				return nil, err
+			}
+			byHash[fileHash] = append(byHash[fileHash], p)
+		}
+		for fileHash, same := range byHash {
+			if len(same) < 2 {
+				continue
+			}
+			sets = append(sets, DuplicateFiles{
+				FileHash:   fileHash,
+				Size:       size,
+				Keep:       same[0],
+				Duplicates: same[1:],
+			})
+		}
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Keep < sets[j].Keep
+	})
+	return sets, nil
+}
+
+// IsLinked returns true if both paths point to the same file
+func IsLinked(a, b string) bool {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	ib, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ia, ib)
+}
+
+// ReplaceDuplicate replaces dup with a hardlink to keep, or removes it if remove is set.
+// The books that are stored with these files are updated and merged.
+func ReplaceDuplicate(s Store, set DuplicateFiles, dup string, remove bool) error {
+	var err error
+	if remove {
+		err = os.Remove(dup)
+	} else if !IsLinked(set.Keep, dup) {
+		err = linkFile(set.Keep, dup)
+	}
+	if err != nil {
+		return err
+	}
+
+	original, err := bookForFile(s, set.Keep)
+	if err != nil {
+		return nil
+	}
+	if original.FileHash != set.FileHash {
+		original.FileHash = set.FileHash
+		original.Path = set.Keep
+		err = s.SaveBook(&original)
+		if err != nil {
+			return err
+		}
+	}
+	duplicate, err := bookForFile(s, dup)
+	if err != nil || duplicate.ID == original.ID {
+		return nil
+	}
+	duplicate.FileHash = set.FileHash
+	duplicate.DuplicateOf = original.ID
+	if remove {
+		duplicate.Path = ""
+	} else {
+		duplicate.Path = dup
+	}
+	return s.SaveBook(&duplicate)
+}
+
+// bookForFile finds the book that is stored in path, by path or by the hash in the filename
+func bookForFile(s Store, path string) (Book, error) {
+	b, err := s.BookByPath(path)
+	if err == nil {
+		return b, nil
+	}
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	return s.BookByHash(name)
+}
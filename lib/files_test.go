@@ -0,0 +1,149 @@
+package lib_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+// writeFile creates a file with content in dir and returns its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	err := ioutil.WriteFile(p, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// storedOriginal saves a downloaded book with its file hash
+func storedOriginal(t *testing.T, s lib.Store, path string) lib.Book {
+	t.Helper()
+	fileHash, err := lib.FileHash(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := lib.Book{
+		Hash:     "herbertdune",
+		Author:   "Frank Herbert",
+		Title:    "Dune",
+		Path:     path,
+		FileHash: fileHash,
+	}
+	err = s.SaveBook(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDedupeDownloadSkip(t *testing.T) {
+	s := db.NewMemory()
+	dir := t.TempDir()
+	original := storedOriginal(t, s, writeFile(t, dir, "herbertdune.epub", "dune"))
+	dup := writeFile(t, dir, "herbertdunenovel.epub", "dune")
+
+	book := lib.Book{
+		Hash:     "herbertdunenovel",
+		Path:     dup,
+		FileHash: original.FileHash,
+	}
+	found, err := lib.DedupeDownload(s, &book, lib.DuplicateSkip)
+	if err != nil || !found {
+		t.Fatalf("found %v, err %v", found, err)
+	}
+	if book.DuplicateOf != original.ID {
+		t.Errorf("duplicate of = %d, want %d", book.DuplicateOf, original.ID)
+	}
+	if book.Path != "" {
+		t.Errorf("path = %s, want no path", book.Path)
+	}
+	if _, err := os.Stat(dup); !os.IsNotExist(err) {
+		t.Errorf("duplicate file still exists: %v", err)
+	}
+	if _, err := os.Stat(original.Path); err != nil {
+		t.Errorf("original file is gone: %v", err)
+	}
+}
+
+func TestDedupeDownloadLink(t *testing.T) {
+	s := db.NewMemory()
+	dir := t.TempDir()
+	original := storedOriginal(t, s, writeFile(t, dir, "herbertdune.epub", "dune"))
+	dup := writeFile(t, dir, "herbertdunenovel.epub", "dune")
+
+	book := lib.Book{Path: dup, FileHash: original.FileHash}
+	found, err := lib.DedupeDownload(s, &book, lib.DuplicateLink)
+	if err != nil || !found {
+		t.Fatalf("found %v, err %v", found, err)
+	}
+	if book.Path != dup || !lib.IsLinked(original.Path, dup) {
+		t.Errorf("path %s is not linked to %s", book.Path, original.Path)
+	}
+
+	book = lib.Book{Path: writeFile(t, dir, "other.epub", "dune"), FileHash: original.FileHash}
+	found, _ = lib.DedupeDownload(s, &book, lib.DuplicateKeep)
+	if found || book.DuplicateOf != 0 {
+		t.Error("keep mode marked a duplicate")
+	}
+	book = lib.Book{Path: writeFile(t, dir, "messiah.epub", "messiah"), FileHash: "other"}
+	found, _ = lib.DedupeDownload(s, &book, lib.DuplicateSkip)
+	if found {
+		t.Error("a different file was marked as a duplicate")
+	}
+}
+
+func TestReplaceDuplicate(t *testing.T) {
+	for _, remove := range []bool{false, true} {
+		s := db.NewMemory()
+		dir := t.TempDir()
+		keep := writeFile(t, dir, "herbertdune.epub", "dune")
+		dup := writeFile(t, dir, "herbertdunenovel.epub", "dune")
+		writeFile(t, dir, "herbertmessiah.epub", "messiah")
+		original := storedOriginal(t, s, keep)
+		b := lib.Book{Hash: "herbertdunenovel", Path: dup}
+		err := s.SaveBook(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sets, err := lib.FindDuplicateFiles(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sets) != 1 || len(sets[0].Duplicates) != 1 {
+			t.Fatalf("sets = %+v, want one set with one duplicate", sets)
+		}
+		set := sets[0]
+		if set.Keep != keep || set.Duplicates[0] != dup {
+			t.Fatalf("set keeps %s with duplicates %v", set.Keep, set.Duplicates)
+		}
+		err = lib.ReplaceDuplicate(s, set, dup, remove)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		merged, err := s.BookByHash("herbertdunenovel")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if merged.DuplicateOf != original.ID || merged.FileHash != set.FileHash {
+			t.Errorf("remove %v: book is not merged: %+v", remove, merged)
+		}
+		if remove {
+			if merged.Path != "" {
+				t.Errorf("removed duplicate has path %s", merged.Path)
+			}
+			if _, err := os.Stat(dup); !os.IsNotExist(err) {
+				t.Errorf("duplicate file still exists: %v", err)
+			}
+		} else if merged.Path != dup || !lib.IsLinked(keep, dup) {
+			t.Errorf("duplicate %s is not linked to %s", merged.Path, keep)
+		}
+	}
+}
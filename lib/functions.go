@@ -1,16 +1,10 @@
 package lib
 
 import (
-	"regexp"
 	"strconv"
 	"strings"
-
-	"github.com/gnur/demeter/db"
 )
 
-var yearRemove = regexp.MustCompile(`\((1|2)[0-9]{3}\)`)
-var drukRemove = regexp.MustCompile(`(?i)/ druk [0-9]+`)
-
 func intSliceToString(a []int) string {
 	b := make([]string, len(a))
 	for i, v := range a {
@@ -20,42 +14,84 @@ func intSliceToString(a []int) string {
 	return strings.Join(b, ",")
 }
 
-func bookInDatabase(b *CalibreBook) (bool, string) {
-	title := fix(b.Title, true, false)
+// newBook converts a calibre book into the representation that is stored in the database
+func newBook(s Store, b *CalibreBook) Book {
+	return normaliseBook(s, b, nil)
+}
+
+// normaliseBook does the actual conversion of newBook and records every step in t
+func normaliseBook(s Store, b *CalibreBook, t *trace) Book {
+	rs := rulesFor(b.Languages)
+	t.add("title: input", b.Title)
+	title := b.Title
+	if rs.stripSubtitle {
+		title = rs.removeSubtitle(title)
+		t.add("title: strip subtitle", title)
+	}
+	title = fixSteps(title, true, false, rs, "title", t)
+	rawAuthor := "Unknown"
 	var author string
 	if len(b.Authors) == 0 {
 		author = "Unknown"
+		t.add("author: no author", author)
 	} else {
-		author = fix(b.Authors[0], true, true)
+		rawAuthor = b.Authors[0]
+		t.add("author: input", rawAuthor)
+		author = fixSteps(rawAuthor, true, true, rs, "author", t)
+	}
+	author = resolveAuthor(s, author)
+	t.add("author: resolve alias", author)
+	book := Book{
+		Hash:      hashBookSteps(author, title, rs, t),
+		Author:    rawAuthor,
+		Title:     b.Title,
+		Languages: b.Languages,
+		UUID:      b.UUID,
 	}
-	hash := hashBook(author, title)
-	var book Book
-	err := db.Conn.One("Hash", hash, &book)
-	return err == nil, hash
+	if b.Series != "" {
+		book.Series = b.Series
+		book.SeriesIndex = b.SeriesIndex
+		book.SeriesKey = seriesKey(author, b.Series)
+	}
+	return book
+}
+
+// bookInDatabase normalises a calibre book and checks with l if it is already in the database
+func bookInDatabase(s Store, l bookLookup, b *CalibreBook) (bool, Book) {
+	book := newBook(s, b)
+	return l.has(book), book
 }
 
 func fix(s string, capitalize, correctOrder bool) string {
+	return fixSteps(s, capitalize, correctOrder, rulesFor(nil), "", nil)
+}
+
+// fixSteps does the actual work of fix with the given rules, every step is recorded in t with the given prefix
+func fixSteps(s string, capitalize, correctOrder bool, rs ruleSet, prefix string, t *trace) string {
 	if s == "" {
+		t.add(prefix+": empty", "Unknown")
 		return "Unknown"
 	}
 	if capitalize {
 		s = strings.Title(strings.ToLower(s))
 		s = strings.Replace(s, "'S", "'s", -1)
+		t.add(prefix+": capitalize", s)
 	}
 	if correctOrder && strings.Contains(s, ",") {
 		sParts := strings.Split(s, ",")
 		if len(sParts) == 2 {
 			s = strings.TrimSpace(sParts[1]) + " " + strings.TrimSpace(sParts[0])
+			t.add(prefix+": correct order", s)
 		}
 	}
 
-	s = yearRemove.ReplaceAllString(s, "")
-	s = drukRemove.ReplaceAllString(s, "")
+	s = rs.replace(StageClean, prefix, s, t)
 	s = strings.Replace(s, ".", " ", -1)
 	s = strings.Replace(s, "  ", " ", -1)
 	s = strings.TrimSpace(s)
+	t.add(prefix+": remove dots and whitespace", s)
 
-	return strings.Map(func(in rune) rune {
+	s = strings.Map(func(in rune) rune {
 		switch in {
 		case '“', '‹', '”', '›':
 			return '"'
@@ -66,4 +102,6 @@ func fix(s string, capitalize, correctOrder bool) string {
 		}
 		return in
 	}, s)
+	t.add(prefix+": replace quotes", s)
+	return s
 }
@@ -0,0 +1,281 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HealthState is where a host is in the health state machine
+type HealthState string
+
+const (
+	// HealthHealthy hosts are scraped on their schedule, hosts without a state are healthy as well
+	HealthHealthy HealthState = "healthy"
+	// HealthDegraded hosts failed their last scrapes but are still scraped on their schedule
+	HealthDegraded HealthState = "degraded"
+	// HealthBackingOff hosts failed often enough to wait longer and longer between scrapes
+	HealthBackingOff HealthState = "backing-off"
+	// HealthDisabled hosts are no longer scraped, they are probed until they recover
+	HealthDisabled HealthState = "disabled"
+	// HealthDead hosts have been disabled for so long that they are no longer probed
+	HealthDead HealthState = "dead"
+	// HealthOff hosts were disabled by hand, they are not probed and stay off until they are enabled by hand
+	HealthOff HealthState = "off"
+)
+
+// HealthPolicy holds the thresholds of the health state machine. A host becomes degraded, backs off
+// and is disabled after that many failed scrapes in a row. The time between scrapes of a host that
+// backs off starts at BackoffBase and doubles after every failure, up to BackoffMax. Disabled hosts
+// are probed every ProbeEvery and are declared dead when they haven't recovered after DeadAfter.
+type HealthPolicy struct {
+	DegradedAfter int    `json:"degraded_after"`
+	BackoffAfter  int    `json:"backoff_after"`
+	DisableAfter  int    `json:"disable_after"`
+	BackoffBase   string `json:"backoff_base"`
+	BackoffMax    string `json:"backoff_max"`
+	ProbeEvery    string `json:"probe_every"`
+	DeadAfter     string `json:"dead_after"`
+}
+
+// DefaultHealthPolicy returns the health policy that is used when none is configured
+func DefaultHealthPolicy() HealthPolicy {
+	return HealthPolicy{
+		DegradedAfter: 1,
+		BackoffAfter:  3,
+		DisableAfter:  8,
+		BackoffBase:   "1h",
+		BackoffMax:    "48h",
+		ProbeEvery:    "24h",
+		DeadAfter:     "720h",
+	}
+}
+
+// Validate returns an error when the policy can't be used
+func (p HealthPolicy) Validate() error {
+	if p.DegradedAfter < 1 || p.BackoffAfter < p.DegradedAfter || p.DisableAfter < p.BackoffAfter {
+		return errors.New("the thresholds should be at least 1 and in the order degraded_after, backoff_after, disable_after")
+	}
+	for name, v := range map[string]string{
+		"backoff_base": p.BackoffBase,
+		"backoff_max":  p.BackoffMax,
+		"probe_every":  p.ProbeEvery,
+		"dead_after":   p.DeadAfter,
+	} {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("invalid %s: should be positive", name)
+		}
+	}
+	return nil
+}
+
+// HostEvent is a change of the health of a host
+type HostEvent struct {
+	ID     int `storm:"id,increment"`
+	HostID int `storm:"index"`
+	Time   time.Time
+	From   HealthState
+	To     HealthState
+	Reason string
+}
+
+// State returns the health of a host
+func (h Host) State() HealthState {
+	if h.Health == "" {
+		return HealthHealthy
+	}
+	return h.Health
+}
+
+// setHealth moves a host to another state and returns the event for it, it returns nil when the state doesn't change
+func (h *Host) setHealth(to HealthState, reason string, now time.Time) *HostEvent {
+	from := h.State()
+	if from == to {
+		return nil
+	}
+	h.Health = to
+	return &HostEvent{
+		HostID: h.ID,
+		Time:   now,
+		From:   from,
+		To:     to,
+		Reason: reason,
+	}
+}
+
+// Reset makes a host healthy and active again, it is used when a host is enabled by hand
+func (h *Host) Reset(now time.Time) *HostEvent {
+	h.Active = true
+	h.ConsecutiveFails = 0
+	h.BackoffUntil = time.Time{}
+	return h.setHealth(HealthHealthy, "enabled by hand", now)
+}
+
+// Disable turns a host off by hand, it is no longer scraped or probed until it is enabled again
+func (h *Host) Disable(now time.Time) *HostEvent {
+	h.Active = false
+	h.BackoffUntil = time.Time{}
+	h.DisabledSince = now
+	return h.setHealth(HealthOff, "disabled by hand", now)
+}
+
+// scraped moves a host through the state machine after a scrape
+func (p HealthPolicy) scraped(h *Host, success bool, now time.Time) *HostEvent {
+	if success {
+		h.ConsecutiveFails = 0
+		h.BackoffUntil = time.Time{}
+		return h.setHealth(HealthHealthy, "scrape succeeded", now)
+	}
+	h.ConsecutiveFails++
+	reason := fmt.Sprintf("%d failed scrapes in a row", h.ConsecutiveFails)
+	switch {
+	case h.ConsecutiveFails >= p.DisableAfter:
+		h.Active = false
+		h.BackoffUntil = time.Time{}
+		h.DisabledSince = now
+		return h.setHealth(HealthDisabled, reason, now)
+	case h.ConsecutiveFails >= p.BackoffAfter:
+		h.BackoffUntil = now.Add(p.backoff(h.ConsecutiveFails - p.BackoffAfter))
+		return h.setHealth(HealthBackingOff, reason, now)
+	case h.ConsecutiveFails >= p.DegradedAfter:
+		return h.setHealth(HealthDegraded, reason, now)
+	}
+	return nil
+}
+
+// backoff returns the extra time a host waits after the nth failure since it started backing off
+func (p HealthPolicy) backoff(n int) time.Duration {
+	base, _ := time.ParseDuration(p.BackoffBase)
+	max, _ := time.ParseDuration(p.BackoffMax)
+	d := base
+	for i := 0; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// probeDue returns true if a disabled host should be probed at now
+func (p HealthPolicy) probeDue(h Host, now time.Time) bool {
+	if h.State() != HealthDisabled {
+		return false
+	}
+	every, _ := time.ParseDuration(p.ProbeEvery)
+	last := h.LastProbe
+	if last.Before(h.DisabledSince) {
+		last = h.DisabledSince
+	}
+	return !last.Add(every).After(now)
+}
+
+// probed moves a disabled host through the state machine after a probe
+func (p HealthPolicy) probed(h *Host, err error, now time.Time) *HostEvent {
+	h.LastProbe = now
+	if err == nil {
+		h.Active = true
+		h.ConsecutiveFails = 0
+		return h.setHealth(HealthHealthy, "probe succeeded", now)
+	}
+	dead, _ := time.ParseDuration(p.DeadAfter)
+	if !h.DisabledSince.Add(dead).After(now) {
+		return h.setHealth(HealthDead, fmt.Sprintf("not reachable since %s: %s", h.DisabledSince.Format(time.RFC3339), err), now)
+	}
+	return nil
+}
+
+// probeTimeout is how long a probe waits for a host, probes are cheap so a host that takes longer is not reachable
+const probeTimeout = 30 * time.Second
+
+// Probe checks if a host is reachable by asking it for a single book ID
+func (a *App) Probe(ctx context.Context, h Host) error {
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return err
+	}
+	u.Path = "/ajax/search"
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	_, err = a.getIDS(ctx, *u, 0, 1)
+	return err
+}
+
+// ProbeHosts probes all disabled hosts that are due for a probe at the same time and enables
+// the ones that have recovered. Probes that are cut short by ctx don't count.
+func (a *App) ProbeHosts(ctx context.Context, p Policy) error {
+	hosts, err := a.Store.Hosts()
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(hosts))
+	for _, h := range hosts {
+		if !p.Health.probeDue(h, time.Now()) {
+			continue
+		}
+		wg.Add(1)
+		go func(h Host) {
+			defer wg.Done()
+			perr := a.Probe(ctx, h)
+			if ctx.Err() != nil {
+				return
+			}
+			e := p.Health.probed(&h, perr, time.Now())
+			err := SaveHostHealth(a.Store, &h, e)
+			if err != nil {
+				errs <- err
+				return
+			}
+			log.WithFields(log.Fields{
+				"host":   h.URL,
+				"health": h.State(),
+				"err":    perr,
+			}).Debug("Probed host")
+		}(h)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// SaveHostHealth stores a host together with the event of its last transition, if there is one
+func SaveHostHealth(s Store, h *Host, e *HostEvent) error {
+	return s.Update(func(tx Store) error {
+		err := tx.SaveHost(h)
+		if err != nil || e == nil {
+			return err
+		}
+		logTransition(h, e)
+		return tx.AddHostEvent(e)
+	})
+}
+
+// logTransition logs a change of the health of a host
+func logTransition(h *Host, e *HostEvent) {
+	l := log.WithFields(log.Fields{
+		"host":   h.URL,
+		"from":   e.From,
+		"to":     e.To,
+		"reason": e.Reason,
+	})
+	if e.To == HealthDisabled || e.To == HealthDead {
+		l.Warning("Host health changed")
+		return
+	}
+	l.Info("Host health changed")
+}
+
+// Print prints a health event
+func (e HostEvent) Print() {
+	fmt.Printf(" - %s  %s -> %s: %s\n", e.Time.Format(time.RFC3339), e.From, e.To, e.Reason)
+}
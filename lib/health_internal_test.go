@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHealthScraped(t *testing.T) {
+	p := DefaultHealthPolicy()
+	now := time.Date(2024, 3, 14, 15, 0, 0, 0, time.UTC)
+	h := Host{ID: 1, Active: true}
+
+	var states []HealthState
+	var backoffs []time.Duration
+	for i := 0; i < p.DisableAfter; i++ {
+		e := p.scraped(&h, false, now)
+		if e != nil {
+			states = append(states, e.To)
+		}
+		if !h.BackoffUntil.IsZero() {
+			backoffs = append(backoffs, h.BackoffUntil.Sub(now))
+		}
+	}
+	want := []HealthState{HealthDegraded, HealthBackingOff, HealthDisabled}
+	if len(states) != len(want) {
+		t.Fatalf("transitions %v, want %v", states, want)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Errorf("transitions %v, want %v", states, want)
+		}
+	}
+	//the backoff doubles after every failure and stops at the maximum
+	wantBackoffs := []time.Duration{time.Hour, 2 * time.Hour, 4 * time.Hour, 8 * time.Hour, 16 * time.Hour}
+	if len(backoffs) != len(wantBackoffs) {
+		t.Fatalf("backoffs %v, want %v", backoffs, wantBackoffs)
+	}
+	for i := range wantBackoffs {
+		if backoffs[i] != wantBackoffs[i] {
+			t.Errorf("backoffs %v, want %v", backoffs, wantBackoffs)
+		}
+	}
+	if p.backoff(10) != 48*time.Hour {
+		t.Errorf("backoff %v, want the maximum", p.backoff(10))
+	}
+	if h.Active || !h.DisabledSince.Equal(now) || !h.BackoffUntil.IsZero() {
+		t.Errorf("disabled host %+v", h)
+	}
+
+	h = Host{ID: 1, Active: true, ConsecutiveFails: 4, Health: HealthBackingOff, BackoffUntil: now}
+	e := p.scraped(&h, true, now)
+	if e == nil || e.From != HealthBackingOff || e.To != HealthHealthy {
+		t.Errorf("event %+v, want a recovery", e)
+	}
+	if h.ConsecutiveFails != 0 || !h.BackoffUntil.IsZero() {
+		t.Errorf("recovered host %+v", h)
+	}
+	if e = p.scraped(&h, true, now); e != nil {
+		t.Errorf("event %+v for a host that stays healthy", e)
+	}
+}
+
+func TestHealthProbed(t *testing.T) {
+	p := DefaultHealthPolicy()
+	since := time.Date(2024, 3, 14, 15, 0, 0, 0, time.UTC)
+	h := Host{ID: 1, Health: HealthDisabled, DisabledSince: since}
+
+	if p.probeDue(h, since.Add(23*time.Hour)) {
+		t.Error("probe due before probe_every passed")
+	}
+	if !p.probeDue(h, since.Add(24*time.Hour)) {
+		t.Error("probe not due after probe_every passed")
+	}
+	if p.probeDue(Host{ID: 2, Active: true}, since) {
+		t.Error("probe due for a healthy host")
+	}
+
+	down := errors.New("down")
+	if e := p.probed(&h, down, since.Add(24*time.Hour)); e != nil {
+		t.Errorf("event %+v for a failed probe", e)
+	}
+	if p.probeDue(h, since.Add(47*time.Hour)) {
+		t.Error("probe due right after the last probe")
+	}
+	e := p.probed(&h, down, since.Add(720*time.Hour))
+	if e == nil || e.To != HealthDead {
+		t.Errorf("event %+v, want the host to be dead", e)
+	}
+	if p.probeDue(h, since.Add(1000*time.Hour)) {
+		t.Error("probe due for a dead host")
+	}
+
+	//a host that was disabled automatically and then by hand is left alone
+	h = Host{ID: 1, Health: HealthDisabled, DisabledSince: since}
+	e = h.Disable(since.Add(time.Hour))
+	if e == nil || e.From != HealthDisabled || e.To != HealthOff || h.Active {
+		t.Errorf("host %+v after event %+v, want it off", h, e)
+	}
+	if p.probeDue(h, since.Add(1000*time.Hour)) {
+		t.Error("probe due for a host that was disabled by hand")
+	}
+	if e = h.Reset(since.Add(2 * time.Hour)); e == nil || e.To != HealthHealthy || !h.Active {
+		t.Errorf("host %+v after event %+v, want it enabled", h, e)
+	}
+
+	h = Host{ID: 1, Health: HealthDisabled, DisabledSince: since, ConsecutiveFails: 8}
+	e = p.probed(&h, nil, since.Add(24*time.Hour))
+	if e == nil || e.To != HealthHealthy || !h.Active || h.ConsecutiveFails != 0 {
+		t.Errorf("host %+v after event %+v, want it enabled", h, e)
+	}
+}
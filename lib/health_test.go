@@ -0,0 +1,124 @@
+package lib_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+func TestHealthPolicyValidate(t *testing.T) {
+	if err := lib.DefaultHealthPolicy().Validate(); err != nil {
+		t.Errorf("default policy: %v", err)
+	}
+	for _, change := range []func(*lib.HealthPolicy){
+		func(p *lib.HealthPolicy) { p.DegradedAfter = 0 },
+		func(p *lib.HealthPolicy) { p.BackoffAfter = 0 },
+		func(p *lib.HealthPolicy) { p.DisableAfter = 2 },
+		func(p *lib.HealthPolicy) { p.BackoffBase = "soon" },
+		func(p *lib.HealthPolicy) { p.DeadAfter = "0s" },
+	} {
+		p := lib.DefaultHealthPolicy()
+		change(&p)
+		if err := p.Validate(); err == nil {
+			t.Errorf("%+v is valid, want an error", p)
+		}
+	}
+}
+
+func TestProbeHosts(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"total_num": 1, "book_ids": [1]}`))
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	s := db.NewMemory()
+	since := time.Now().Add(-48 * time.Hour)
+	recovered := lib.Host{URL: up.URL, Health: lib.HealthDisabled, DisabledSince: since}
+	broken := lib.Host{URL: down.URL, Health: lib.HealthDisabled, DisabledSince: since}
+	//a host that is off would answer, but it was disabled by hand
+	off := lib.Host{URL: up.URL + "/off", Health: lib.HealthOff, DisabledSince: since}
+	for _, h := range []*lib.Host{&recovered, &broken, &off} {
+		if err := s.SaveHost(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := &lib.App{Store: s, Timeout: time.Minute}
+	if err := a.ProbeHosts(context.Background(), lib.Policy{Health: lib.DefaultHealthPolicy()}); err != nil {
+		t.Fatal(err)
+	}
+
+	h, _ := s.Host(recovered.ID)
+	if !h.Active || h.State() != lib.HealthHealthy {
+		t.Errorf("recovered host is %s, active %v", h.State(), h.Active)
+	}
+	events, _ := s.HostEvents(recovered.ID)
+	if len(events) != 1 || events[0].To != lib.HealthHealthy {
+		t.Errorf("events of the recovered host = %+v", events)
+	}
+	h, _ = s.Host(broken.ID)
+	if h.Active || h.State() != lib.HealthDisabled || h.LastProbe.IsZero() {
+		t.Errorf("broken host %+v, want it disabled and probed", h)
+	}
+	h, _ = s.Host(off.ID)
+	if h.Active || h.State() != lib.HealthOff || !h.LastProbe.IsZero() {
+		t.Errorf("host that is off %+v, want it left alone", h)
+	}
+}
+
+func TestProbeHostsInParallel(t *testing.T) {
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer hanging.Close()
+	defer close(release)
+
+	s := db.NewMemory()
+	since := time.Now().Add(-48 * time.Hour)
+	for i := 0; i < 5; i++ {
+		h := lib.Host{URL: hanging.URL + "/" + string(rune('a'+i)), Health: lib.HealthDisabled, DisabledSince: since}
+		if err := s.SaveHost(&h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := &lib.App{Store: s, Timeout: 200 * time.Millisecond}
+	start := time.Now()
+	if err := a.ProbeHosts(context.Background(), lib.Policy{Health: lib.DefaultHealthPolicy()}); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > 900*time.Millisecond {
+		t.Errorf("probing took %v, the hosts were probed one by one", took)
+	}
+
+	//probes that are cut short by stopping demeter don't count
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hosts, _ := s.Hosts()
+	for _, h := range hosts {
+		h.LastProbe = time.Time{}
+		if err := s.SaveHost(&h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.ProbeHosts(ctx, lib.Policy{Health: lib.DefaultHealthPolicy()}); err != nil {
+		t.Fatal(err)
+	}
+	hosts, _ = s.Hosts()
+	for _, h := range hosts {
+		if !h.LastProbe.IsZero() {
+			t.Errorf("host %s was probed after demeter stopped", h.URL)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package lib
+
+import "time"
+
+// Retention decides how long scrape results are kept. A result is kept when it is one of
+// the last KeepRuns results of its host, or when it is less than KeepDays old.
+// Everything is kept when both are 0.
+type Retention struct {
+	KeepRuns int `json:"keep_runs"`
+	KeepDays int `json:"keep_days"`
+}
+
+// DefaultRetention returns the retention that is used when none is configured
+func DefaultRetention() Retention {
+	return Retention{
+		KeepRuns: 50,
+		KeepDays: 30,
+	}
+}
+
+// keepSince returns the start time from which all results are kept
+func (r Retention) keepSince(now time.Time) time.Time {
+	return now.AddDate(0, 0, -r.KeepDays)
+}
+
+// RecordScrape stores a host together with the result of its latest scrape
+// and removes the results that fall outside the retention
+func RecordScrape(s Store, h *Host, r ScrapeResult, keep Retention) error {
+	return s.Update(func(tx Store) error {
+		err := tx.SaveHost(h)
+		if err != nil {
+			return err
+		}
+		err = tx.AddScrapeResult(h.ID, r)
+		if err != nil {
+			return err
+		}
+		if keep.KeepRuns <= 0 && keep.KeepDays <= 0 {
+			return nil
+		}
+		_, err = tx.PruneScrapeResults(h.ID, keep.KeepRuns, keep.keepSince(time.Now()))
+		return err
+	})
+}
+
+// Add adds a scrape result to the aggregate of its day
+func (d ScrapeDay) Add(r ScrapeResult) ScrapeDay {
+	d.Date = ScrapeDate(r.Start)
+	d.Runs++
+	if !r.Success {
+		d.Fails++
+	}
+	if r.Results > d.MaxResults {
+		d.MaxResults = r.Results
+	}
+	d.Downloads += r.Downloads
+	d.Duplicates += r.Duplicates
+	d.Duration += r.End.Sub(r.Start)
+	return d
+}
+
+// ScrapeDate returns the day a scrape result is aggregated in
+func ScrapeDate(start time.Time) string {
+	return start.Format("2006-01-02")
+}
@@ -0,0 +1,54 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+func TestRecordScrapeRetention(t *testing.T) {
+	now := time.Now()
+	for _, c := range []struct {
+		keep lib.Retention
+		want int
+	}{
+		{lib.Retention{}, 10},
+		{lib.Retention{KeepRuns: 3}, 3},
+		{lib.Retention{KeepDays: 4}, 4},
+		{lib.Retention{KeepRuns: 7, KeepDays: 4}, 7},
+	} {
+		s := db.NewMemory()
+		h := lib.Host{URL: "http://calibre.example.com"}
+		for i := 9; i >= 0; i-- {
+			start := now.AddDate(0, 0, -i).Add(-time.Minute)
+			h.Scrapes++
+			err := lib.RecordScrape(s, &h, lib.ScrapeResult{
+				Start:     start,
+				End:       start.Add(time.Second),
+				Success:   i != 0,
+				Downloads: 2,
+			}, c.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		results, _ := s.ScrapeResults(h.ID, 0)
+		if len(results) != c.want {
+			t.Errorf("%+v kept %d results, want %d", c.keep, len(results), c.want)
+		}
+		days, _ := s.ScrapeDays(h.ID)
+		if len(days) != 10 {
+			t.Errorf("%+v kept %d days, want 10", c.keep, len(days))
+		}
+		stored, _ := s.Host(h.ID)
+		if stored.Scrapes != 10 {
+			t.Errorf("host scrapes = %d, want 10", stored.Scrapes)
+		}
+		fails, downloads := h.Stats(s, 2)
+		if fails != 1 || downloads != 4 {
+			t.Errorf("stats of last 2 runs = %d fails, %d downloads", fails, downloads)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package lib
+
+// These hooks expose unexported functions to the tests in lib_test.
+var (
+	DedupeDownload = dedupeDownload
+)
+
+// BookInDatabase normalises a book and looks it up in s one query at a time
+func BookInDatabase(s Store, b *CalibreBook) bool {
+	present, _ := bookInDatabase(s, storeLookup{s}, b)
+	return present
+}
+
+// FilterOldIDs marks the IDs of cp as checked and keeps the ones that weren't checked before
+func (a *App) FilterOldIDs(cp *ScrapeCheckpoint) {
+	a.filterOldIDs(cp)
+}
+
+// NewOnPage returns how many books of a page are not in the database
+func (a *App) NewOnPage(bs BooksQueryResult) (int, error) {
+	page, err := a.checkPage(bs)
+	found := 0
+	for _, p := range page {
+		if !p.present {
+			found++
+		}
+	}
+	return found, err
+}
@@ -0,0 +1,118 @@
+package lib
+
+// RemovedHost counts the records that were removed together with a host
+type RemovedHost struct {
+	CheckedIDs     int
+	ScrapeResults  int
+	CatalogEntries int
+	Books          int
+	Downloads      int
+	// PassedDownloads were passed to a fallback source on another host instead of being removed
+	PassedDownloads int
+}
+
+// RemoveHost removes a host and every record that was stored for it in a single transaction.
+// The scrape and health history is left alone when keepHistory is set. With purgeBooks the books that were
+// downloaded from the host are removed as well, unless another host has them in its catalog.
+// Only the records of purged books are removed, their files are kept. Queued downloads are passed to
+// the first of their fallback sources that is active, only the ones without such a fallback are removed.
+func RemoveHost(s Store, id int, keepHistory, purgeBooks bool) (RemovedHost, error) {
+	var r RemovedHost
+	err := s.Update(func(tx Store) error {
+		_, err := tx.Host(id)
+		if err != nil {
+			return err
+		}
+		if purgeBooks {
+			r.Books, err = purgeHostBooks(tx, id)
+			if err != nil {
+				return err
+			}
+		}
+		r.CheckedIDs, err = tx.ClearChecked(id)
+		if err != nil {
+			return err
+		}
+		err = tx.DeleteCheckpoint(id)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		var left []QueuedDownload
+		r.PassedDownloads, left, err = passClaims(tx, id, func(hostID int) bool {
+			return hostID != id && hostActive(tx, hostID)
+		})
+		if err != nil {
+			return err
+		}
+		for _, d := range left {
+			err = tx.DeleteQueuedDownload(d.ID)
+			if err != nil {
+				return err
+			}
+			r.Downloads++
+		}
+		if !keepHistory {
+			r.ScrapeResults, err = tx.ClearScrapeResults(id)
+			if err != nil {
+				return err
+			}
+			events, err := tx.HostEvents(id)
+			if err != nil {
+				return err
+			}
+			for _, e := range events {
+				err = tx.DeleteHostEvent(e.ID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		entries, err := tx.Catalog(id)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			err = tx.DeleteCatalogEntry(e.ID)
+			if err != nil {
+				return err
+			}
+			r.CatalogEntries++
+		}
+		return tx.DeleteHost(id)
+	})
+	if err != nil {
+		return RemovedHost{}, err
+	}
+	return r, nil
+}
+
+// purgeHostBooks removes the books that were downloaded from a host and are not
+// in the catalog of any other host
+func purgeHostBooks(tx Store, id int) (int, error) {
+	entries, err := tx.CatalogEntries()
+	if err != nil {
+		return 0, err
+	}
+	elsewhere := make(map[string]bool)
+	for _, e := range entries {
+		if e.HostID != id {
+			elsewhere[e.Hash] = true
+		}
+	}
+	books, err := tx.Books()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, b := range books {
+		if b.SourceID != id || b.Local || elsewhere[b.Hash] {
+			continue
+		}
+		err = tx.DeleteBook(b.ID)
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,166 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+// hostState fills a store with two hosts that both have a record of every kind
+func hostState(t *testing.T) (lib.Store, lib.Host, lib.Host) {
+	t.Helper()
+	s := db.NewMemory()
+	a := lib.Host{URL: "http://a.example.com"}
+	b := lib.Host{URL: "http://b.example.com"}
+	start := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)
+	for i, h := range []*lib.Host{&a, &b} {
+		if err := s.SaveHost(h); err != nil {
+			t.Fatal(err)
+		}
+		for _, err := range []error{
+			s.MarkChecked(h.ID, 1),
+			s.MarkChecked(h.ID, 2),
+			s.AddScrapeResult(h.ID, lib.ScrapeResult{Start: start, End: start.Add(time.Minute)}),
+			s.AddHostEvent(&lib.HostEvent{HostID: h.ID, Time: start, From: lib.HealthHealthy, To: lib.HealthDegraded}),
+			s.SaveCheckpoint(&lib.ScrapeCheckpoint{HostID: h.ID, Start: start}),
+			s.SaveQueuedDownload(&lib.QueuedDownload{HostID: h.ID, Hash: "queued" + h.URL}),
+			s.SaveCatalogEntry(&lib.CatalogEntry{ID: h.URL + "_1", HostID: h.ID, CalibreID: 1, Hash: "shared"}),
+			s.SaveCatalogEntry(&lib.CatalogEntry{ID: h.URL + "_2", HostID: h.ID, CalibreID: 2, Hash: "only" + h.URL}),
+		} {
+			if err != nil {
+				t.Fatalf("host %d: %v", i, err)
+			}
+		}
+	}
+	for _, book := range []lib.Book{
+		{Hash: "shared", SourceID: a.ID},
+		{Hash: "only" + a.URL, SourceID: a.ID},
+		{Hash: "local", SourceID: a.ID, Local: true},
+		{Hash: "only" + b.URL, SourceID: b.ID},
+	} {
+		book := book
+		if err := s.SaveBook(&book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, a, b
+}
+
+func TestRemoveHost(t *testing.T) {
+	s, a, b := hostState(t)
+	r, err := lib.RemoveHost(s, a.ID, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := lib.RemovedHost{CheckedIDs: 2, ScrapeResults: 1, CatalogEntries: 2, Downloads: 1}
+	if r != want {
+		t.Errorf("removed %+v, want %+v", r, want)
+	}
+	if _, err = s.Host(a.ID); err != lib.ErrNotFound {
+		t.Errorf("host still exists: %v", err)
+	}
+	if found, _ := s.IsChecked(a.ID, 1); found {
+		t.Error("checked ids of the host are left")
+	}
+	if events, _ := s.HostEvents(a.ID); len(events) != 0 {
+		t.Errorf("events are left: %+v", events)
+	}
+	if _, err = s.Checkpoint(a.ID); err != lib.ErrNotFound {
+		t.Errorf("checkpoint is left: %v", err)
+	}
+	if books, _ := s.Books(); len(books) != 4 {
+		t.Errorf("books = %d, want all 4", len(books))
+	}
+
+	//the other host is untouched
+	if found, _ := s.IsChecked(b.ID, 1); !found {
+		t.Error("checked ids of the other host were removed")
+	}
+	for name, n := range map[string]func() int{
+		"results": func() int { r, _ := s.ScrapeResults(b.ID, 0); return len(r) },
+		"events":  func() int { e, _ := s.HostEvents(b.ID); return len(e) },
+		"queue":   func() int { q, _ := s.HostQueue(b.ID); return len(q) },
+		"catalog": func() int { c, _ := s.Catalog(b.ID); return len(c) },
+	} {
+		if n() == 0 {
+			t.Errorf("%s of the other host were removed", name)
+		}
+	}
+}
+
+func TestRemoveHostKeepHistoryAndPurge(t *testing.T) {
+	s, a, _ := hostState(t)
+	r, err := lib.RemoveHost(s, a.ID, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ScrapeResults != 0 || r.Books != 1 {
+		t.Errorf("removed %+v, want no history and 1 book", r)
+	}
+	if results, _ := s.ScrapeResults(a.ID, 0); len(results) != 1 {
+		t.Errorf("history was removed: %+v", results)
+	}
+	if events, _ := s.HostEvents(a.ID); len(events) != 1 {
+		t.Errorf("events were removed: %+v", events)
+	}
+	if _, err = s.BookByHash("only" + a.URL); err != lib.ErrNotFound {
+		t.Errorf("book of only this host was kept: %v", err)
+	}
+	for _, hash := range []string{"shared", "local"} {
+		if _, err = s.BookByHash(hash); err != nil {
+			t.Errorf("book %s was purged: %v", hash, err)
+		}
+	}
+}
+
+func TestRemoveMissingHost(t *testing.T) {
+	s, _, _ := hostState(t)
+	if _, err := lib.RemoveHost(s, 42, false, true); err != lib.ErrNotFound {
+		t.Errorf("err = %v, want %v", err, lib.ErrNotFound)
+	}
+	if books, _ := s.Books(); len(books) != 4 {
+		t.Errorf("books = %d, want all 4", len(books))
+	}
+}
+
+func TestRemoveHostPassesQueue(t *testing.T) {
+	s := db.NewMemory()
+	removed := lib.Host{URL: "http://removed.example.com", Active: true}
+	fallback := lib.Host{URL: "http://fallback.example.com", Active: true}
+	inactive := lib.Host{URL: "http://inactive.example.com"}
+	for _, h := range []*lib.Host{&removed, &fallback, &inactive} {
+		if err := s.SaveHost(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	passed := lib.QueuedDownload{HostID: removed.ID, Hash: "passed", Fallbacks: []lib.QueuedDownload{
+		{HostID: inactive.ID, Hash: "passed"},
+		{HostID: fallback.ID, Hash: "passed", URL: fallback.URL + "/get/epub/1"},
+	}}
+	dropped := lib.QueuedDownload{HostID: removed.ID, Hash: "dropped", Fallbacks: []lib.QueuedDownload{
+		{HostID: inactive.ID, Hash: "dropped"},
+	}}
+	for _, d := range []*lib.QueuedDownload{&passed, &dropped} {
+		if err := s.SaveQueuedDownload(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := lib.RemoveHost(s, removed.ID, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.PassedDownloads != 1 || r.Downloads != 1 {
+		t.Errorf("removed %+v, want 1 download passed and 1 removed", r)
+	}
+	queue, _ := s.Queue()
+	if len(queue) != 1 {
+		t.Fatalf("queue holds %v, want only the passed download", hashes(queue))
+	}
+	d := queue[0]
+	if d.ID != passed.ID || d.HostID != fallback.ID || d.URL != fallback.URL+"/get/epub/1" || len(d.Fallbacks) != 0 {
+		t.Errorf("queued %+v, want it passed to the active fallback", d)
+	}
+}
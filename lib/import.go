@@ -0,0 +1,250 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// importBatchSize is the number of books that are stored in a single transaction
+const importBatchSize = 100
+
+// EbookExtensions holds the extensions of the files that are imported by default
+var EbookExtensions = []string{"epub", "mobi", "azw", "azw3", "pdf", "fb2", "djvu", "cbz", "cbr", "txt", "rtf", "docx"}
+
+// ImportResult summarises an import
+type ImportResult struct {
+	Scanned   int
+	Imported  int
+	Present   int
+	Conflicts int
+	Failed    int
+}
+
+type importedFile struct {
+	path string
+	book Book
+	err  error
+}
+
+// ReadLocalBook reads the metadata of a book file, epubs are read from their OPF metadata
+// and all other files from their filename
+func ReadLocalBook(filePath string) (CalibreBook, error) {
+	if strings.EqualFold(filepath.Ext(filePath), ".epub") {
+		b, err := readEpub(filePath)
+		if err == nil {
+			return b, nil
+		}
+		log.WithFields(log.Fields{
+			"path": filePath,
+			"err":  err,
+		}).Debug("Could not read epub metadata, falling back to the filename")
+	}
+	return bookFromFilename(filePath), nil
+}
+
+// bookFromFilename guesses the author and title of a book from its path. It understands
+// "Author - Title.ext" and the calibre layout "Author/Title (id)/Title - Author.ext".
+func bookFromFilename(filePath string) CalibreBook {
+	name := filepath.Base(filePath)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = strings.Replace(name, "_", " ", -1)
+	authorDir := filepath.Base(filepath.Dir(filepath.Dir(filePath)))
+
+	parts := strings.Split(name, " - ")
+	if len(parts) < 2 {
+		return CalibreBook{
+			Title: strings.TrimSpace(name),
+		}
+	}
+	last := strings.TrimSpace(parts[len(parts)-1])
+	if strings.EqualFold(last, authorDir) {
+		return CalibreBook{
+			Title:   strings.TrimSpace(strings.Join(parts[:len(parts)-1], " - ")),
+			Authors: []string{last},
+		}
+	}
+	return CalibreBook{
+		Title:   strings.TrimSpace(strings.Join(parts[1:], " - ")),
+		Authors: []string{strings.TrimSpace(parts[0])},
+	}
+}
+
+// ImportDir walks dir and adds every book file it finds to the database as a locally owned book.
+// Files are read by the given number of workers, nothing is stored when dryRun is set.
+func ImportDir(s Store, dir string, extensions []string, workers int, dryRun bool) (ImportResult, error) {
+	var r ImportResult
+	wanted := make(map[string]bool, len(extensions))
+	for _, ext := range extensions {
+		wanted["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+
+	paths := make(chan string)
+	files := make(chan importedFile)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				files <- readImportFile(s, p)
+			}
+		}()
+	}
+
+	dir, walkErr := filepath.Abs(dir)
+	if walkErr != nil {
+		return r, walkErr
+	}
+	go func() {
+		walkErr = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.WithFields(log.Fields{
+					"path": p,
+					"err":  err,
+				}).Warning("Could not read path")
+				return nil
+			}
+			if info.Mode().IsRegular() && wanted[strings.ToLower(filepath.Ext(p))] {
+				paths <- p
+			}
+			return nil
+		})
+		close(paths)
+		wg.Wait()
+		close(files)
+	}()
+
+	im := newImporter(s, dryRun)
+	var err error
+	for f := range files {
+		if f.err != nil {
+			im.r.Scanned++
+			im.r.Failed++
+			log.WithFields(log.Fields{
+				"path": f.path,
+				"err":  f.err,
+			}).Warning("Could not read book")
+			continue
+		}
+		err = im.add(f.book)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = im.flush()
+	}
+	//drain the remaining files if storing failed
+	for range files {
+	}
+	if err != nil {
+		return im.r, err
+	}
+	return im.r, walkErr
+}
+
+// importer stores imported books in batches and keeps track of conflicts
+type importer struct {
+	store  Store
+	dryRun bool
+	seen   map[string]string
+	batch  []Book
+	r      ImportResult
+}
+
+func newImporter(s Store, dryRun bool) *importer {
+	return &importer{
+		store:  s,
+		dryRun: dryRun,
+		seen:   make(map[string]string),
+	}
+}
+
+// add imports a single book, unless it conflicts with a book in the database or this import
+func (im *importer) add(book Book) error {
+	im.r.Scanned++
+	l := log.WithFields(log.Fields{
+		"path": book.Path,
+		"hash": book.Hash,
+	})
+	if other, ok := im.seen[book.Hash]; ok {
+		im.r.Conflicts++
+		l.WithField("other", other).Warning("Conflict: another book in this import has the same hash")
+		return nil
+	}
+	im.seen[book.Hash] = book.Path
+
+	if book.UUID != "" {
+		if _, err := im.store.BookByUUID(book.UUID); err == nil {
+			im.r.Present++
+			return nil
+		}
+	}
+	if existing, err := im.store.BookByHash(book.Hash); err == nil {
+		if existing.Path == book.Path {
+			im.r.Present++
+			return nil
+		}
+		im.r.Conflicts++
+		l.WithFields(log.Fields{
+			"existing": existing.ID,
+			"other":    existing.Path,
+		}).Warning("Conflict: book is already in the database")
+		return nil
+	}
+	im.r.Imported++
+	l.Debug("Importing book")
+	if im.dryRun {
+		return nil
+	}
+	im.batch = append(im.batch, book)
+	if len(im.batch) >= importBatchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+// flush stores all books that are waiting to be stored
+func (im *importer) flush() error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	err := saveBooks(im.store, im.batch)
+	im.batch = nil
+	return err
+}
+
+func readImportFile(s Store, p string) importedFile {
+	f := importedFile{
+		path: p,
+	}
+	cb, err := ReadLocalBook(p)
+	if err != nil {
+		f.err = err
+		return f
+	}
+	f.book = newBook(s, &cb)
+	f.book.Added = time.Now()
+	f.book.Local = true
+	f.book.Path = p
+	f.book.FileHash, f.err = FileHash(p)
+	return f
+}
+
+// saveBooks stores books in a single transaction
+func saveBooks(s Store, books []Book) error {
+	return s.Update(func(tx Store) error {
+		for i := range books {
+			err := tx.SaveBook(&books[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,145 @@
+package lib_test
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+const testOPF = `<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:title>Children of Dune</dc:title>
+    <dc:creator opf:role="aut">Frank Herbert</dc:creator>
+    <dc:creator opf:role="ill">John Schoenherr</dc:creator>
+    <dc:language>en-US</dc:language>
+    <meta name="calibre:series" content="Dune"/>
+    <meta name="calibre:series_index" content="3.0"/>
+  </metadata>
+</package>`
+
+// writeEpub creates a minimal epub with the given OPF metadata
+func writeEpub(t *testing.T, p, opf string) {
+	t.Helper()
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z := zip.NewWriter(f)
+	for name, content := range map[string]string{
+		"META-INF/container.xml": `<?xml version="1.0"?><container><rootfiles><rootfile full-path="OEBPS/content.opf"/></rootfiles></container>`,
+		"OEBPS/content.opf":      opf,
+	} {
+		w, err := z.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	err = z.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadLocalBookEpub(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "book.epub")
+	writeEpub(t, p, testOPF)
+	b, err := lib.ReadLocalBook(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := lib.CalibreBook{
+		Title:       "Children of Dune",
+		Authors:     []string{"Frank Herbert"},
+		Languages:   []string{"eng"},
+		Series:      "Dune",
+		SeriesIndex: 3,
+	}
+	if !reflect.DeepEqual(b, want) {
+		t.Errorf("book = %+v, want %+v", b, want)
+	}
+}
+
+func TestReadLocalBookFilename(t *testing.T) {
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "Frank Herbert - Dune.epub")
+	writeFile(t, dir, filepath.Base(broken), "not a zip")
+	for _, c := range []struct {
+		path   string
+		author string
+		title  string
+	}{
+		{broken, "Frank Herbert", "Dune"},
+		{"/books/Frank Herbert - Dune - Messiah.mobi", "Frank Herbert", "Dune - Messiah"},
+		{"/library/Frank Herbert/Dune (12)/Dune - Frank Herbert.pdf", "Frank Herbert", "Dune"},
+		{"/books/Dune_Messiah.txt", "", "Dune Messiah"},
+	} {
+		b, err := lib.ReadLocalBook(c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var author string
+		if len(b.Authors) > 0 {
+			author = b.Authors[0]
+		}
+		if author != c.author || b.Title != c.title {
+			t.Errorf("%s: author %q, title %q, want %q, %q", c.path, author, b.Title, c.author, c.title)
+		}
+	}
+}
+
+func TestImportDir(t *testing.T) {
+	s := db.NewMemory()
+	dir := t.TempDir()
+	writeEpub(t, filepath.Join(dir, "children.epub"), testOPF)
+	writeFile(t, dir, "Frank Herbert - Dune.mobi", "dune")
+	writeFile(t, dir, "Frank Herbert - Dune (1965).pdf", "dune pdf")
+	writeFile(t, dir, "Frank Herbert - Dune Messiah.txt", "messiah")
+	writeFile(t, dir, "cover.jpg", "cover")
+
+	r, err := lib.ImportDir(s, dir, []string{"epub", "mobi", "pdf"}, 4, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := lib.ImportResult{Scanned: 3, Imported: 2, Conflicts: 1}
+	if r != want {
+		t.Errorf("dry run = %+v, want %+v", r, want)
+	}
+	books, _ := s.Books()
+	if len(books) != 0 {
+		t.Fatalf("dry run stored %d books", len(books))
+	}
+
+	r, err = lib.ImportDir(s, dir, lib.EbookExtensions, 4, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = lib.ImportResult{Scanned: 4, Imported: 3, Conflicts: 1}
+	if r != want {
+		t.Errorf("import = %+v, want %+v", r, want)
+	}
+	books, _ = s.Books()
+	if len(books) != 3 {
+		t.Fatalf("stored %d books, want 3", len(books))
+	}
+	for _, b := range books {
+		if !b.Local || b.Path == "" || b.FileHash == "" {
+			t.Errorf("book is not stored as a local file: %+v", b)
+		}
+	}
+
+	r, err = lib.ImportDir(s, dir, lib.EbookExtensions, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Imported != 0 || r.Present+r.Conflicts != 4 {
+		t.Errorf("second import = %+v, want every book present", r)
+	}
+}
@@ -34,6 +34,8 @@ type CalibreBook struct {
 	LastModified  time.Time         `json:"last_modified"`
 	Thumbnail     string            `json:"thumbnail"`
 	Formats       []string          `json:"formats"`
+	Series        string            `json:"series"`
+	SeriesIndex   float64           `json:"series_index"`
 }
 
 // BooksQueryResult is
@@ -48,55 +50,138 @@ type Host struct {
 	LastScrape        time.Time
 	LastDownload      time.Time
 	Added             time.Time
-	ScrapeResults     []ScrapeResult
 	Active            bool
 	LastRunSuccessful bool
+	// Schedule overrides the default schedule for this host
+	Schedule Schedule
+	// AdaptedInterval is the interval that fits how often the catalog of the host changes
+	AdaptedInterval time.Duration
+	IntervalReason  string
+	// Health is the state of the host in the health state machine
+	Health           HealthState
+	ConsecutiveFails int
+	BackoffUntil     time.Time
+	DisabledSince    time.Time
+	LastProbe        time.Time
 }
 
 // ScrapeResult is the result of a single scrape attempt
 type ScrapeResult struct {
-	Start     time.Time
-	End       time.Time
-	Success   bool
-	Results   int
-	Downloads int
+	Start   time.Time
+	End     time.Time
+	Success bool
+	Results int
+	// Total is the number of books in the catalog of the host
+	Total      int
+	Downloads  int
+	Duplicates int
+}
+
+// ScrapeDay aggregates all scrape runs of a host on a single day, these are kept when the runs themselves are pruned
+type ScrapeDay struct {
+	Date       string
+	Runs       int
+	Fails      int
+	MaxResults int
+	Downloads  int
+	Duplicates int
+	Duration   time.Duration
+}
+
+// ScrapeRecord is a scrape result together with the host it belongs to
+type ScrapeRecord struct {
+	HostID int
+	ScrapeResult
 }
 
 // Print prints a scrapeResult in a nicely formatted way
 func (s *ScrapeResult) Print() {
 	niceDuration := s.End.Sub(s.Start).String()
-	fmt.Printf(` - Started:   %s
-   Duration:  %s
-   Success:   %t
-   Downloads: %d
-   Results:   %d`, s.Start.Format(time.RFC3339), niceDuration, s.Success, s.Downloads, s.Results)
+	fmt.Printf(` - Started:    %s
+   Duration:   %s
+   Success:    %t
+   Downloads:  %d
+   Duplicates: %d
+   Results:    %d`, s.Start.Format(time.RFC3339), niceDuration, s.Success, s.Downloads, s.Duplicates, s.Results)
 	fmt.Println()
 }
 
 // Book is a oversimplified representation of a book
 type Book struct {
-	ID       int `storm:"id,increment"`
-	Added    time.Time
-	Hash     string `storm:"unique"`
-	SourceID int
-	Author   string
-	Title    string
+	ID          int `storm:"id,increment"`
+	Added       time.Time
+	Hash        string `storm:"unique"`
+	SourceID    int
+	Author      string
+	Title       string
+	Languages   []string
+	Series      string
+	SeriesIndex float64
+	SeriesKey   string `storm:"index"`
+	Path        string
+	FileHash    string `storm:"index"`
+	DuplicateOf int
+	Local       bool
+	UUID        string `storm:"index"`
+	Identifiers map[string]string
+	Formats     []string
+}
+
+// CatalogEntry is a book as it was last seen in the catalog of a host
+type CatalogEntry struct {
+	ID          string `storm:"id"`
+	HostID      int    `storm:"index"`
+	CalibreID   int
+	Hash        string
+	Author      string
+	Title       string
+	Languages   []string
+	Series      string
+	SeriesIndex float64
+	SeriesKey   string `storm:"index"`
+	Seen        time.Time
+}
+
+// CalibreLibrary holds the state of the last import of a local calibre library
+type CalibreLibrary struct {
+	Path       string `storm:"id"`
+	LastID     int
+	LastImport time.Time
+	Books      int
+}
+
+// Alias links an alternative author name to the canonical author name
+type Alias struct {
+	Key       string `storm:"id"`
+	Name      string
+	Canonical string
+	Added     time.Time
 }
 
-// Print prints a host in a nicely formatted way
-func (h *Host) Print(verbose bool) {
+// CheckedID marks a book ID of a host as checked
+type CheckedID struct {
+	HostID int
+	BookID int
+}
+
+// Print prints a host in a nicely formatted way, together
+// with the next planned scrape according to the policy
+func (h *Host) Print(s Store, p Policy, verbose bool) {
 	allFails := 0
 	maxBooks := 0
-	for _, scrape := range h.ScrapeResults {
-		if !scrape.Success {
-			allFails++
-		}
-		if scrape.Results > maxBooks {
-			maxBooks = scrape.Results
+	days, _ := s.ScrapeDays(h.ID)
+	for _, day := range days {
+		allFails += day.Fails
+		if day.MaxResults > maxBooks {
+			maxBooks = day.MaxResults
 		}
-
 	}
-	fails, dls := h.Stats(5)
+	fails, dls := h.Stats(s, 5)
+	sched := p.ScheduleFor(*h)
+	next := "-"
+	if h.Active {
+		next = formatNext(p.Next(*h))
+	}
 	if verbose {
 		fmt.Printf(`ID:          %d
 URL:            %s
@@ -105,34 +190,58 @@ Fails:          %d
 Downloads:      %d
 Library size:   %d
 Recent (last5): %d downloads, %d fails
-Active:         %t`, h.ID, h.URL, h.Scrapes, allFails, h.Downloads, maxBooks, dls, fails, h.Active)
+Active:         %t
+Health:         %s (%d failed scrapes in a row)
+Schedule:       %s
+Next scrape:    %s`, h.ID, h.URL, h.Scrapes, allFails, h.Downloads, maxBooks, dls, fails, h.Active, h.State(), h.ConsecutiveFails, sched, next)
 		fmt.Println()
+		if p.Adaptive.Enabled && h.IntervalReason != "" {
+			fmt.Printf("Interval:       %s (%s)\n", formatInterval(h.AdaptedInterval), h.IntervalReason)
+		}
 	} else {
-		fmt.Printf(`%5d|%30s|%7d|%7d|%5d|%6d|%6d|%6t`, h.ID, h.URL, maxBooks, dls, fails, h.Scrapes, h.Downloads, h.Active)
+		fmt.Printf(`%5d|%30s|%7d|%7d|%5d|%6d|%6d|%6t|%11s|%16s`, h.ID, h.URL, maxBooks, dls, fails, h.Scrapes, h.Downloads, h.Active, h.State(), next)
 	}
 	if verbose {
+		fmt.Println("Health changes: ")
+		events, _ := s.HostEvents(h.ID)
+		if len(events) == 0 {
+			fmt.Println(" - none")
+		}
+		for _, e := range events {
+			e.Print()
+		}
 		fmt.Println("Scrape results: ")
-		if h.Scrapes == 0 || len(h.ScrapeResults) == 0 {
+		results, _ := s.ScrapeResults(h.ID, 0)
+		if h.Scrapes == 0 || len(results) == 0 {
 			fmt.Println(" - none")
 		} else {
-			for _, scrape := range h.ScrapeResults {
+			for _, scrape := range results {
 				scrape.Print()
 			}
 		}
 	}
 }
 
+// formatNext formats the time of the next scrape
+func formatNext(next time.Time) string {
+	if !next.After(time.Now()) {
+		return "now"
+	}
+	return next.Local().Format("2006-01-02 15:04")
+}
+
+// formatInterval formats an adapted interval, 0 means there is none yet
+func formatInterval(d time.Duration) string {
+	if d == 0 {
+		return "default"
+	}
+	return d.String()
+}
+
 // Stats returns usefull stats about the last n scrape runs of a host
-func (h *Host) Stats(n int) (fails, downloads int) {
-	fails = 0
-	downloads = 0
-	count := 0
-	for i := len(h.ScrapeResults) - 1; i >= 0; i-- {
-		count++
-		if count > n {
-			break
-		}
-		scrape := h.ScrapeResults[i]
+func (h *Host) Stats(s Store, n int) (fails, downloads int) {
+	results, _ := s.ScrapeResults(h.ID, n)
+	for _, scrape := range results {
 		if !scrape.Success {
 			fails++
 		}
@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"sort"
+	"sync"
+)
+
+// bookLookup tells if a book is already in the database
+type bookLookup interface {
+	has(book Book) bool
+	// inSeries returns true if a volume of the series is in the database
+	inSeries(key string) bool
+}
+
+// storeLookup looks books up in a store, which is normally a read transaction
+type storeLookup struct {
+	s Store
+}
+
+func (l storeLookup) has(book Book) bool {
+	_, err := l.s.BookByHash(book.Hash)
+	if err == nil {
+		return true
+	}
+	if book.UUID != "" {
+		if _, err := l.s.BookByUUID(book.UUID); err == nil {
+			return true
+		}
+	}
+	if book.SeriesKey == "" {
+		return false
+	}
+	volumes, _ := l.s.BooksInSeries(book.SeriesKey)
+	for _, v := range volumes {
+		if v.SeriesIndex == book.SeriesIndex {
+			return true
+		}
+	}
+	return false
+}
+
+func (l storeLookup) inSeries(key string) bool {
+	volumes, _ := l.s.BooksInSeries(key)
+	return len(volumes) > 0
+}
+
+// BookSet holds the hashes, uuids and series volumes of all books in memory,
+// so a scrape doesn't need the database to find out which books are new
+type BookSet struct {
+	mu      sync.RWMutex
+	hashes  map[string]bool
+	uuids   map[string]bool
+	volumes map[string]map[float64]bool
+}
+
+// LoadBookSet reads all books of the store into a BookSet
+func LoadBookSet(s Store) (*BookSet, error) {
+	books, err := s.Books()
+	if err != nil {
+		return nil, err
+	}
+	bs := &BookSet{
+		hashes:  make(map[string]bool, len(books)),
+		uuids:   make(map[string]bool),
+		volumes: make(map[string]map[float64]bool),
+	}
+	for _, b := range books {
+		bs.Add(b)
+	}
+	return bs, nil
+}
+
+// Add adds a book to the set
+func (bs *BookSet) Add(book Book) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.hashes[book.Hash] = true
+	if book.UUID != "" {
+		bs.uuids[book.UUID] = true
+	}
+	if book.SeriesKey != "" {
+		if bs.volumes[book.SeriesKey] == nil {
+			bs.volumes[book.SeriesKey] = make(map[float64]bool)
+		}
+		bs.volumes[book.SeriesKey][book.SeriesIndex] = true
+	}
+}
+
+// Len returns the number of books in the set
+func (bs *BookSet) Len() int {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	return len(bs.hashes)
+}
+
+func (bs *BookSet) has(book Book) bool {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	if bs.hashes[book.Hash] {
+		return true
+	}
+	if book.UUID != "" && bs.uuids[book.UUID] {
+		return true
+	}
+	return book.SeriesKey != "" && bs.volumes[book.SeriesKey][book.SeriesIndex]
+}
+
+func (bs *BookSet) inSeries(key string) bool {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	return len(bs.volumes[key]) > 0
+}
+
+// pageBook is a book of a page of a calibre catalog together with its normalised form
+type pageBook struct {
+	key     string
+	calibre CalibreBook
+	book    Book
+	present bool
+	// wanted is set for new books that continue a series that is in the database
+	wanted bool
+}
+
+// checkPage normalises all books of a page and checks which of them are already in the database,
+// everything is read in a single transaction or from the preloaded book set
+func (a *App) checkPage(bs BooksQueryResult) ([]pageBook, error) {
+	page := make([]pageBook, 0, len(bs))
+	err := a.Store.View(func(tx Store) error {
+		var l bookLookup = storeLookup{tx}
+		if a.Preloaded != nil {
+			l = a.Preloaded
+		}
+		for key, b := range bs {
+			present, book := bookInDatabase(tx, l, &b)
+			page = append(page, pageBook{
+				key:     key,
+				calibre: b,
+				book:    book,
+				present: present,
+				wanted:  !present && book.SeriesKey != "" && l.inSeries(book.SeriesKey),
+			})
+		}
+		return nil
+	})
+	sort.Slice(page, func(i, j int) bool {
+		return page[i].key < page[j].key
+	})
+	return page, err
+}
@@ -0,0 +1,212 @@
+package lib_test
+
+import (
+	"flag"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+// syntheticBook returns the i-th book of the synthetic catalog
+func syntheticBook(i int) lib.CalibreBook {
+	return lib.CalibreBook{
+		ApplicationID: i,
+		Title:         "The Chronicles of X" + syntheticName(i),
+		Authors:       []string{"Author Q" + syntheticName(i%1000)},
+	}
+}
+
+// syntheticName spells out i in letters, numbers would be stripped from the hash of a book
+func syntheticName(i int) string {
+	name := []byte{}
+	for {
+		name = append([]byte{byte('a' + i%16)}, name...)
+		i /= 16
+		if i == 0 {
+			return string(name)
+		}
+	}
+}
+
+// syntheticLibrary stores every even book of a synthetic catalog of n books and returns the catalog ids
+func syntheticLibrary(tb testing.TB, s lib.Store, n int) []int {
+	tb.Helper()
+	//books are normalised against an empty store, so finding similar books stays cheap
+	empty := db.NewMemory()
+	err := s.Update(func(tx lib.Store) error {
+		for i := 0; i < n; i += 2 {
+			e, err := lib.Explain(empty, syntheticBook(i))
+			if err != nil {
+				return err
+			}
+			if err = tx.SaveBook(&e.Book); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ids := make([]int, n)
+	for i := range ids {
+		ids[i] = i
+	}
+	return ids
+}
+
+// perRecord checks every id of a host one query at a time, like scrapes used to
+func perRecord(tb testing.TB, s lib.Store, hostID int, ids []int) int {
+	found := 0
+	for _, id := range ids {
+		checked, err := s.IsChecked(hostID, id)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if checked {
+			continue
+		}
+		if err = s.MarkChecked(hostID, id); err != nil {
+			tb.Fatal(err)
+		}
+		b := syntheticBook(id)
+		if !lib.BookInDatabase(s, &b) {
+			found++
+		}
+	}
+	return found
+}
+
+// perPage checks every id of a host the way a scrape does, a page at a time
+func perPage(tb testing.TB, a *lib.App, hostID int, ids []int) int {
+	cp := &lib.ScrapeCheckpoint{
+		HostID: hostID,
+		IDs:    ids,
+	}
+	a.FilterOldIDs(cp)
+	found := 0
+	for i := 0; i < len(cp.New); i += a.StepSize {
+		max := i + a.StepSize
+		if max > len(cp.New) {
+			max = len(cp.New)
+		}
+		bs := make(lib.BooksQueryResult, max-i)
+		for _, id := range cp.New[i:max] {
+			bs[strconv.Itoa(id)] = syntheticBook(id)
+		}
+		n, err := a.NewOnPage(bs)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		found += n
+	}
+	return found
+}
+
+func TestLookupStrategies(t *testing.T) {
+	s := db.NewMemory()
+	ids := syntheticLibrary(t, s, 200)
+	if found := perRecord(t, s, 1, ids); found != 100 {
+		t.Errorf("per record found %d new books, want 100", found)
+	}
+	if found := perRecord(t, s, 1, ids); found != 0 {
+		t.Errorf("second check found %d new books, want 0", found)
+	}
+
+	a := &lib.App{Store: s, StepSize: 30}
+	if found := perPage(t, a, 2, ids); found != 100 {
+		t.Errorf("batched found %d new books, want 100", found)
+	}
+	set, err := lib.LoadBookSet(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Len() != 100 {
+		t.Errorf("preloaded %d books, want 100", set.Len())
+	}
+	a.Preloaded = set
+	if found := perPage(t, a, 3, ids); found != 100 {
+		t.Errorf("preloaded found %d new books, want 100", found)
+	}
+}
+
+func TestBookSetMatchesSeriesAndUUID(t *testing.T) {
+	s := db.NewMemory()
+	owned := seriesBook(t, s, "Children of Dune", "Dune", 3)
+	owned.UUID = "uuid-children"
+	if err := s.SaveBook(&owned); err != nil {
+		t.Fatal(err)
+	}
+	set, err := lib.LoadBookSet(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &lib.App{Store: s, Preloaded: set}
+	for _, c := range []struct {
+		book lib.CalibreBook
+		new  int
+	}{
+		{lib.CalibreBook{Authors: []string{"Frank Herbert"}, Title: "Dune 03 - Children of Dune", Series: "Dune", SeriesIndex: 3}, 0},
+		{lib.CalibreBook{Authors: []string{"Someone Else"}, Title: "Kinderen van Duin", UUID: "uuid-children"}, 0},
+		{lib.CalibreBook{Authors: []string{"Frank Herbert"}, Title: "God Emperor of Dune", Series: "Dune", SeriesIndex: 4}, 1},
+	} {
+		for name, l := range map[string]*lib.App{"preloaded": a, "store": {Store: s}} {
+			n, err := l.NewOnPage(lib.BooksQueryResult{"1": c.book})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != c.new {
+				t.Errorf("%s: %s is new %d times, want %d", name, c.book.Title, n, c.new)
+			}
+		}
+	}
+}
+
+// benchBooks is the size of the synthetic catalog the lookup benchmarks run against
+var benchBooks = flag.Int("bench.books", 100000, "number of books in the synthetic catalog of the lookup benchmarks")
+
+// benchLibrary opens a bbolt database with a synthetic library of -bench.books books
+func benchLibrary(b *testing.B) (lib.Store, []int) {
+	s, err := db.Open(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		s.Close()
+	})
+	return s, syntheticLibrary(b, s, *benchBooks)
+}
+
+func BenchmarkLookupPerRecord(b *testing.B) {
+	s, ids := benchLibrary(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		perRecord(b, s, i+1, ids)
+	}
+}
+
+func BenchmarkLookupBatched(b *testing.B) {
+	s, ids := benchLibrary(b)
+	a := &lib.App{Store: s, StepSize: 50}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		perPage(b, a, i+1, ids)
+	}
+}
+
+func BenchmarkLookupPreloaded(b *testing.B) {
+	s, ids := benchLibrary(b)
+	a := &lib.App{Store: s, StepSize: 50}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set, err := lib.LoadBookSet(s)
+		if err != nil {
+			b.Fatal(err)
+		}
+		a.Preloaded = set
+		perPage(b, a, i+1, ids)
+	}
+}
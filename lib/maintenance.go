@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BackupSchedule decides when a backup of the database is made automatically.
+// No backups are made when EveryHours is 0.
+type BackupSchedule struct {
+	// Dir is the directory the backups are written to, defaults to a backups directory next to the database
+	Dir        string `json:"dir"`
+	EveryHours int    `json:"every_hours"`
+	// Keep is the number of backups that is kept, older backups are removed. All backups are kept when it is 0.
+	Keep int `json:"keep"`
+}
+
+// DefaultBackupSchedule returns the backup schedule that is used when none is configured
+func DefaultBackupSchedule() BackupSchedule {
+	return BackupSchedule{
+		Keep: 7,
+	}
+}
+
+// Interval returns the time between two backups
+func (s BackupSchedule) Interval() time.Duration {
+	return time.Duration(s.EveryHours) * time.Hour
+}
+
+// backupStore is a store that can back itself up on a schedule
+type backupStore interface {
+	ScheduledBackup(s BackupSchedule, now time.Time) (string, error)
+}
+
+// Maintain makes the scheduled backup of the store, if it supports backups, and removes
+// the expired books from the trash. Problems are logged, they don't stop demeter.
+func Maintain(s Store, backups BackupSchedule, trash TrashExpiry, now time.Time) {
+	if b, ok := s.(backupStore); ok {
+		backup, err := b.ScheduledBackup(backups, now)
+		if err != nil {
+			log.WithField("err", err).Error("Could not back up database")
+		} else if backup != "" {
+			log.WithField("backup", backup).Debug("Database has been backed up")
+		}
+	}
+	if trash.KeepDays > 0 {
+		expired, err := ExpireTrash(s, trash.Since(now))
+		if err != nil {
+			log.WithField("err", err).Error("Could not empty the trash")
+		} else if expired > 0 {
+			log.WithField("books", expired).Debug("Expired books have been removed from the trash")
+		}
+	}
+}
+
+// VerifyReport holds the problems Verify found in the stored data
+type VerifyReport struct {
+	// OrphanedIDs are checked book IDs of hosts that no longer exist
+	OrphanedIDs []CheckedID
+	// DuplicateHashes holds the IDs of all books that share a hash
+	DuplicateHashes map[string][]int
+	// MissingFiles are the downloaded books whose file no longer exists
+	MissingFiles []Book
+}
+
+// Problems returns the number of problems in the report
+func (r VerifyReport) Problems() int {
+	return len(r.OrphanedIDs) + len(r.DuplicateHashes) + len(r.MissingFiles)
+}
+
+// Verify checks the stored data for records that don't make sense anymore.
+// Relative book paths are resolved from the current directory, the files of
+// locally owned books are not checked because demeter doesn't manage them.
+func Verify(s Store) (VerifyReport, error) {
+	r := VerifyReport{
+		DuplicateHashes: make(map[string][]int),
+	}
+	err := s.View(func(tx Store) error {
+		hosts, err := tx.Hosts()
+		if err != nil {
+			return err
+		}
+		known := make(map[int]bool, len(hosts))
+		for _, h := range hosts {
+			known[h.ID] = true
+		}
+		ids, err := tx.CheckedIDs()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if !known[id.HostID] {
+				r.OrphanedIDs = append(r.OrphanedIDs, id)
+			}
+		}
+
+		books, err := tx.Books()
+		if err != nil {
+			return err
+		}
+		byHash := make(map[string][]int, len(books))
+		for _, b := range books {
+			byHash[b.Hash] = append(byHash[b.Hash], b.ID)
+			if b.Path == "" || b.Local {
+				continue
+			}
+			_, err := os.Stat(b.Path)
+			if os.IsNotExist(err) {
+				r.MissingFiles = append(r.MissingFiles, b)
+			}
+		}
+		for hash, ids := range byHash {
+			if len(ids) > 1 {
+				sort.Ints(ids)
+				r.DuplicateHashes[hash] = ids
+			}
+		}
+		return nil
+	})
+	return r, err
+}
@@ -0,0 +1,45 @@
+package lib_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+func TestVerify(t *testing.T) {
+	s := db.NewMemory()
+	dir := t.TempDir()
+	h := lib.Host{URL: "http://calibre.example.com"}
+	if err := s.SaveHost(&h); err != nil {
+		t.Fatal(err)
+	}
+	s.MarkChecked(h.ID, 1)
+	s.MarkChecked(h.ID+1, 1)
+	for _, b := range []lib.Book{
+		{Hash: "present", Path: writeFile(t, dir, "present.epub", "present")},
+		{Hash: "missing", Path: filepath.Join(dir, "missing.epub")},
+		{Hash: "local", Path: filepath.Join(dir, "calibre", "Frank Herbert", "Dune (1)"), Local: true},
+		{Hash: "hashonly"},
+	} {
+		b := b
+		if err := s.SaveBook(&b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := lib.Verify(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.OrphanedIDs) != 1 || r.OrphanedIDs[0].HostID != h.ID+1 {
+		t.Errorf("orphaned ids = %+v", r.OrphanedIDs)
+	}
+	if len(r.MissingFiles) != 1 || r.MissingFiles[0].Hash != "missing" {
+		t.Errorf("missing files = %+v, want only the downloaded book", r.MissingFiles)
+	}
+	if r.Problems() != 2 {
+		t.Errorf("problems = %d, want 2", r.Problems())
+	}
+}
@@ -12,24 +12,22 @@ import (
 
 var onlyLower = regexp.MustCompile("[^a-z]+")
 var leadingNumbers = regexp.MustCompile("^ *[0-9]+")
-var betweenParentheses = regexp.MustCompile(`\(.*\)`)
-var betweenBockHooks = regexp.MustCompile(`\[.*\]`)
 
 var leadingZeroes = regexp.MustCompile(`^ *(0)([0-9]+) `)
 var alphaNumeric = regexp.MustCompile(`[^a-z0-9]+`)
 
 //var year = regexp.MustCompile(`(19[0-9]{2})|(20[0-9]{2})`)
 
-var uselessWords = []string{
-	"le", "la", "et",
-	"de", "het", "en",
-	"the", "and", "a", "an",
+func hashBook(author, title string) string {
+	return hashBookSteps(author, title, rulesFor(nil), nil)
 }
 
-func hashBook(author, title string) string {
+// hashBookSteps does the actual work of hashBook with the given rules and records every step in t
+func hashBookSteps(author, title string, rs ruleSet, t *trace) string {
 	author = strings.ToLower(author)
 	author = strings.Replace(author, "-", " ", -1)
 	title = strings.ToLower(title)
+	t.add("hash: lowercase", author+" / "+title)
 
 	authorParts := strings.Split(author, " ")
 	lastName := authorParts[len(authorParts)-1]
@@ -37,32 +35,36 @@ func hashBook(author, title string) string {
 	//remove author from title
 	title = strings.Replace(title, author, "", -1)
 	title = strings.Replace(title, lastName, "", -1)
+	t.add("hash: remove author from title", title)
 
 	//remove leading numbers
 	title = leadingNumbers.ReplaceAllString(title, "")
+	t.add("hash: remove leading numbers", title)
+
+	//remove stop words
+	title = rs.removeStopWords(title)
+	t.add("hash: remove stop words", title)
 
 	//concatenate to half further actions
 	title = lastName + " " + title
+	t.add("hash: prepend last name", title)
 
 	title = removeAccents(title)
+	t.add("hash: remove accents", title)
 
 	//make sure no whitespace is on either end
 	title = strings.TrimSpace(title)
 
-	//remove everything between parenthesis
-	title = betweenParentheses.ReplaceAllString(title, " ")
-
-	//remove everything between blockhooks
-	title = betweenBockHooks.ReplaceAllString(title, " ")
-
-	//remove ': a novel'
-	title = strings.Replace(title, ": a novel", " ", -1)
+	//apply the configured replacements, like removing everything between parenthesis
+	title = rs.replace(StageHash, "hash", title, t)
 
 	//remove leading zeroes from numbers
 	title = leadingZeroes.ReplaceAllString(title, " $2 ")
+	t.add("hash: remove leading zeroes", title)
 
 	//remove all non [a-z0-9]
 	title = alphaNumeric.ReplaceAllString(title, "")
+	t.add("hash: keep only [a-z0-9]", title)
 
 	return title
 }
@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// HostStatus is how a host ended up in a run
+type HostStatus string
+
+const (
+	// HostScraped hosts were scraped successfully
+	HostScraped HostStatus = "ok"
+	// HostFailed hosts were scraped but the scrape failed
+	HostFailed HostStatus = "failed"
+	// HostInterrupted hosts were still being scraped when the run was cancelled
+	HostInterrupted HostStatus = "interrupted"
+	// HostDeferred hosts ran out of their budget or the budget of the run, the rest of their work is done in a later run
+	HostDeferred HostStatus = "deferred"
+	// HostNotDue hosts were not scraped because they are not due according to their schedule
+	HostNotDue HostStatus = "not-due"
+)
+
+// HostSummary is the outcome of a single host in a run
+type HostSummary struct {
+	ID         int        `json:"id"`
+	URL        string     `json:"url"`
+	Status     HostStatus `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	Results    int        `json:"results"`
+	Downloads  int        `json:"downloads"`
+	Duplicates int        `json:"duplicates"`
+	Duration   string     `json:"duration"`
+}
+
+// RunSummary is the outcome of a run over all hosts
+type RunSummary struct {
+	Start time.Time     `json:"start"`
+	End   time.Time     `json:"end"`
+	Hosts []HostSummary `json:"hosts"`
+	// Cancelled is the reason the run stopped early, if it did
+	Cancelled   string `json:"cancelled,omitempty"`
+	Scraped     int    `json:"scraped"`
+	Failed      int    `json:"failed"`
+	Interrupted int    `json:"interrupted"`
+	Deferred    int    `json:"deferred"`
+	Downloads   int    `json:"downloads"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// OK returns true if no host failed or was interrupted, deferred hosts are fine
+func (s RunSummary) OK() bool {
+	return s.Failed == 0 && s.Interrupted == 0
+}
+
+// Orchestrator scrapes every due host once, all at the same time. The hosts that are not due but
+// have waiting downloads in the queue are drained without being scraped, unless they are backing off.
+type Orchestrator struct {
+	App    *App
+	Policy Policy
+	// Budget limits the downloads of the whole run, its duration is left to the context of the run
+	Budget Budget
+}
+
+// Run scrapes the hosts that are due and returns when all of them are done. When ctx is done
+// the running scrapes stop making requests, store what they downloaded and are reported as interrupted.
+func (o *Orchestrator) Run(ctx context.Context, hosts []Host) RunSummary {
+	summary := RunSummary{
+		Start: time.Now(),
+		Hosts: make([]HostSummary, len(hosts)),
+	}
+	run := o.Budget.Start(nil)
+	var wg sync.WaitGroup
+	for i, h := range hosts {
+		summary.Hosts[i] = HostSummary{
+			ID:     h.ID,
+			URL:    h.URL,
+			Status: HostNotDue,
+		}
+		if !o.Policy.Due(h, summary.Start) {
+			if !drainable(o.App.Store, h, summary.Start) {
+				continue
+			}
+			wg.Add(1)
+			go func(i int, h Host) {
+				defer wg.Done()
+				o.drain(ctx, &h, run, &summary.Hosts[i])
+			}(i, h)
+			continue
+		}
+		//every scrape is registered before it starts, so Wait can't miss one
+		wg.Add(1)
+		go func(i int, h Host) {
+			defer wg.Done()
+			o.scrape(ctx, &h, run, &summary.Hosts[i])
+		}(i, h)
+	}
+	wg.Wait()
+
+	summary.End = time.Now()
+	if err := ctx.Err(); err != nil {
+		summary.Cancelled = err.Error()
+	}
+	for _, hs := range summary.Hosts {
+		switch hs.Status {
+		case HostScraped:
+			summary.Scraped++
+		case HostFailed:
+			summary.Failed++
+		case HostInterrupted:
+			summary.Interrupted++
+		case HostDeferred:
+			summary.Deferred++
+		}
+		summary.Downloads += hs.Downloads
+	}
+	_, summary.Bytes = run.Spent()
+	return summary
+}
+
+// scrape scrapes a single host and fills its summary
+func (o *Orchestrator) scrape(ctx context.Context, h *Host, run *Allowance, hs *HostSummary) {
+	if ctx.Err() != nil {
+		hs.Status = stoppedStatus(ctx)
+		hs.Error = ctx.Err().Error()
+		return
+	}
+	result, err := o.App.ScrapeHost(ctx, h, o.Policy, run)
+	hs.fill(ctx, result, err, HostScraped)
+}
+
+// drain drains the queue of a host that is not due and fills its summary, the host stays not due
+func (o *Orchestrator) drain(ctx context.Context, h *Host, run *Allowance, hs *HostSummary) {
+	if ctx.Err() != nil {
+		return
+	}
+	result, err := o.App.DrainHost(ctx, h, o.Policy, run)
+	hs.fill(ctx, result, err, HostNotDue)
+}
+
+// fill fills the summary of a host from its result, ok is the status of a host without an error
+func (hs *HostSummary) fill(ctx context.Context, result *ScrapeResult, err error, ok HostStatus) {
+	hs.Results = result.Results
+	hs.Downloads = result.Downloads
+	hs.Duplicates = result.Duplicates
+	hs.Duration = result.End.Sub(result.Start).Round(time.Millisecond).String()
+	switch {
+	case err == nil:
+		hs.Status = ok
+	case errors.Is(err, ErrBudgetExhausted):
+		hs.Status = HostDeferred
+		hs.Error = err.Error()
+	case ctx.Err() != nil:
+		hs.Status = stoppedStatus(ctx)
+		hs.Error = err.Error()
+	default:
+		hs.Status = HostFailed
+		hs.Error = err.Error()
+	}
+}
+
+// stoppedStatus returns the status of a host that was stopped by the context of the run,
+// a run that reached its maximum duration defers the rest of the work
+func stoppedStatus(ctx context.Context) HostStatus {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return HostDeferred
+	}
+	return HostInterrupted
+}
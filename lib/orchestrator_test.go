@@ -0,0 +1,239 @@
+package lib_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+// fakeHost is a calibre content server with a book for every title, the book IDs start at 1
+type fakeHost struct {
+	*httptest.Server
+	mu    sync.Mutex
+	books lib.BooksQueryResult
+	// down makes every request fail, failBooks the metadata requests of these IDs and failDownloads the downloads
+	down          bool
+	failBooks     map[int]bool
+	failDownloads bool
+	downloads     int
+	// hold keeps downloads waiting until it is closed
+	hold chan struct{}
+}
+
+// newFakeHost starts a calibre content server for the titles, every title is a book by the same author
+func newFakeHost(t *testing.T, titles ...string) *fakeHost {
+	t.Helper()
+	f := &fakeHost{
+		books:     make(lib.BooksQueryResult),
+		failBooks: make(map[int]bool),
+	}
+	for i, title := range titles {
+		id := i + 1
+		f.books[strconv.Itoa(id)] = lib.CalibreBook{
+			Title:         title,
+			Authors:       []string{"Frank Herbert"},
+			ApplicationID: id,
+			MainFormat:    map[string]string{"epub": fmt.Sprintf("/get/epub/%d", id)},
+		}
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeHost) set(fn func(f *fakeHost)) {
+	f.mu.Lock()
+	fn(f)
+	f.mu.Unlock()
+}
+
+func (f *fakeHost) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	hold := f.hold
+	f.mu.Unlock()
+	if hold != nil && strings.HasPrefix(r.URL.Path, "/get/") {
+		<-hold
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+	switch {
+	case r.URL.Path == "/ajax/search":
+		var ids []int
+		for k := range f.books {
+			id, _ := strconv.Atoi(k)
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		num, _ := strconv.Atoi(r.URL.Query().Get("num"))
+		if offset > len(ids) {
+			offset = len(ids)
+		}
+		end := offset + num
+		if end > len(ids) {
+			end = len(ids)
+		}
+		json.NewEncoder(w).Encode(lib.SearchResult{TotalNum: len(ids), BookIds: ids[offset:end]})
+	case r.URL.Path == "/ajax/books":
+		page := make(lib.BooksQueryResult)
+		for _, k := range strings.Split(r.URL.Query().Get("ids"), ",") {
+			id, _ := strconv.Atoi(k)
+			if f.failBooks[id] {
+				http.Error(w, "broken", http.StatusInternalServerError)
+				return
+			}
+			if b, ok := f.books[k]; ok {
+				page[k] = b
+			}
+		}
+		json.NewEncoder(w).Encode(page)
+	case strings.HasPrefix(r.URL.Path, "/get/epub/"):
+		if f.failDownloads {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		f.downloads++
+		fmt.Fprintf(w, "epub of %s on %s", r.URL.Path, f.URL)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// testApp returns an app that scrapes into a temporary directory
+func testApp(t *testing.T, s lib.Store) *lib.App {
+	t.Helper()
+	a := &lib.App{
+		Store:           s,
+		Timeout:         5 * time.Second,
+		DownloadTimeout: 5 * time.Second,
+		StepSize:        2,
+		OutputDir:       t.TempDir(),
+		Extension:       "epub",
+		Pool:            lib.NewPool(2, 10, 0),
+	}
+	t.Cleanup(a.Pool.Close)
+	return a
+}
+
+// saveHosts stores an active host for every URL
+func saveHosts(t *testing.T, s lib.Store, urls ...string) []lib.Host {
+	t.Helper()
+	var hosts []lib.Host
+	for _, u := range urls {
+		h := lib.Host{URL: u, Active: true}
+		if err := s.SaveHost(&h); err != nil {
+			t.Fatal(err)
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+func TestOrchestratorRun(t *testing.T) {
+	up := newFakeHost(t, "Dune", "Dune Messiah", "Children of Dune")
+	down := newFakeHost(t, "Foundation")
+	down.set(func(f *fakeHost) { f.down = true })
+	later := newFakeHost(t, "Hyperion")
+
+	s := db.NewMemory()
+	hosts := saveHosts(t, s, up.URL, down.URL, later.URL)
+	hosts[2].LastScrape = time.Now()
+	o := lib.Orchestrator{
+		App:    testApp(t, s),
+		Policy: lib.Policy{Schedule: lib.Schedule{Interval: "12h"}, Health: lib.DefaultHealthPolicy()},
+	}
+	summary := o.Run(context.Background(), hosts)
+
+	want := []lib.HostStatus{lib.HostScraped, lib.HostFailed, lib.HostNotDue}
+	for i, hs := range summary.Hosts {
+		if hs.Status != want[i] {
+			t.Errorf("host %s is %s (%s), want %s", hs.URL, hs.Status, hs.Error, want[i])
+		}
+	}
+	if summary.Scraped != 1 || summary.Failed != 1 || summary.Downloads != 3 || summary.OK() {
+		t.Errorf("summary %+v", summary)
+	}
+	if summary.Hosts[0].Results != 3 || summary.Hosts[0].Downloads != 3 {
+		t.Errorf("scraped host %+v", summary.Hosts[0])
+	}
+	books, _ := s.Books()
+	if len(books) != 3 {
+		t.Errorf("stored %d books, want 3", len(books))
+	}
+	for _, b := range books {
+		if _, err := os.Stat(b.Path); err != nil {
+			t.Errorf("book %s: %v", b.Title, err)
+		}
+	}
+	h, _ := s.Host(hosts[1].ID)
+	if h.State() != lib.HealthDegraded || h.LastRunSuccessful {
+		t.Errorf("failed host is %s", h.State())
+	}
+}
+
+func TestOrchestratorStopped(t *testing.T) {
+	f := newFakeHost(t, "Dune")
+	s := db.NewMemory()
+	hosts := saveHosts(t, s, f.URL)
+	o := lib.Orchestrator{App: testApp(t, s), Policy: lib.Policy{Health: lib.DefaultHealthPolicy()}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	summary := o.Run(ctx, hosts)
+	if summary.Hosts[0].Status != lib.HostInterrupted || summary.Interrupted != 1 || summary.OK() || summary.Cancelled == "" {
+		t.Errorf("cancelled summary %+v", summary)
+	}
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	summary = o.Run(ctx, hosts)
+	if summary.Hosts[0].Status != lib.HostDeferred || summary.Deferred != 1 || !summary.OK() {
+		t.Errorf("summary after the maximum duration %+v", summary)
+	}
+	if f.downloads != 0 {
+		t.Errorf("%d downloads after the run stopped", f.downloads)
+	}
+}
+
+func TestOrchestratorDrainsQueue(t *testing.T) {
+	f := newFakeHost(t, "Dune", "Dune Messiah")
+	s := db.NewMemory()
+	hosts := saveHosts(t, s, f.URL)
+	a := testApp(t, s)
+	o := lib.Orchestrator{
+		App:    a,
+		Policy: lib.Policy{Schedule: lib.Schedule{Interval: "12h"}, Health: lib.DefaultHealthPolicy()},
+		Budget: lib.Budget{MaxDownloads: 1},
+	}
+	summary := o.Run(context.Background(), hosts)
+	if summary.Hosts[0].Status != lib.HostDeferred || summary.Downloads != 1 {
+		t.Fatalf("first run %+v", summary.Hosts[0])
+	}
+
+	//the host is not due anymore, but its queue is drained
+	hosts[0], _ = s.Host(hosts[0].ID)
+	o.Budget = lib.Budget{}
+	summary = o.Run(context.Background(), hosts)
+	if summary.Hosts[0].Status != lib.HostNotDue || summary.Downloads != 1 {
+		t.Errorf("second run %+v", summary.Hosts[0])
+	}
+	if queue, _ := s.Queue(); len(queue) != 0 {
+		t.Errorf("queue still holds %+v", queue)
+	}
+}
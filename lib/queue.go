@@ -0,0 +1,598 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// QueueState is the state of a queued download
+type QueueState string
+
+const (
+	// QueueWaiting downloads are made when their host is drained
+	QueueWaiting QueueState = "waiting"
+	// QueuePaused downloads are skipped until they are resumed
+	QueuePaused QueueState = "paused"
+	// QueueFailed downloads failed maxAttempts times, they are skipped until they are retried
+	QueueFailed QueueState = "failed"
+)
+
+// maxAttempts is the number of times a download is tried before it fails for good
+const maxAttempts = 3
+
+// queuePausedKey is the meta key that pauses the whole queue
+const queuePausedKey = "queue_paused"
+
+// QueuedDownload is a book that a scrape found and that waits to be downloaded,
+// it is removed from the queue when it is downloaded
+type QueuedDownload struct {
+	ID        int `storm:"id,increment"`
+	HostID    int `storm:"index"`
+	CalibreID int
+	// Hash is the hash of the book, a book is queued only once
+	Hash        string `storm:"unique"`
+	URL         string
+	Format      string
+	Path        string
+	Priority    Priority
+	Attempts    int
+	State       QueueState
+	Error       string
+	Added       time.Time
+	LastAttempt time.Time
+	Book        Book
+	// Fallbacks are the downloads of other hosts that found the book after it was queued,
+	// the first one takes over when the download fails
+	Fallbacks []QueuedDownload
+}
+
+// Print prints a queued download in a nicely formatted way
+func (d QueuedDownload) Print() {
+	fmt.Printf(`%6d|%5d|%7d|%8s|%8s|%3d|%5s| %s - %s`, d.ID, d.HostID, d.CalibreID, d.State, d.Priority, d.Attempts, d.Format, d.Book.Author, d.Book.Title)
+	if d.Error != "" {
+		fmt.Printf(" (%s)", d.Error)
+	}
+	fmt.Println()
+}
+
+// sortQueue orders queued downloads the way they are downloaded, most important first
+func sortQueue(queue []QueuedDownload) {
+	sort.SliceStable(queue, func(i, j int) bool {
+		if queue[i].Priority != queue[j].Priority {
+			return queue[i].Priority < queue[j].Priority
+		}
+		return queue[i].ID < queue[j].ID
+	})
+}
+
+// QueueSelection selects queued downloads by their ID, their host and their state,
+// All selects every download that matches the state
+type QueueSelection struct {
+	IDs    []int
+	HostID int
+	States []QueueState
+	All    bool
+}
+
+// Empty returns true if the selection doesn't select anything
+func (q QueueSelection) Empty() bool {
+	return len(q.IDs) == 0 && q.HostID == 0 && !q.All
+}
+
+// match returns true if a queued download is selected
+func (q QueueSelection) match(d QueuedDownload) bool {
+	if len(q.States) > 0 && !containsState(q.States, d.State) {
+		return false
+	}
+	if q.All {
+		return true
+	}
+	if q.HostID != 0 && d.HostID == q.HostID {
+		return true
+	}
+	return containsInt(q.IDs, d.ID)
+}
+
+func containsState(list []QueueState, v QueueState) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectQueue returns the queued downloads that match the selection, most important first
+func SelectQueue(s Store, sel QueueSelection) ([]QueuedDownload, error) {
+	queue, err := s.Queue()
+	if err != nil {
+		return nil, err
+	}
+	var selected []QueuedDownload
+	for _, d := range queue {
+		if sel.match(d) {
+			selected = append(selected, d)
+		}
+	}
+	sortQueue(selected)
+	return selected, nil
+}
+
+// UpdateQueue applies fn to the selected downloads in a single transaction and stores the ones it
+// changed, fn returns false when it left a download alone. The changed downloads are returned.
+func UpdateQueue(s Store, sel QueueSelection, fn func(d *QueuedDownload) bool) ([]QueuedDownload, error) {
+	var changed []QueuedDownload
+	err := s.Update(func(tx Store) error {
+		changed = nil
+		selected, err := SelectQueue(tx, sel)
+		if err != nil {
+			return err
+		}
+		for _, d := range selected {
+			if !fn(&d) {
+				continue
+			}
+			err = tx.SaveQueuedDownload(&d)
+			if err != nil {
+				return err
+			}
+			changed = append(changed, d)
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// DropQueue removes the selected downloads from the queue and returns them
+func DropQueue(s Store, sel QueueSelection) ([]QueuedDownload, error) {
+	var dropped []QueuedDownload
+	err := s.Update(func(tx Store) error {
+		var err error
+		dropped, err = SelectQueue(tx, sel)
+		if err != nil {
+			return err
+		}
+		for _, d := range dropped {
+			err = tx.DeleteQueuedDownload(d.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return dropped, err
+}
+
+// IsQueuePaused returns true if the whole queue is paused
+func IsQueuePaused(s Store) bool {
+	var paused bool
+	err := s.Meta(queuePausedKey, &paused)
+	return err == nil && paused
+}
+
+// SetQueuePaused pauses or resumes the whole queue, a paused queue is not drained
+func SetQueuePaused(s Store, paused bool) error {
+	return s.SetMeta(queuePausedKey, paused)
+}
+
+// enqueue adds downloads to the queue in a single transaction and returns how many were added.
+// A book that another host queued already is claimed by that host, the download is added to its
+// fallback sources instead. When the claiming host failed to download it, is no longer active or
+// is backing off, this host takes over.
+func (a *App) enqueue(downloads []QueuedDownload) (int, error) {
+	added := 0
+	now := time.Now()
+	err := a.Store.Update(func(tx Store) error {
+		added = 0
+		for _, d := range downloads {
+			//a failed save can leave index entries behind, so the hash is looked up first
+			claimed, err := tx.QueuedDownloadByHash(d.Hash)
+			switch {
+			case err == ErrNotFound:
+			case err != nil:
+				return err
+			case claimed.HostID == d.HostID:
+				continue
+			case claimed.State == QueueFailed || !hostAvailable(tx, claimed.HostID, now):
+				d.ID = claimed.ID
+				d.Added = claimed.Added
+				d.Fallbacks = withoutHost(claimed.Fallbacks, d.HostID)
+			default:
+				if !addFallback(&claimed, d) {
+					continue
+				}
+				err = tx.SaveQueuedDownload(&claimed)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err = tx.SaveQueuedDownload(&d)
+			if err != nil {
+				return err
+			}
+			added++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// hostActive returns true if a host exists and is active
+func hostActive(s Store, hostID int) bool {
+	h, err := s.Host(hostID)
+	return err == nil && h.Active
+}
+
+// hostAvailable returns true if a host exists, is active and is not backing off at now
+func hostAvailable(s Store, hostID int, now time.Time) bool {
+	h, err := s.Host(hostID)
+	return err == nil && h.Active && !h.BackoffUntil.After(now)
+}
+
+// addFallback adds the download of another host to the fallback sources of a queued download,
+// false is returned when that host is a fallback source already
+func addFallback(d *QueuedDownload, fallback QueuedDownload) bool {
+	for _, f := range d.Fallbacks {
+		if f.HostID == fallback.HostID {
+			return false
+		}
+	}
+	fallback.Fallbacks = nil
+	d.Fallbacks = append(d.Fallbacks, fallback)
+	return true
+}
+
+// withoutHost returns the fallback sources that are not on the given host
+func withoutHost(fallbacks []QueuedDownload, hostID int) []QueuedDownload {
+	var kept []QueuedDownload
+	for _, f := range fallbacks {
+		if f.HostID != hostID {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// nextFallback returns the first fallback source of a download whose host is usable
+func nextFallback(d QueuedDownload, usable func(hostID int) bool) (QueuedDownload, []QueuedDownload, bool) {
+	for i, f := range d.Fallbacks {
+		if usable(f.HostID) {
+			return f, d.Fallbacks[i+1:], true
+		}
+	}
+	return QueuedDownload{}, nil, false
+}
+
+// passClaim returns the queued download with the claim of a book passed to one of its fallback sources,
+// the rest of the fallbacks stay with it
+func passClaim(d, fallback QueuedDownload, rest []QueuedDownload) QueuedDownload {
+	fallback.ID = d.ID
+	fallback.Added = d.Added
+	fallback.Fallbacks = rest
+	if d.Priority < fallback.Priority {
+		fallback.Priority = d.Priority
+	}
+	if d.State == QueuePaused {
+		fallback.State = QueuePaused
+	}
+	return fallback
+}
+
+// passClaims passes the queued downloads of a host to the first of their fallback sources that is usable,
+// the downloads without one are returned
+func passClaims(tx Store, hostID int, usable func(hostID int) bool) (int, []QueuedDownload, error) {
+	queue, err := tx.HostQueue(hostID)
+	if err != nil {
+		return 0, nil, err
+	}
+	passed := 0
+	var left []QueuedDownload
+	for _, d := range queue {
+		fallback, rest, ok := nextFallback(d, usable)
+		if !ok {
+			left = append(left, d)
+			continue
+		}
+		d = passClaim(d, fallback, rest)
+		err = tx.SaveQueuedDownload(&d)
+		if err != nil {
+			return passed, left, err
+		}
+		passed++
+	}
+	return passed, left, nil
+}
+
+// PassClaims passes the queued downloads of a host that can't download them for a while, because it is
+// backing off or disabled, to the first of their fallback sources that is active and not backing off at now.
+// It returns how many were passed, the downloads without such a fallback stay with the host.
+func PassClaims(s Store, hostID int, now time.Time) (int, error) {
+	passed := 0
+	err := s.Update(func(tx Store) error {
+		var err error
+		passed, _, err = passClaims(tx, hostID, func(id int) bool {
+			return id != hostID && hostAvailable(tx, id, now)
+		})
+		return err
+	})
+	return passed, err
+}
+
+// Drain downloads the waiting downloads of a host, most important first, and counts them in the result.
+// A download that fails is tried again in a later drain until it failed maxAttempts times. Downloads are
+// only started while the allowance isn't exhausted, ErrBudgetExhausted is returned when downloads are left
+// because of it. When ctx is done no new downloads are started and ctx.Err() is returned.
+func (a *App) Drain(ctx context.Context, h *Host, allowance *Allowance, r *ScrapeResult) error {
+	if IsQueuePaused(a.Store) {
+		log.WithField("host", h.URL).Info("The download queue is paused")
+		return nil
+	}
+	err := os.MkdirAll(a.OutputDir, 0755)
+	if err != nil {
+		return err
+	}
+	queue, err := a.Store.HostQueue(h.ID)
+	if err != nil {
+		return err
+	}
+	sortQueue(queue)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	started := make(map[string]QueuedDownload)
+	deferred := false
+	//downloads are stored while the rest of the queue is submitted, so a stopped drain has little left to store
+	results := make(chan DownloadBookResponse)
+	go func() {
+		for res := range results {
+			mu.Lock()
+			d := started[res.Path]
+			mu.Unlock()
+			switch {
+			case res.Err == nil:
+				r.Downloads++
+				a.storeDownload(r, d, res)
+				a.clearInFlight(d.Hash)
+			case errors.Is(res.Err, ErrBudgetExhausted):
+				mu.Lock()
+				deferred = true
+				mu.Unlock()
+				a.clearInFlight(d.Hash)
+			case ctx.Err() != nil:
+				//the download was stopped, which doesn't count as an attempt
+				a.clearInFlight(d.Hash)
+			default:
+				//a book that is handed over stays in flight while the fallback downloads it
+				fallback, rest, ok := nextFallback(d, func(id int) bool {
+					return hostActive(a.Store, id)
+				})
+				if !ok {
+					d.Fallbacks = nil
+					a.failed(d, res.Err)
+					a.clearInFlight(d.Hash)
+					break
+				}
+				d = a.handOver(d, fallback, rest, res.Err)
+				mu.Lock()
+				started[d.Path] = d
+				mu.Unlock()
+				//the result of the fallback is handled by this loop, so it can't wait for a worker here
+				wg.Add(1)
+				go func(d QueuedDownload) {
+					err := a.downloadBookAsync(ctx, d.HostID, d.Priority, d.URL, d.Path, allowance, results)
+					if err != nil {
+						a.clearInFlight(d.Hash)
+						wg.Done()
+					}
+				}(d)
+			}
+			wg.Done()
+		}
+	}()
+
+	for _, d := range queue {
+		if d.State != QueueWaiting {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if allowance.Exhausted() {
+			mu.Lock()
+			deferred = true
+			mu.Unlock()
+			break
+		}
+		if !a.markInFlight(d.Hash) {
+			//another drain is downloading the book, it was handed over to this host while it runs
+			continue
+		}
+		if _, err := a.Store.BookByHash(d.Hash); err == nil {
+			//another host or an import added the book since it was queued
+			a.dequeue(d)
+			a.clearInFlight(d.Hash)
+			continue
+		}
+		mu.Lock()
+		started[d.Path] = d
+		mu.Unlock()
+		wg.Add(1)
+		err = a.downloadBookAsync(ctx, h.ID, d.Priority, d.URL, d.Path, allowance, results)
+		if err != nil {
+			a.clearInFlight(d.Hash)
+			wg.Done()
+			break
+		}
+	}
+	wg.Wait()
+	close(results)
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if deferred {
+		return ErrBudgetExhausted
+	}
+	return nil
+}
+
+// DrainHost drains the download queue of a host without scraping it, within the budget of the host
+// which spends from the allowance of the run. The downloads are added to the counters of the host.
+func (a *App) DrainHost(ctx context.Context, h *Host, p Policy, run *Allowance) (*ScrapeResult, error) {
+	budget := p.Budgets.For(*h)
+	hctx, cancel := budget.WithDeadline(ctx)
+	defer cancel()
+	r := &ScrapeResult{Start: time.Now()}
+	err := a.Drain(hctx, h, budget.Start(run), r)
+	r.End = time.Now()
+	if err != nil && ctx.Err() == nil && hctx.Err() != nil {
+		err = ErrBudgetExhausted
+	}
+	if r.Downloads > 0 {
+		log.WithFields(log.Fields{
+			"host":      h.URL,
+			"downloads": r.Downloads,
+		}).Info("Drained download queue")
+		h.Downloads += r.Downloads
+		h.LastDownload = r.End
+		serr := a.Store.SaveHost(h)
+		if serr != nil {
+			log.WithFields(log.Fields{
+				"host": h.URL,
+				"err":  serr,
+			}).Error("Could not store host")
+		}
+	}
+	return r, err
+}
+
+// drainable returns true if a host that is not scraped has waiting downloads that can be made at now
+func drainable(s Store, h Host, now time.Time) bool {
+	if h.BackoffUntil.After(now) || IsQueuePaused(s) {
+		return false
+	}
+	queue, err := s.HostQueue(h.ID)
+	if err != nil {
+		return false
+	}
+	for _, d := range queue {
+		if d.State == QueueWaiting {
+			return true
+		}
+	}
+	return false
+}
+
+// storeDownload adds a downloaded book to the database and removes it from the queue
+func (a *App) storeDownload(r *ScrapeResult, d QueuedDownload, res DownloadBookResponse) {
+	book := d.Book
+	book.Added = time.Now()
+	book.Path = res.Path
+	book.FileHash = res.FileHash
+	duplicate, err := dedupeDownload(a.Store, &book, a.OnDuplicate)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path": res.Path,
+			"err":  err,
+		}).Warning("Could not remove duplicate file")
+	}
+	if duplicate {
+		r.Duplicates++
+		log.WithFields(log.Fields{
+			"hash":        book.Hash,
+			"duplicateOf": book.DuplicateOf,
+		}).Debug("Downloaded file is identical to an existing book")
+	}
+	err = a.Store.Update(func(tx Store) error {
+		err := tx.SaveBook(&book)
+		if err != nil {
+			return err
+		}
+		return tx.DeleteQueuedDownload(d.ID)
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"hash": book.Hash,
+			"err":  err,
+		}).Warning("Could not store downloaded book")
+	} else if a.Preloaded != nil {
+		a.Preloaded.Add(book)
+	}
+}
+
+// handOver passes the claim of a book whose download failed to a fallback source on another host,
+// the queued download is stored with the fallback as its source and the rest as its fallbacks and returned
+func (a *App) handOver(d, fallback QueuedDownload, rest []QueuedDownload, derr error) QueuedDownload {
+	log.WithFields(log.Fields{
+		"hash": d.Hash,
+		"from": d.URL,
+		"to":   fallback.URL,
+		"err":  derr,
+	}).Info("Download failed, passing it to another host")
+	fallback = passClaim(d, fallback, rest)
+	fallback.LastAttempt = time.Now()
+	fallback.Error = derr.Error()
+	err := a.Store.SaveQueuedDownload(&fallback)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"hash": d.Hash,
+			"err":  err,
+		}).Warning("Could not update queued download")
+	}
+	return fallback
+}
+
+// markInFlight marks the download of a book as in flight, false is returned when a drain is downloading it already
+func (a *App) markInFlight(hash string) bool {
+	_, busy := a.inFlight.LoadOrStore(hash, true)
+	return !busy
+}
+
+// clearInFlight marks the download of a book as no longer in flight
+func (a *App) clearInFlight(hash string) {
+	a.inFlight.Delete(hash)
+}
+
+// dequeue removes a download from the queue
+func (a *App) dequeue(d QueuedDownload) {
+	err := a.Store.DeleteQueuedDownload(d.ID)
+	if err != nil && err != ErrNotFound {
+		log.WithFields(log.Fields{
+			"hash": d.Hash,
+			"err":  err,
+		}).Warning("Could not remove download from the queue")
+	}
+}
+
+// failed counts a failed attempt of a queued download
+func (a *App) failed(d QueuedDownload, derr error) {
+	d.Attempts++
+	d.LastAttempt = time.Now()
+	d.Error = derr.Error()
+	if d.Attempts >= maxAttempts {
+		d.State = QueueFailed
+	}
+	err := a.Store.SaveQueuedDownload(&d)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"hash": d.Hash,
+			"err":  err,
+		}).Warning("Could not update queued download")
+	}
+	log.WithFields(log.Fields{
+		"url":      d.URL,
+		"attempts": d.Attempts,
+		"err":      derr,
+	}).Debug("Download failed")
+}
@@ -0,0 +1,172 @@
+package lib_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+// queueState fills a store with queued downloads of two hosts
+func queueState(t *testing.T) lib.Store {
+	t.Helper()
+	s := db.NewMemory()
+	for _, d := range []lib.QueuedDownload{
+		{HostID: 1, Hash: "bulk", Priority: lib.PriorityBulk, State: lib.QueueWaiting},
+		{HostID: 1, Hash: "wanted", Priority: lib.PriorityWanted, State: lib.QueueWaiting},
+		{HostID: 2, Hash: "paused", Priority: lib.PriorityBulk, State: lib.QueuePaused},
+		{HostID: 2, Hash: "failed", Priority: lib.PriorityManual, State: lib.QueueFailed, Attempts: 3},
+	} {
+		d := d
+		if err := s.SaveQueuedDownload(&d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func hashes(queue []lib.QueuedDownload) []string {
+	var hs []string
+	for _, d := range queue {
+		hs = append(hs, d.Hash)
+	}
+	return hs
+}
+
+func TestSelectQueue(t *testing.T) {
+	s := queueState(t)
+	tests := []struct {
+		sel  lib.QueueSelection
+		want []string
+	}{
+		{lib.QueueSelection{All: true}, []string{"failed", "wanted", "bulk", "paused"}},
+		{lib.QueueSelection{HostID: 1}, []string{"wanted", "bulk"}},
+		{lib.QueueSelection{IDs: []int{1, 3}}, []string{"bulk", "paused"}},
+		{lib.QueueSelection{All: true, States: []lib.QueueState{lib.QueuePaused, lib.QueueFailed}}, []string{"failed", "paused"}},
+		{lib.QueueSelection{HostID: 1, States: []lib.QueueState{lib.QueuePaused}}, nil},
+	}
+	for _, tt := range tests {
+		queue, err := lib.SelectQueue(s, tt.sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := hashes(queue)
+		if len(got) != len(tt.want) {
+			t.Errorf("%+v selected %v, want %v", tt.sel, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%+v selected %v, want %v", tt.sel, got, tt.want)
+				break
+			}
+		}
+	}
+	if !(lib.QueueSelection{States: []lib.QueueState{lib.QueueFailed}}).Empty() {
+		t.Error("a selection of only states is not empty")
+	}
+}
+
+func TestUpdateAndDropQueue(t *testing.T) {
+	s := queueState(t)
+	changed, err := lib.UpdateQueue(s, lib.QueueSelection{All: true}, func(d *lib.QueuedDownload) bool {
+		if d.State != lib.QueueWaiting {
+			return false
+		}
+		d.State = lib.QueuePaused
+		return true
+	})
+	if err != nil || len(changed) != 2 {
+		t.Fatalf("paused %v, %v, want the waiting downloads", hashes(changed), err)
+	}
+	paused, _ := lib.SelectQueue(s, lib.QueueSelection{All: true, States: []lib.QueueState{lib.QueuePaused}})
+	if len(paused) != 3 {
+		t.Errorf("%v are paused, want 3 downloads", hashes(paused))
+	}
+
+	dropped, err := lib.DropQueue(s, lib.QueueSelection{HostID: 2})
+	if err != nil || len(dropped) != 2 {
+		t.Fatalf("dropped %v, %v, want the downloads of host 2", hashes(dropped), err)
+	}
+	queue, _ := s.Queue()
+	if len(queue) != 2 {
+		t.Errorf("queue holds %v after dropping", hashes(queue))
+	}
+}
+
+func TestDrainQueue(t *testing.T) {
+	f := newFakeHost(t, "Dune", "Dune Messiah", "Children of Dune")
+	s := db.NewMemory()
+	h := saveHosts(t, s, f.URL)[0]
+	a := testApp(t, s)
+	if _, err := a.Scrape(context.Background(), &h); err != nil {
+		t.Fatal(err)
+	}
+	queue, _ := s.HostQueue(h.ID)
+	if len(queue) != 3 {
+		t.Fatalf("scrape queued %d downloads, want 3", len(queue))
+	}
+
+	//a paused queue is left alone
+	if err := lib.SetQueuePaused(s, true); err != nil {
+		t.Fatal(err)
+	}
+	if !lib.IsQueuePaused(s) {
+		t.Fatal("queue is not paused")
+	}
+	r := &lib.ScrapeResult{}
+	if err := a.Drain(context.Background(), &h, nil, r); err != nil || r.Downloads != 0 {
+		t.Errorf("drained %d, %v from a paused queue", r.Downloads, err)
+	}
+	if err := lib.SetQueuePaused(s, false); err != nil {
+		t.Fatal(err)
+	}
+
+	//a paused download is skipped, a book that was added since is dropped from the queue
+	queue[0].State = lib.QueuePaused
+	if err := s.SaveQueuedDownload(&queue[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveBook(&lib.Book{Hash: queue[1].Hash, Local: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Drain(context.Background(), &h, nil, r); err != nil || r.Downloads != 1 {
+		t.Errorf("drained %d, %v, want 1 download", r.Downloads, err)
+	}
+	left, _ := s.HostQueue(h.ID)
+	if len(left) != 1 || left[0].Hash != queue[0].Hash {
+		t.Errorf("queue holds %v, want the paused download", hashes(left))
+	}
+	if f.downloads != 1 {
+		t.Errorf("the host served %d downloads, want 1", f.downloads)
+	}
+}
+
+func TestDrainFailedDownloads(t *testing.T) {
+	f := newFakeHost(t, "Dune")
+	f.set(func(f *fakeHost) { f.failDownloads = true })
+	s := db.NewMemory()
+	h := saveHosts(t, s, f.URL)[0]
+	a := testApp(t, s)
+	if _, err := a.Scrape(context.Background(), &h); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 4; i++ {
+		if err := a.Drain(context.Background(), &h, nil, &lib.ScrapeResult{}); err != nil {
+			t.Fatal(err)
+		}
+		queue, _ := s.HostQueue(h.ID)
+		if len(queue) != 1 {
+			t.Fatalf("queue holds %v", hashes(queue))
+		}
+		attempts, state := i, lib.QueueWaiting
+		if i >= 3 {
+			attempts, state = 3, lib.QueueFailed
+		}
+		if queue[0].Attempts != attempts || queue[0].State != state || queue[0].Error == "" {
+			t.Errorf("after drain %d the download has %d attempts and is %s, want %d and %s", i, queue[0].Attempts, queue[0].State, attempts, state)
+		}
+	}
+}
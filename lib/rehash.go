@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EnsureMatcher rehashes all books when the matcher or its rules changed since the last rehash
+func EnsureMatcher(s Store) (changed int, err error) {
+	var stored string
+	err = s.Meta("matcher", &stored)
+	if err != nil && err != ErrNotFound {
+		return 0, err
+	}
+	if stored == "" {
+		// databases from before the matcher was versioned were hashed like the default rules do
+		stored = defaultRules.fingerprint
+	}
+	if stored == MatcherFingerprint() {
+		if err == ErrNotFound {
+			return 0, s.SetMeta("matcher", stored)
+		}
+		return 0, nil
+	}
+	changed, err = Rehash(s)
+	if err != nil {
+		return changed, err
+	}
+	return changed, s.SetMeta("matcher", MatcherFingerprint())
+}
+
+// asCalibreBook recreates the calibre metadata a book or catalog entry was created from
+func asCalibreBook(author, title, series string, index float64, languages []string) CalibreBook {
+	return CalibreBook{
+		Title:       title,
+		Authors:     []string{author},
+		Series:      series,
+		SeriesIndex: index,
+		Languages:   languages,
+	}
+}
+
+// Rehash recalculates the hashes of all books and catalog entries with the active rules.
+// Books that end up with the same hash are merged into the book that kept its hash, or else
+// into the oldest of them, see mergeBook.
+func Rehash(s Store) (changed int, err error) {
+	err = s.Update(func(tx Store) error {
+		changed, err = rehash(tx)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// rehash does the actual work of Rehash within a transaction
+func rehash(tx Store) (changed int, err error) {
+	books, err := tx.Books()
+	if err != nil {
+		return 0, err
+	}
+	entries, err := tx.CatalogEntries()
+	if err != nil {
+		return 0, err
+	}
+
+	kept := make(map[string]*Book, len(books))
+	var rehashed []Book
+	for i, b := range books {
+		if b.Title == "" {
+			kept[b.Hash] = &books[i]
+			continue
+		}
+		cb := asCalibreBook(b.Author, b.Title, b.Series, b.SeriesIndex, b.Languages)
+		nb := newBook(tx, &cb)
+		if nb.Hash == b.Hash && nb.SeriesKey == b.SeriesKey {
+			kept[b.Hash] = &books[i]
+			continue
+		}
+		err = tx.DeleteBook(b.ID)
+		if err != nil {
+			return 0, err
+		}
+		b.Hash = nb.Hash
+		b.SeriesKey = nb.SeriesKey
+		rehashed = append(rehashed, b)
+	}
+
+	sort.Slice(rehashed, func(i, j int) bool {
+		return rehashed[i].Added.Before(rehashed[j].Added)
+	})
+	//the books that have to be stored, by hash
+	save := make(map[string]bool)
+	for i := range rehashed {
+		b := &rehashed[i]
+		changed++
+		if k, ok := kept[b.Hash]; ok {
+			mergeBook(k, *b)
+			save[b.Hash] = true
+			continue
+		}
+		kept[b.Hash] = b
+		save[b.Hash] = true
+	}
+	for hash := range save {
+		err = tx.SaveBook(kept[hash])
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, e := range entries {
+		cb := asCalibreBook(e.Author, e.Title, e.Series, e.SeriesIndex, e.Languages)
+		nb := newBook(tx, &cb)
+		if nb.Hash == e.Hash && nb.SeriesKey == e.SeriesKey {
+			continue
+		}
+		e.Hash = nb.Hash
+		e.SeriesKey = nb.SeriesKey
+		err = tx.SaveCatalogEntry(&e)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return changed, nil
+}
+
+// mergeBook merges a book that got the same hash as keep into it. The file of the book is
+// kept when keep has none, the identifiers and formats keep doesn't have are added. Every
+// dropped book is logged, with a warning when its file is no longer tracked.
+func mergeBook(keep *Book, drop Book) {
+	if keep.Path == "" && drop.Path != "" {
+		keep.Path = drop.Path
+		keep.FileHash = drop.FileHash
+		keep.Local = drop.Local
+		drop.Path = ""
+	}
+	if keep.UUID == "" {
+		keep.UUID = drop.UUID
+	}
+	for k, v := range drop.Identifiers {
+		if _, ok := keep.Identifiers[k]; ok {
+			continue
+		}
+		if keep.Identifiers == nil {
+			keep.Identifiers = make(map[string]string)
+		}
+		keep.Identifiers[k] = v
+	}
+	for _, f := range drop.Formats {
+		if !containsString(keep.Formats, f) {
+			keep.Formats = append(keep.Formats, f)
+		}
+	}
+	l := log.WithFields(log.Fields{
+		"hash":    keep.Hash,
+		"kept":    keep.ID,
+		"dropped": drop.ID,
+	})
+	if drop.Path != "" && drop.Path != keep.Path {
+		l.WithField("path", drop.Path).Warning("Merged a book with the same hash, its file is no longer tracked")
+		return
+	}
+	l.Info("Merged a book with the same hash")
+}
@@ -0,0 +1,53 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+func TestRehashMergesBooks(t *testing.T) {
+	s := db.NewMemory()
+	added := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)
+	//both books were hashed by an older matcher, they get the same hash now
+	for _, b := range []lib.Book{
+		{Hash: "old-dune", Author: "Frank Herbert", Title: "Dune", Added: added, UUID: "uuid-dune"},
+		{Hash: "old-dune-1965", Author: "Frank Herbert", Title: "Dune (1965)", Added: added.Add(time.Hour),
+			Path: "/books/dune.epub", FileHash: "filehash", Identifiers: map[string]string{"isbn": "9780441013593"}},
+		{Hash: "old-messiah", Author: "Frank Herbert", Title: "Dune Messiah", Added: added, Path: "/books/messiah.epub"},
+		{Hash: "old-messiah-copy", Author: "Frank Herbert", Title: "Dune Messiah (1969)", Added: added.Add(time.Hour), Path: "/books/messiah-copy.epub"},
+	} {
+		b := b
+		if err := s.SaveBook(&b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed, err := lib.Rehash(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 4 {
+		t.Errorf("changed %d books, want 4", changed)
+	}
+	books, _ := s.Books()
+	if len(books) != 2 {
+		t.Fatalf("kept %d books, want 2", len(books))
+	}
+	for _, b := range books {
+		switch b.Title {
+		case "Dune":
+			if b.Path != "/books/dune.epub" || b.FileHash != "filehash" || b.UUID != "uuid-dune" || b.Identifiers["isbn"] == "" {
+				t.Errorf("merged book %+v, want the file and identifiers of the newer book", b)
+			}
+		case "Dune Messiah":
+			if b.Path != "/books/messiah.epub" {
+				t.Errorf("merged book %+v, want the file of the oldest book", b)
+			}
+		default:
+			t.Errorf("kept %+v, want the oldest book", b)
+		}
+	}
+}
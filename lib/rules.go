@@ -0,0 +1,225 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MatcherVersion is the version of the matching code, it must be raised whenever
+// a change to the matcher results in different hashes
+const MatcherVersion = 2
+
+const (
+	// StageClean replacements are applied to the author and title before they are combined
+	StageClean = "clean"
+	// StageHash replacements are applied to the lowercased title while it is being hashed
+	StageHash = "hash"
+)
+
+// Rules holds all normalisation rules, the default set is used for every book and
+// extended with the set for the first language of a book that has one
+type Rules struct {
+	Default   RuleSet            `json:"default"`
+	Languages map[string]RuleSet `json:"languages"`
+}
+
+// RuleSet is a set of normalisation rules
+type RuleSet struct {
+	Replacements  []Replacement `json:"replacements"`
+	StopWords     []string      `json:"stop_words"`
+	StripSubtitle bool          `json:"strip_subtitle"`
+}
+
+// Replacement replaces every match of Pattern with Replace
+type Replacement struct {
+	Name    string `json:"name"`
+	Stage   string `json:"stage"`
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+}
+
+type compiledReplacement struct {
+	Replacement
+	re *regexp.Regexp
+}
+
+type ruleSet struct {
+	replacements  []compiledReplacement
+	stopWords     map[string]bool
+	stripSubtitle bool
+}
+
+type compiledRules struct {
+	fingerprint string
+	def         ruleSet
+	languages   map[string]ruleSet
+}
+
+// defaultRules are the compiled default rules, they hash books the same way demeter did
+// before the rules were configurable
+var defaultRules = mustCompileRules(DefaultRules())
+
+var activeRules = defaultRules
+
+// DefaultRules returns the rules that are used when no rules are configured
+func DefaultRules() Rules {
+	return Rules{
+		Default: RuleSet{
+			Replacements: []Replacement{
+				{Name: "remove year", Stage: StageClean, Pattern: `\((1|2)[0-9]{3}\)`},
+				{Name: "remove druk", Stage: StageClean, Pattern: `(?i)/ druk [0-9]+`},
+				{Name: "remove parentheses", Stage: StageHash, Pattern: `\(.*\)`, Replace: " "},
+				{Name: "remove brackets", Stage: StageHash, Pattern: `\[.*\]`, Replace: " "},
+				{Name: "remove ': a novel'", Stage: StageHash, Pattern: `: a novel`, Replace: " "},
+			},
+		},
+		Languages: map[string]RuleSet{},
+	}
+}
+
+// UnmarshalJSON replaces all rules instead of merging the configured languages into the existing ones
+func (r *Rules) UnmarshalJSON(data []byte) error {
+	type plain Rules
+	var p plain
+	err := json.Unmarshal(data, &p)
+	if err != nil {
+		return err
+	}
+	*r = Rules(p)
+	return nil
+}
+
+// UseRules replaces the normalisation rules that are used for matching
+func UseRules(r Rules) error {
+	c, err := compileRules(r)
+	if err != nil {
+		return err
+	}
+	activeRules = c
+	return nil
+}
+
+// MatcherFingerprint identifies the matcher version together with the active rules,
+// books need to be rehashed whenever it changes
+func MatcherFingerprint() string {
+	return activeRules.fingerprint
+}
+
+func mustCompileRules(r Rules) *compiledRules {
+	c, err := compileRules(r)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func compileRules(r Rules) (*compiledRules, error) {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	c := compiledRules{
+		fingerprint: fmt.Sprintf("%d-%x", MatcherVersion, sha256.Sum256(raw))[:18],
+		languages:   make(map[string]ruleSet),
+	}
+	c.def, err = compileRuleSet(r.Default)
+	if err != nil {
+		return nil, fmt.Errorf("default rules: %w", err)
+	}
+	for lang, rs := range r.Languages {
+		c.languages[strings.ToLower(lang)], err = compileRuleSet(rs)
+		if err != nil {
+			return nil, fmt.Errorf("rules for %s: %w", lang, err)
+		}
+	}
+	return &c, nil
+}
+
+func compileRuleSet(rs RuleSet) (ruleSet, error) {
+	c := ruleSet{
+		stopWords:     make(map[string]bool),
+		stripSubtitle: rs.StripSubtitle,
+	}
+	for _, r := range rs.Replacements {
+		if r.Stage != StageClean && r.Stage != StageHash {
+			return c, fmt.Errorf("replacement %q has unknown stage %q", r.Name, r.Stage)
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return c, fmt.Errorf("replacement %q: %w", r.Name, err)
+		}
+		c.replacements = append(c.replacements, compiledReplacement{
+			Replacement: r,
+			re:          re,
+		})
+	}
+	for _, w := range rs.StopWords {
+		c.stopWords[strings.ToLower(w)] = true
+	}
+	return c, nil
+}
+
+// rulesFor combines the default rules with the rules of the first language that has them
+func rulesFor(languages []string) ruleSet {
+	rs := activeRules.def
+	for _, lang := range languages {
+		l, ok := activeRules.languages[strings.ToLower(lang)]
+		if !ok {
+			continue
+		}
+		combined := ruleSet{
+			replacements:  append(append([]compiledReplacement{}, rs.replacements...), l.replacements...),
+			stopWords:     make(map[string]bool, len(rs.stopWords)+len(l.stopWords)),
+			stripSubtitle: rs.stripSubtitle || l.stripSubtitle,
+		}
+		for w := range rs.stopWords {
+			combined.stopWords[w] = true
+		}
+		for w := range l.stopWords {
+			combined.stopWords[w] = true
+		}
+		return combined
+	}
+	return rs
+}
+
+// replace applies all replacements of a stage and records them in t
+func (rs ruleSet) replace(stage, prefix, s string, t *trace) string {
+	for _, r := range rs.replacements {
+		if r.Stage != stage {
+			continue
+		}
+		s = r.re.ReplaceAllString(s, r.Replace)
+		t.add(prefix+": "+r.Name, s)
+	}
+	return s
+}
+
+// removeStopWords removes all stop words from a lowercased string
+func (rs ruleSet) removeStopWords(s string) string {
+	if len(rs.stopWords) == 0 {
+		return s
+	}
+	words := strings.Fields(s)
+	kept := words[:0]
+	for _, w := range words {
+		if !rs.stopWords[w] {
+			kept = append(kept, w)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// removeSubtitle removes everything after the first colon of a title
+func (rs ruleSet) removeSubtitle(title string) string {
+	if !rs.stripSubtitle {
+		return title
+	}
+	if i := strings.Index(title, ":"); i > 0 {
+		return strings.TrimSpace(title[:i])
+	}
+	return title
+}
@@ -0,0 +1,195 @@
+package lib_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// baselineHash is a copy of the matcher from before the rules were configurable
+func baselineHash(author, title string) string {
+	yearRemove := regexp.MustCompile(`\((1|2)[0-9]{3}\)`)
+	drukRemove := regexp.MustCompile(`(?i)/ druk [0-9]+`)
+	fix := func(s string, correctOrder bool) string {
+		if s == "" {
+			return "Unknown"
+		}
+		s = strings.Title(strings.ToLower(s))
+		s = strings.Replace(s, "'S", "'s", -1)
+		if correctOrder && strings.Contains(s, ",") {
+			sParts := strings.Split(s, ",")
+			if len(sParts) == 2 {
+				s = strings.TrimSpace(sParts[1]) + " " + strings.TrimSpace(sParts[0])
+			}
+		}
+		s = yearRemove.ReplaceAllString(s, "")
+		s = drukRemove.ReplaceAllString(s, "")
+		s = strings.Replace(s, ".", " ", -1)
+		s = strings.Replace(s, "  ", " ", -1)
+		s = strings.TrimSpace(s)
+		return strings.Map(func(in rune) rune {
+			switch in {
+			case '“', '‹', '”', '›':
+				return '"'
+			case '_':
+				return ' '
+			case '‘', '’':
+				return '\''
+			}
+			return in
+		}, s)
+	}
+
+	author = strings.ToLower(fix(author, true))
+	author = strings.Replace(author, "-", " ", -1)
+	title = strings.ToLower(fix(title, false))
+	authorParts := strings.Split(author, " ")
+	lastName := authorParts[len(authorParts)-1]
+	title = strings.Replace(title, author, "", -1)
+	title = strings.Replace(title, lastName, "", -1)
+	title = regexp.MustCompile("^ *[0-9]+").ReplaceAllString(title, "")
+	title = lastName + " " + title
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	if s, _, err := transform.String(t, title); err == nil {
+		title = s
+	}
+	title = strings.TrimSpace(title)
+	title = regexp.MustCompile(`\(.*\)`).ReplaceAllString(title, " ")
+	title = regexp.MustCompile(`\[.*\]`).ReplaceAllString(title, " ")
+	title = strings.Replace(title, ": a novel", " ", -1)
+	title = regexp.MustCompile(`^ *(0)([0-9]+) `).ReplaceAllString(title, " $2 ")
+	return regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(title, "")
+}
+
+// useRules activates r for the rest of the test
+func useRules(t *testing.T, r lib.Rules) {
+	t.Helper()
+	err := lib.UseRules(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		lib.UseRules(lib.DefaultRules())
+	})
+}
+
+func TestDefaultRulesMatchBaseline(t *testing.T) {
+	s := db.NewMemory()
+	for _, c := range []struct {
+		author    string
+		title     string
+		languages []string
+	}{
+		{"Frank Herbert", "Dune (1965)", []string{"eng"}},
+		{"Herbert, Frank", "The Children of Dune", []string{"eng"}},
+		{"J.R.R. Tolkien", "The Lord of the Rings [Illustrated]", []string{"eng"}},
+		{"Ian McEwan", "Atonement: A Novel", []string{"eng"}},
+		{"Harry Mulisch", "De aanslag / druk 12", []string{"nld"}},
+		{"Gabriel García Márquez", "Cien años de soledad", []string{"spa"}},
+		{"Jean-Paul Sartre", "La Nausée", []string{"fra"}},
+		{"Isaac Asimov", "01 Foundation", nil},
+		{"", "Beowulf", nil},
+		{"Stephen King", "It", []string{"eng"}},
+	} {
+		e, err := lib.Explain(s, lib.CalibreBook{
+			Authors:   []string{c.author},
+			Title:     c.title,
+			Languages: c.languages,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := baselineHash(c.author, c.title); e.Book.Hash != want {
+			t.Errorf("hash of %s - %s = %s, want %s", c.author, c.title, e.Book.Hash, want)
+		}
+	}
+}
+
+func TestStopWordsAreOptIn(t *testing.T) {
+	s := db.NewMemory()
+	book := lib.CalibreBook{
+		Authors:   []string{"Frank Herbert"},
+		Title:     "The Children of Dune",
+		Languages: []string{"eng"},
+	}
+	e, _ := lib.Explain(s, book)
+	if e.Book.Hash != "herbertthechildrenofdune" {
+		t.Errorf("default hash = %s, want herbertthechildrenofdune", e.Book.Hash)
+	}
+
+	r := lib.DefaultRules()
+	r.Languages["eng"] = lib.RuleSet{StopWords: []string{"the", "of"}}
+	useRules(t, r)
+	e, _ = lib.Explain(s, book)
+	if e.Book.Hash != "herbertchildrendune" {
+		t.Errorf("hash with stop words = %s, want herbertchildrendune", e.Book.Hash)
+	}
+	book.Languages = []string{"nld"}
+	e, _ = lib.Explain(s, book)
+	if e.Book.Hash != "herbertthechildrenofdune" {
+		t.Errorf("hash in another language = %s, want herbertthechildrenofdune", e.Book.Hash)
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	active := lib.MatcherFingerprint()
+	for _, r := range []lib.Replacement{
+		{Name: "stage", Stage: "other", Pattern: "x"},
+		{Name: "pattern", Stage: lib.StageHash, Pattern: "("},
+	} {
+		rules := lib.DefaultRules()
+		rules.Default.Replacements = append(rules.Default.Replacements, r)
+		if err := lib.UseRules(rules); err == nil {
+			t.Errorf("rules with invalid %s were accepted", r.Name)
+		}
+	}
+	if lib.MatcherFingerprint() != active {
+		t.Error("invalid rules replaced the active rules")
+	}
+}
+
+func TestEnsureMatcherRehashesOnChange(t *testing.T) {
+	s := db.NewMemory()
+	b := lib.Book{
+		Hash:      baselineHash("Frank Herbert", "The Children of Dune"),
+		Author:    "Frank Herbert",
+		Title:     "The Children of Dune",
+		Languages: []string{"eng"},
+	}
+	err := s.SaveBook(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := lib.EnsureMatcher(s)
+	if err != nil || changed != 0 {
+		t.Fatalf("first run with default rules: changed %d, err %v", changed, err)
+	}
+	var stored string
+	if err = s.Meta("matcher", &stored); err != nil || stored != lib.MatcherFingerprint() {
+		t.Errorf("stored fingerprint = %q (%v), want %q", stored, err, lib.MatcherFingerprint())
+	}
+	changed, _ = lib.EnsureMatcher(s)
+	if changed != 0 {
+		t.Errorf("unchanged rules rehashed %d books", changed)
+	}
+
+	r := lib.DefaultRules()
+	r.Languages["eng"] = lib.RuleSet{StopWords: []string{"the"}}
+	useRules(t, r)
+	changed, err = lib.EnsureMatcher(s)
+	if err != nil || changed != 1 {
+		t.Fatalf("changed rules: changed %d, err %v, want 1", changed, err)
+	}
+	books, _ := s.Books()
+	if len(books) != 1 || books[0].Hash != "herbertchildrenofdune" {
+		t.Errorf("books after rehash = %v", books)
+	}
+}
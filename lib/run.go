@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Policy holds all settings that decide when and how hosts are scraped
+type Policy struct {
+	Schedule Schedule
+	Adaptive Adaptive
+	History  Retention
+	Health   HealthPolicy
+	Budgets  Budgets
+}
+
+// ScheduleFor returns the schedule of a host: its own schedule, filled from the default
+// schedule and the adapted interval of the host
+func (p Policy) ScheduleFor(h Host) Schedule {
+	own := h.Schedule.Interval != "" || h.Schedule.Cron != ""
+	if p.Adaptive.Enabled && !own && h.AdaptedInterval > 0 {
+		h.Schedule.Interval = h.AdaptedInterval.String()
+	}
+	return h.Schedule.Or(p.Schedule)
+}
+
+// Next returns when a host should be scraped next, a host that is backing off waits at least until its backoff ends
+func (p Policy) Next(h Host) time.Time {
+	return p.nextAt(h, time.Now())
+}
+
+// Due returns true if a host should be scraped at now
+func (p Policy) Due(h Host, now time.Time) bool {
+	return !p.nextAt(h, now).After(now)
+}
+
+// nextAt returns when a host should be scraped next, seen from now. The backoff
+// of a host is moved into the window just like its planned scrape.
+func (p Policy) nextAt(h Host, now time.Time) time.Time {
+	s := p.ScheduleFor(h)
+	next := s.planned(h)
+	if h.BackoffUntil.After(next) {
+		next = h.BackoffUntil
+	}
+	return s.inWindow(next, now)
+}
+
+// ScrapeHost scrapes a host and drains its download queue within its budget, which spends from
+// the allowance of the run, updates its counters, interval and health and stores it together with the result.
+// The returned error is the scrape error, the result is never nil. When the budget of the
+// host runs out the scrape counts as a successful one and ErrBudgetExhausted is returned.
+func (a *App) ScrapeHost(ctx context.Context, h *Host, p Policy, run *Allowance) (*ScrapeResult, error) {
+	log.WithField("host", h.URL).Info("Starting work")
+	budget := p.Budgets.For(*h)
+	hctx, cancel := budget.WithDeadline(ctx)
+	defer cancel()
+	result, err := a.Scrape(hctx, h)
+	if result == nil {
+		now := time.Now()
+		result = &ScrapeResult{
+			Start: now,
+			End:   now,
+		}
+	}
+	if err == nil {
+		err = a.Drain(hctx, h, budget.Start(run), result)
+		result.End = time.Now()
+	}
+	if err != nil && ctx.Err() != nil {
+		a.interrupted(h, result)
+		return result, err
+	}
+	if err != nil && (errors.Is(err, ErrBudgetExhausted) || hctx.Err() != nil) {
+		//the rest is deferred to the next scrape of the host
+		err = ErrBudgetExhausted
+		log.WithFields(log.Fields{
+			"host":        h.URL,
+			"host_budget": budget.String(),
+		}).Info("Budget exhausted, deferring the rest")
+	}
+	result.Success = err == nil || err == ErrBudgetExhausted
+	h.LastRunSuccessful = result.Success
+	if !result.Success {
+		log.WithFields(log.Fields{
+			"host": h.URL,
+			"err":  err,
+		}).Error("Scraping failed")
+	} else {
+		log.WithFields(log.Fields{
+			"host":      h.URL,
+			"downloads": result.Downloads,
+			"duration":  time.Since(result.Start).String(),
+		}).Info("Scraping done")
+	}
+	h.Downloads += result.Downloads
+	h.Scrapes++
+	if result.Downloads > 0 {
+		h.LastDownload = result.End
+	}
+	h.LastScrape = result.End
+	e := p.Health.scraped(h, result.Success, result.End)
+
+	rerr := RecordScrape(a.Store, h, *result, p.History)
+	if rerr == nil && e != nil {
+		logTransition(h, e)
+		rerr = a.Store.AddHostEvent(e)
+	}
+	if rerr != nil {
+		log.WithFields(log.Fields{
+			"host": h.URL,
+			"err":  rerr,
+		}).Error("Could not store scrape result")
+		return result, err
+	}
+	if !h.Active || h.BackoffUntil.After(result.End) {
+		//the queue of the host would wait until it recovers, other hosts with the same books can download them now
+		a.passQueue(h, result.End)
+	}
+	if p.Adaptive.Enabled {
+		p.adapt(a.Store, h)
+		rerr = a.Store.SaveHost(h)
+		if rerr != nil {
+			log.WithFields(log.Fields{
+				"host": h.URL,
+				"err":  rerr,
+			}).Error("Could not store interval")
+		}
+		log.WithFields(log.Fields{
+			"host":     h.URL,
+			"interval": formatInterval(h.AdaptedInterval),
+			"reason":   h.IntervalReason,
+		}).Debug("Adapted interval")
+	}
+	return result, err
+}
+
+// passQueue passes the queued downloads of a host that backs off or was disabled to other hosts
+func (a *App) passQueue(h *Host, now time.Time) {
+	passed, err := PassClaims(a.Store, h.ID, now)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"host": h.URL,
+			"err":  err,
+		}).Error("Could not pass queued downloads to other hosts")
+		return
+	}
+	if passed > 0 {
+		log.WithFields(log.Fields{
+			"host":   h.URL,
+			"passed": passed,
+		}).Info("Passed queued downloads to other hosts")
+	}
+}
+
+// interrupted stores the downloads of a scrape that was cancelled. An interrupted scrape says
+// nothing about the health of the host, so it is not recorded and the host stays due.
+func (a *App) interrupted(h *Host, result *ScrapeResult) {
+	log.WithFields(log.Fields{
+		"host":      h.URL,
+		"downloads": result.Downloads,
+	}).Warning("Scraping interrupted")
+	h.Downloads += result.Downloads
+	if result.Downloads > 0 {
+		h.LastDownload = result.End
+	}
+	err := a.Store.SaveHost(h)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"host": h.URL,
+			"err":  err,
+		}).Error("Could not store host")
+	}
+}
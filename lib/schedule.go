@@ -0,0 +1,316 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when a host is scraped. A host is scraped Interval after its last scrape,
+// or at the next time that matches Cron when it is set. Jitter adds up to that much time to
+// an interval, so hosts that were added together aren't scraped together forever. With a
+// Window like "01:00-06:00" scrapes only start within that time of day.
+type Schedule struct {
+	Interval string `json:"interval,omitempty"`
+	Cron     string `json:"cron,omitempty"`
+	Jitter   string `json:"jitter,omitempty"`
+	Window   string `json:"window,omitempty"`
+}
+
+// DefaultSchedule returns the schedule that is used when none is configured
+func DefaultSchedule() Schedule {
+	return Schedule{
+		Interval: "12h",
+		Jitter:   "1h",
+	}
+}
+
+// IsZero returns true if nothing is set in the schedule
+func (s Schedule) IsZero() bool {
+	return s == Schedule{}
+}
+
+// Or fills the fields that are not set in s from def. An interval or a cron expression
+// replaces both of them, the jitter and window are filled separately.
+func (s Schedule) Or(def Schedule) Schedule {
+	if s.Interval == "" && s.Cron == "" {
+		s.Interval = def.Interval
+		s.Cron = def.Cron
+	}
+	if s.Jitter == "" {
+		s.Jitter = def.Jitter
+	}
+	if s.Window == "" {
+		s.Window = def.Window
+	}
+	return s
+}
+
+// Validate returns an error when a field of the schedule can't be parsed
+func (s Schedule) Validate() error {
+	if s.Interval != "" {
+		d, err := time.ParseDuration(s.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid interval: %w", err)
+		}
+		if d <= 0 {
+			return errors.New("invalid interval: should be positive")
+		}
+	}
+	if s.Jitter != "" {
+		if _, err := time.ParseDuration(s.Jitter); err != nil {
+			return fmt.Errorf("invalid jitter: %w", err)
+		}
+	}
+	if s.Cron != "" {
+		c, err := parseCron(s.Cron)
+		if err != nil {
+			return fmt.Errorf("invalid cron expression: %w", err)
+		}
+		if c.next(time.Now()).IsZero() {
+			return fmt.Errorf("cron expression %q never matches", s.Cron)
+		}
+	}
+	if s.Window != "" {
+		if _, _, err := parseWindow(s.Window); err != nil {
+			return fmt.Errorf("invalid window: %w", err)
+		}
+	}
+	return nil
+}
+
+// String describes the schedule
+func (s Schedule) String() string {
+	var parts []string
+	if s.Cron != "" {
+		parts = append(parts, "cron "+s.Cron)
+	} else if s.Interval != "" {
+		parts = append(parts, "every "+s.Interval)
+	}
+	if s.Jitter != "" && s.Cron == "" {
+		parts = append(parts, "jitter "+s.Jitter)
+	}
+	if s.Window != "" {
+		parts = append(parts, "between "+s.Window)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Next returns when a host should be scraped next. A host that was never scraped
+// is due right away, as far as the window allows it. Invalid fields are ignored.
+func (s Schedule) Next(h Host) time.Time {
+	return s.nextAt(h, time.Now())
+}
+
+// Due returns true if a host should be scraped at now
+func (s Schedule) Due(h Host, now time.Time) bool {
+	return !s.nextAt(h, now).After(now)
+}
+
+// nextAt returns when a host should be scraped next, seen from now
+func (s Schedule) nextAt(h Host, now time.Time) time.Time {
+	return s.inWindow(s.planned(h), now)
+}
+
+// planned returns when a host should be scraped next without looking at the window,
+// the zero time for a host that was never scraped
+func (s Schedule) planned(h Host) time.Time {
+	if h.LastScrape.IsZero() {
+		return time.Time{}
+	}
+	if c, err := parseCron(s.Cron); s.Cron != "" && err == nil {
+		return c.next(h.LastScrape)
+	}
+	interval, _ := time.ParseDuration(s.Interval)
+	return h.LastScrape.Add(interval + s.jitter(h))
+}
+
+// inWindow moves next into the window. A scrape that is overdue can't start before now,
+// so it moves to now when the window is open, or else to the next start of the window.
+func (s Schedule) inWindow(next, now time.Time) time.Time {
+	from, to, err := parseWindow(s.Window)
+	if s.Window == "" || err != nil {
+		return next
+	}
+	if next.Before(now) {
+		next = now
+	}
+	return nextInWindow(next.Local(), from, to)
+}
+
+// jitter returns the extra time a host waits after its interval, it only changes after every scrape
+// so the next scrape that is shown is the one that is used
+func (s Schedule) jitter(h Host) time.Duration {
+	max, err := time.ParseDuration(s.Jitter)
+	if err != nil || max <= 0 {
+		return 0
+	}
+	f := fnv.New64a()
+	fmt.Fprintf(f, "%d-%d", h.ID, h.LastScrape.UnixNano())
+	return time.Duration(f.Sum64() % uint64(max))
+}
+
+// parseWindow reads a time of day window like "01:00-06:00" into minutes since midnight
+func parseWindow(w string) (int, int, error) {
+	parts := strings.Split(w, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("%q should look like 01:00-06:00", w)
+	}
+	var minutes [2]int
+	for i, p := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(p))
+		if err != nil {
+			return 0, 0, fmt.Errorf("%q should look like 01:00-06:00", w)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+	if minutes[0] == minutes[1] {
+		return 0, 0, fmt.Errorf("%q is empty", w)
+	}
+	return minutes[0], minutes[1], nil
+}
+
+// nextInWindow returns t when it is within the window, or else the next start of the window.
+// A window that ends before it starts runs past midnight.
+func nextInWindow(t time.Time, from, to int) time.Time {
+	m := t.Hour()*60 + t.Minute()
+	if from < to && m >= from && m < to {
+		return t
+	}
+	if from > to && (m >= from || m < to) {
+		return t
+	}
+	start := time.Date(t.Year(), t.Month(), t.Day(), from/60, from%60, 0, 0, t.Location())
+	if !start.After(t) {
+		start = start.AddDate(0, 0, 1)
+	}
+	return start
+}
+
+// cronSchedule holds the allowed values of every field of a cron expression
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	// anyDom and anyDow are set for a *, a day matches both fields unless one of them is *
+	anyDom, anyDow bool
+}
+
+// cronFields holds the range of every field of a cron expression
+var cronFields = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+// parseCron reads a cron expression with the fields minute, hour, day of month, month and day of week.
+// Every field can be a *, a number, a range like 1-5, a list like 1,3 and a step like */15.
+func parseCron(expr string) (cronSchedule, error) {
+	var c cronSchedule
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return c, fmt.Errorf("%q should have 5 fields", expr)
+	}
+	var sets [5]map[int]bool
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i][0], cronFields[i][1])
+		if err != nil {
+			return c, err
+		}
+		sets[i] = set
+	}
+	//7 is sunday as well
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	c.minute, c.hour, c.dom, c.month, c.dow = sets[0], sets[1], sets[2], sets[3], sets[4]
+	c.anyDom = fields[2] == "*"
+	c.anyDow = fields[4] == "*"
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := make(map[int]bool)
+	if max == 6 {
+		//allow 7 for sunday
+		max = 7
+	}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in %q", field)
+			}
+			part = part[:i]
+		}
+		from, to := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			from, err1 = strconv.Atoi(bounds[0])
+			to, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid range in %q", field)
+			}
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value in %q", field)
+			}
+			from, to = v, v
+			if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("%q is out of range %d-%d", field, min, max)
+		}
+		for v := from; v <= to; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// matchesDay returns true if a day matches the day of month and day of week fields
+func (c cronSchedule) matchesDay(t time.Time) bool {
+	dom := c.dom[t.Day()]
+	dow := c.dow[int(t.Weekday())]
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first time after t that matches the expression, in local time.
+// The zero time is returned when nothing matches within five years.
+func (c cronSchedule) next(t time.Time) time.Time {
+	t = t.Local().Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		if !c.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
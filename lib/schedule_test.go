@@ -0,0 +1,153 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/lib"
+)
+
+// at returns a local time on 14 March 2024, a thursday
+func at(hour, minute int) time.Time {
+	return time.Date(2024, 3, 14, hour, minute, 0, 0, time.Local)
+}
+
+func TestScheduleInterval(t *testing.T) {
+	s := lib.Schedule{Interval: "12h"}
+	h := lib.Host{ID: 1, LastScrape: at(8, 0)}
+	if got, want := s.Next(h), at(20, 0); !got.Equal(want) {
+		t.Errorf("next %v, want %v", got, want)
+	}
+	if s.Due(h, at(19, 59)) {
+		t.Error("due before the interval passed")
+	}
+	if !s.Due(h, at(20, 0)) {
+		t.Error("not due after the interval passed")
+	}
+	if !s.Due(lib.Host{ID: 2}, at(8, 0)) {
+		t.Error("a host that was never scraped is not due")
+	}
+}
+
+func TestScheduleJitter(t *testing.T) {
+	s := lib.Schedule{Interval: "12h", Jitter: "1h"}
+	h := lib.Host{ID: 1, LastScrape: at(8, 0)}
+	next := s.Next(h)
+	if next.Before(at(20, 0)) || !next.Before(at(21, 0)) {
+		t.Errorf("next %v, want within an hour after %v", next, at(20, 0))
+	}
+	if again := s.Next(h); !again.Equal(next) {
+		t.Errorf("next changed from %v to %v without a scrape", next, again)
+	}
+}
+
+func TestScheduleCron(t *testing.T) {
+	s := lib.Schedule{Cron: "0 3 * * 1", Interval: "1h", Jitter: "1h"}
+	h := lib.Host{ID: 1, LastScrape: at(10, 0)}
+	want := time.Date(2024, 3, 18, 3, 0, 0, 0, time.Local)
+	if got := s.Next(h); !got.Equal(want) {
+		t.Errorf("next %v, want monday %v", got, want)
+	}
+
+	s = lib.Schedule{Cron: "*/15 9-17 * * *"}
+	h.LastScrape = at(9, 5)
+	if got := s.Next(h); !got.Equal(at(9, 15)) {
+		t.Errorf("next %v, want %v", got, at(9, 15))
+	}
+	h.LastScrape = at(17, 45)
+	want = time.Date(2024, 3, 15, 9, 0, 0, 0, time.Local)
+	if got := s.Next(h); !got.Equal(want) {
+		t.Errorf("next %v, want %v", got, want)
+	}
+}
+
+func TestScheduleWindow(t *testing.T) {
+	s := lib.Schedule{Interval: "24h", Window: "01:00-06:00"}
+	//the host is due at 05:30, within the window
+	h := lib.Host{ID: 1, LastScrape: at(5, 30).AddDate(0, 0, -1)}
+	if !s.Due(h, at(5, 45)) {
+		t.Error("not due within the window")
+	}
+	if s.Due(h, at(10, 0)) {
+		t.Error("due after the window closed")
+	}
+	if !s.Due(h, at(1, 0).AddDate(0, 0, 1)) {
+		t.Error("not due when the window opens again")
+	}
+
+	//a host that was never scraped waits for the window
+	if s.Due(lib.Host{ID: 2}, at(10, 0)) {
+		t.Error("a new host is due outside the window")
+	}
+	if !s.Due(lib.Host{ID: 2}, at(2, 0)) {
+		t.Error("a new host is not due within the window")
+	}
+
+	//a window can run past midnight
+	s.Window = "22:00-02:00"
+	for _, now := range []time.Time{at(23, 0), at(1, 59)} {
+		if !s.Due(lib.Host{ID: 2}, now) {
+			t.Errorf("not due at %v", now)
+		}
+	}
+	if s.Due(lib.Host{ID: 2}, at(12, 0)) {
+		t.Error("due outside a window that runs past midnight")
+	}
+}
+
+func TestPolicyBackoffInWindow(t *testing.T) {
+	p := lib.Policy{Schedule: lib.Schedule{Interval: "1h", Window: "01:00-06:00"}}
+	h := lib.Host{ID: 1, LastScrape: at(1, 0), BackoffUntil: at(5, 0)}
+	if p.Due(h, at(4, 0)) {
+		t.Error("due while backing off")
+	}
+	if !p.Due(h, at(5, 0)) {
+		t.Error("not due when the backoff ended within the window")
+	}
+	h.BackoffUntil = at(10, 0)
+	if p.Due(h, at(10, 0)) {
+		t.Error("due when the backoff ended outside the window")
+	}
+	if !p.Due(h, at(1, 0).AddDate(0, 0, 1)) {
+		t.Error("not due when the window opens after the backoff")
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	for _, s := range []lib.Schedule{
+		{Interval: "soon"},
+		{Interval: "-1h"},
+		{Jitter: "a bit"},
+		{Cron: "0 3 * *"},
+		{Cron: "61 * * * *"},
+		{Cron: "0 0 31 2 *"},
+		{Window: "01:00"},
+		{Window: "01:00-01:00"},
+		{Window: "25:00-06:00"},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("%+v is valid, want an error", s)
+		}
+	}
+	for _, s := range []lib.Schedule{
+		lib.DefaultSchedule(),
+		{Cron: "0 3 * * 7", Window: "22:00-02:00"},
+		{Cron: "1,31 */2 1-15 * 1-5"},
+	} {
+		if err := s.Validate(); err != nil {
+			t.Errorf("%+v: %v", s, err)
+		}
+	}
+}
+
+func TestScheduleOr(t *testing.T) {
+	def := lib.Schedule{Interval: "12h", Jitter: "1h", Window: "01:00-06:00"}
+	got := lib.Schedule{Cron: "0 3 * * *"}.Or(def)
+	want := lib.Schedule{Cron: "0 3 * * *", Jitter: "1h", Window: "01:00-06:00"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := (lib.Schedule{}).Or(def); got != def {
+		t.Errorf("got %+v, want the default %+v", got, def)
+	}
+}
@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SeriesStatus describes which volumes of a series are present in the database
+type SeriesStatus struct {
+	Key     string
+	Name    string
+	Author  string
+	Owned   []float64
+	Missing []MissingVolume
+}
+
+// MissingVolume is a volume of a series that is not in the database
+type MissingVolume struct {
+	Index float64
+	Title string
+	Hosts []int
+}
+
+// seriesKey creates the key that identifies a series of a specific author
+func seriesKey(author, series string) string {
+	authorParts := strings.Split(strings.ToLower(author), " ")
+	lastName := authorParts[len(authorParts)-1]
+	key := removeAccents(lastName + " " + strings.ToLower(series))
+	return alphaNumeric.ReplaceAllString(key, "")
+}
+
+// FormatSeriesIndex formats a series index without trailing zeroes
+func FormatSeriesIndex(index float64) string {
+	return strconv.FormatFloat(index, 'f', -1, 64)
+}
+
+func newCatalogEntry(hostID, calibreID int, book Book) CatalogEntry {
+	return CatalogEntry{
+		ID:          fmt.Sprintf("%d_%d", hostID, calibreID),
+		HostID:      hostID,
+		CalibreID:   calibreID,
+		Hash:        book.Hash,
+		Author:      book.Author,
+		Title:       book.Title,
+		Languages:   book.Languages,
+		Series:      book.Series,
+		SeriesIndex: book.SeriesIndex,
+		SeriesKey:   book.SeriesKey,
+		Seen:        time.Now(),
+	}
+}
+
+// saveCatalog stores a batch of books in the catalog snapshot of a host
+func saveCatalog(s Store, entries []CatalogEntry) error {
+	return s.Update(func(tx Store) error {
+		for i := range entries {
+			err := tx.SaveCatalogEntry(&entries[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// pruneCatalog removes all books from the catalog snapshot of a host that are no longer offered by it
+func pruneCatalog(s Store, hostID int, ids []int) error {
+	current := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		current[id] = true
+	}
+	return s.Update(func(tx Store) error {
+		entries, err := tx.Catalog(hostID)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if current[e.CalibreID] {
+				continue
+			}
+			err = tx.DeleteCatalogEntry(e.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SeriesReport lists every series that has at least one volume in the database,
+// together with the volumes that are missing and the hosts that offer them
+func SeriesReport(st Store) ([]SeriesStatus, error) {
+	books, err := st.Books()
+	if err != nil {
+		return nil, err
+	}
+	series := make(map[string]*SeriesStatus)
+	for _, b := range books {
+		if b.SeriesKey == "" {
+			continue
+		}
+		s, ok := series[b.SeriesKey]
+		if !ok {
+			s = &SeriesStatus{
+				Key:    b.SeriesKey,
+				Name:   b.Series,
+				Author: b.Author,
+			}
+			series[b.SeriesKey] = s
+		}
+		s.Owned = append(s.Owned, b.SeriesIndex)
+	}
+
+	report := make([]SeriesStatus, 0, len(series))
+	for _, s := range series {
+		entries, _ := st.CatalogInSeries(s.Key)
+		s.Missing = missingVolumes(s.Owned, entries)
+		sort.Float64s(s.Owned)
+		report = append(report, *s)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Author == report[j].Author {
+			return report[i].Name < report[j].Name
+		}
+		return report[i].Author < report[j].Author
+	})
+	return report, nil
+}
+
+// missingVolumes returns every volume up to the highest known index that is not owned
+func missingVolumes(owned []float64, entries []CatalogEntry) []MissingVolume {
+	have := make(map[float64]bool, len(owned))
+	highest := 0.0
+	for _, i := range owned {
+		have[i] = true
+		highest = math.Max(highest, i)
+	}
+	missing := make(map[float64]*MissingVolume)
+	for _, e := range entries {
+		if have[e.SeriesIndex] {
+			continue
+		}
+		m, ok := missing[e.SeriesIndex]
+		if !ok {
+			m = &MissingVolume{
+				Index: e.SeriesIndex,
+				Title: e.Title,
+			}
+			missing[e.SeriesIndex] = m
+		}
+		m.Hosts = append(m.Hosts, e.HostID)
+	}
+	for i := 1.0; i < highest; i++ {
+		if !have[i] && missing[i] == nil {
+			missing[i] = &MissingVolume{
+				Index: i,
+			}
+		}
+	}
+	volumes := make([]MissingVolume, 0, len(missing))
+	for _, m := range missing {
+		volumes = append(volumes, *m)
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Index < volumes[j].Index
+	})
+	return volumes
+}
@@ -0,0 +1,27 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewCatalogEntrySeen(t *testing.T) {
+	before := time.Now()
+	e := newCatalogEntry(3, 42, Book{Hash: "herbertdune"})
+	if e.ID != "3_42" {
+		t.Errorf("id = %s, want 3_42", e.ID)
+	}
+	if e.Seen.Before(before) {
+		t.Errorf("seen = %v, want at least %v", e.Seen, before)
+	}
+}
+
+func TestSeriesKey(t *testing.T) {
+	a := seriesKey("Frank Herbert", "Dune")
+	if b := seriesKey("frank herbert", "DUNE"); a != b {
+		t.Errorf("keys differ by case: %s, %s", a, b)
+	}
+	if b := seriesKey("Brian Herbert", "Dune: House"); a == b {
+		t.Errorf("different series have the same key %s", a)
+	}
+}
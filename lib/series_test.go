@@ -0,0 +1,134 @@
+package lib_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+// seriesBook normalises a volume of a series the way a scrape does
+func seriesBook(t *testing.T, s lib.Store, title, series string, index float64) lib.Book {
+	t.Helper()
+	e, err := lib.Explain(s, lib.CalibreBook{
+		Authors:     []string{"Frank Herbert"},
+		Title:       title,
+		Series:      series,
+		SeriesIndex: index,
+	})
+	if err != nil {
+		t.Fatalf("explain %s: %v", title, err)
+	}
+	return e.Book
+}
+
+func TestSeriesTitleKeepsHash(t *testing.T) {
+	s := db.NewMemory()
+	plain := hashOf(t, s, "Frank Herbert", "Dune 03 - Children of Dune")
+	b := seriesBook(t, s, "Dune 03 - Children of Dune", "Dune", 3)
+	if b.Hash != plain {
+		t.Errorf("hash with series = %s, want %s", b.Hash, plain)
+	}
+	if b.SeriesKey == "" {
+		t.Error("series key is empty")
+	}
+}
+
+func TestSeriesVolumeMatches(t *testing.T) {
+	s := db.NewMemory()
+	owned := seriesBook(t, s, "Children of Dune", "Dune", 3)
+	err := s.SaveBook(&owned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := lib.Explain(s, lib.CalibreBook{
+		Authors:     []string{"Herbert, Frank"},
+		Title:       "Dune 03 - Children of Dune",
+		Series:      "Dune",
+		SeriesIndex: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Matches) != 1 {
+		t.Errorf("matches = %d, want 1", len(e.Matches))
+	}
+
+	e, err = lib.Explain(s, lib.CalibreBook{
+		Authors:     []string{"Frank Herbert"},
+		Title:       "God Emperor of Dune",
+		Series:      "Dune",
+		SeriesIndex: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Matches) != 0 {
+		t.Errorf("another volume matches: %v", e.Matches)
+	}
+}
+
+func TestSeriesReport(t *testing.T) {
+	s := db.NewMemory()
+	for _, v := range []struct {
+		title string
+		index float64
+	}{
+		{"Dune", 1},
+		{"Children of Dune", 3},
+		{"Chapterhouse: Dune", 6},
+	} {
+		b := seriesBook(t, s, v.title, "Dune", v.index)
+		err := s.SaveBook(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := seriesBook(t, s, "Dune", "Dune", 1).SeriesKey
+	for i, v := range []struct {
+		host  int
+		title string
+		index float64
+	}{
+		{1, "Dune Messiah", 2},
+		{2, "Dune Messiah", 2},
+		{2, "Heretics of Dune", 5},
+		{1, "Children of Dune", 3},
+		{1, "Dune 7", 7},
+	} {
+		err := s.SaveCatalogEntry(&lib.CatalogEntry{
+			ID:          string(rune('a' + i)),
+			HostID:      v.host,
+			CalibreID:   i,
+			Title:       v.title,
+			Series:      "Dune",
+			SeriesIndex: v.index,
+			SeriesKey:   key,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := lib.SeriesReport(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 1 {
+		t.Fatalf("series = %d, want 1", len(report))
+	}
+	if want := []float64{1, 3, 6}; !reflect.DeepEqual(report[0].Owned, want) {
+		t.Errorf("owned = %v, want %v", report[0].Owned, want)
+	}
+	want := []lib.MissingVolume{
+		{Index: 2, Title: "Dune Messiah", Hosts: []int{1, 2}},
+		{Index: 4},
+		{Index: 5, Title: "Heretics of Dune", Hosts: []int{2}},
+		{Index: 7, Title: "Dune 7", Hosts: []int{1}},
+	}
+	if !reflect.DeepEqual(report[0].Missing, want) {
+		t.Errorf("missing = %+v, want %+v", report[0].Missing, want)
+	}
+}
@@ -0,0 +1,162 @@
+package lib
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by a Store when a record does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned by a Store when a record with the same unique value already exists
+	ErrExists = errors.New("already exists")
+)
+
+// Migration is a single upgrade of the schema of a Store
+type Migration struct {
+	Version int
+	Name    string
+}
+
+// Store holds all persistent state of demeter
+type Store interface {
+	// Hosts returns all hosts
+	Hosts() ([]Host, error)
+	// ActiveHosts returns all hosts that are active
+	ActiveHosts() ([]Host, error)
+	// Host returns the host with the given ID
+	Host(id int) (Host, error)
+	// HostByURL returns the host with the given url
+	HostByURL(url string) (Host, error)
+	// SaveHost creates a host, or replaces it if it has an ID
+	SaveHost(h *Host) error
+	// DeleteHost removes a host
+	DeleteHost(id int) error
+
+	// Books returns all books
+	Books() ([]Book, error)
+	// BookByHash returns the book with the given hash
+	BookByHash(hash string) (Book, error)
+	// BookByUUID returns the first book with the given calibre uuid
+	BookByUUID(uuid string) (Book, error)
+	// BookByFileHash returns the first book with the given file hash
+	BookByFileHash(fileHash string) (Book, error)
+	// BookByPath returns the first book that is stored in path
+	BookByPath(path string) (Book, error)
+	// BooksInSeries returns all books with the given series key
+	BooksInSeries(key string) ([]Book, error)
+	// SaveBook creates a book, or replaces it if it has an ID
+	SaveBook(b *Book) error
+	// DeleteBook removes a book
+	DeleteBook(id int) error
+
+	// IsChecked returns true if a book ID of a host has been checked before
+	IsChecked(hostID, bookID int) (bool, error)
+	// MarkChecked marks a book ID of a host as checked
+	MarkChecked(hostID, bookID int) error
+	// CheckedIDs returns all checked book IDs of all hosts
+	CheckedIDs() ([]CheckedID, error)
+	// ClearChecked removes all checked book IDs of a host and returns how many were removed
+	ClearChecked(hostID int) (int, error)
+
+	// AddScrapeResult adds a scrape result to the history of a host and to the aggregate of its day.
+	// ErrExists is returned when the host already has a result with the same start time.
+	AddScrapeResult(hostID int, r ScrapeResult) error
+	// ScrapeResults returns the last n scrape results of a host, oldest first. All results are returned if n <= 0.
+	ScrapeResults(hostID, n int) ([]ScrapeResult, error)
+	// ScrapeDays returns the daily aggregates of the scrape results of a host, oldest first
+	ScrapeDays(hostID int) ([]ScrapeDay, error)
+	// PruneScrapeResults removes the scrape results of a host that are not among the last keepRuns
+	// and started before keepSince, the daily aggregates are kept. It returns the number of removed results.
+	PruneScrapeResults(hostID, keepRuns int, keepSince time.Time) (int, error)
+	// ClearScrapeResults removes all scrape results and daily aggregates of a host and returns how many results were removed
+	ClearScrapeResults(hostID int) (int, error)
+
+	// AddHostEvent adds a change of the health of a host to its history
+	AddHostEvent(e *HostEvent) error
+	// HostEvents returns the health changes of a host, oldest first
+	HostEvents(hostID int) ([]HostEvent, error)
+	// DeleteHostEvent removes a health change from the history of its host
+	DeleteHostEvent(id int) error
+
+	// Alias returns the alias with the given key
+	Alias(key string) (Alias, error)
+	// Aliases returns all aliases
+	Aliases() ([]Alias, error)
+	// SaveAlias creates or replaces an alias
+	SaveAlias(a *Alias) error
+	// DeleteAlias removes an alias
+	DeleteAlias(key string) error
+
+	// CatalogEntry returns a single entry of a catalog snapshot
+	CatalogEntry(id string) (CatalogEntry, error)
+	// Catalog returns the catalog snapshot of a host
+	Catalog(hostID int) ([]CatalogEntry, error)
+	// CatalogEntries returns the catalog snapshots of all hosts
+	CatalogEntries() ([]CatalogEntry, error)
+	// CatalogInSeries returns all catalog entries with the given series key
+	CatalogInSeries(key string) ([]CatalogEntry, error)
+	// SaveCatalogEntry creates or replaces a catalog entry
+	SaveCatalogEntry(e *CatalogEntry) error
+	// DeleteCatalogEntry removes a catalog entry
+	DeleteCatalogEntry(id string) error
+
+	// CalibreLibrary returns the import state of a local calibre library
+	CalibreLibrary(path string) (CalibreLibrary, error)
+	// CalibreLibraries returns the import state of all local calibre libraries
+	CalibreLibraries() ([]CalibreLibrary, error)
+	// SaveCalibreLibrary creates or replaces the import state of a local calibre library
+	SaveCalibreLibrary(l *CalibreLibrary) error
+
+	// Checkpoint returns the checkpoint of the scrape of a host
+	Checkpoint(hostID int) (ScrapeCheckpoint, error)
+	// Checkpoints returns the checkpoints of all hosts
+	Checkpoints() ([]ScrapeCheckpoint, error)
+	// SaveCheckpoint creates or replaces the checkpoint of the scrape of a host
+	SaveCheckpoint(cp *ScrapeCheckpoint) error
+	// DeleteCheckpoint removes the checkpoint of the scrape of a host
+	DeleteCheckpoint(hostID int) error
+
+	// QueuedDownload returns the queued download with the given ID
+	QueuedDownload(id int) (QueuedDownload, error)
+	// QueuedDownloadByHash returns the queued download of the book with the given hash
+	QueuedDownloadByHash(hash string) (QueuedDownload, error)
+	// Queue returns all queued downloads, oldest first
+	Queue() ([]QueuedDownload, error)
+	// HostQueue returns the queued downloads of a host, oldest first
+	HostQueue(hostID int) ([]QueuedDownload, error)
+	// SaveQueuedDownload creates a queued download, or replaces it if it has an ID.
+	// ErrExists is returned when another queued download has the same hash.
+	SaveQueuedDownload(d *QueuedDownload) error
+	// DeleteQueuedDownload removes a download from the queue
+	DeleteQueuedDownload(id int) error
+
+	// TrashedBook returns the trashed book with the given trash ID
+	TrashedBook(id int) (TrashedBook, error)
+	// Trash returns all trashed books, oldest first
+	Trash() ([]TrashedBook, error)
+	// SaveTrashedBook creates a trashed book, or replaces it if it has an ID
+	SaveTrashedBook(t *TrashedBook) error
+	// DeleteTrashedBook removes a book from the trash
+	DeleteTrashedBook(id int) error
+
+	// Meta reads a metadata value into v
+	Meta(key string, v interface{}) error
+	// SetMeta stores a metadata value
+	SetMeta(key string, v interface{}) error
+
+	// SchemaVersion returns the version of the schema the stored data is in
+	SchemaVersion() (int, error)
+	// PendingMigrations returns the migrations that have not been applied yet, oldest first
+	PendingMigrations() ([]Migration, error)
+	// Migrate backs up the store and applies all pending migrations, each in its own transaction.
+	// It returns the path of the backup, which is empty when nothing had to be migrated.
+	Migrate() (backup string, applied []Migration, err error)
+
+	// View runs fn in a single read-only transaction
+	View(fn func(Store) error) error
+	// Update runs fn in a single transaction, nothing is stored when fn returns an error
+	Update(fn func(Store) error) error
+	// Close closes the store
+	Close() error
+}
@@ -0,0 +1,301 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TrashedBook is a removed book that can still be restored
+type TrashedBook struct {
+	ID      int `storm:"id,increment"`
+	Book    Book
+	Deleted time.Time
+	// File is the path of the book file in the trash directory, it is empty when the file was not trashed
+	File string
+}
+
+// TrashExpiry decides how long trashed books can be restored, they are kept forever when KeepDays is 0
+type TrashExpiry struct {
+	KeepDays int `json:"keep_days"`
+}
+
+// DefaultTrashExpiry returns the trash expiry that is used when none is configured
+func DefaultTrashExpiry() TrashExpiry {
+	return TrashExpiry{
+		KeepDays: 30,
+	}
+}
+
+// BookFilter selects books, a book has to match every field that is set
+type BookFilter struct {
+	Since   time.Time
+	Until   time.Time
+	HostIDs []int
+	// Author matches every book whose author contains it, ignoring case
+	Author string
+	Hashes []string
+}
+
+// Empty returns true if the filter matches every book
+func (f BookFilter) Empty() bool {
+	return f.Since.IsZero() && f.Until.IsZero() && len(f.HostIDs) == 0 && f.Author == "" && len(f.Hashes) == 0
+}
+
+// Match returns true if the book matches the filter
+func (f BookFilter) Match(b Book) bool {
+	if !f.Since.IsZero() && b.Added.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !b.Added.Before(f.Until) {
+		return false
+	}
+	if len(f.HostIDs) > 0 && !containsInt(f.HostIDs, b.SourceID) {
+		return false
+	}
+	if f.Author != "" && !strings.Contains(strings.ToLower(b.Author), strings.ToLower(f.Author)) {
+		return false
+	}
+	if len(f.Hashes) > 0 && !containsString(f.Hashes, b.Hash) {
+		return false
+	}
+	return true
+}
+
+func containsInt(list []int, v int) bool {
+	for _, i := range list {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// FindBooks returns all books that match the filter
+func FindBooks(s Store, f BookFilter) ([]Book, error) {
+	books, err := s.Books()
+	if err != nil {
+		return nil, err
+	}
+	var found []Book
+	for _, b := range books {
+		if f.Match(b) {
+			found = append(found, b)
+		}
+	}
+	return found, nil
+}
+
+// TrashBooks moves books to the trash in a single transaction. With files set the files of
+// books that were downloaded to outputDir are moved to the trash directory as well, after the
+// transaction has been committed. The files of locally owned books are never moved.
+func TrashBooks(s Store, books []Book, dir, outputDir string, files bool) ([]TrashedBook, error) {
+	now := time.Now()
+	var trashed []TrashedBook
+	err := s.Update(func(tx Store) error {
+		trashed = nil
+		for _, b := range books {
+			t := TrashedBook{
+				Book:    b,
+				Deleted: now,
+			}
+			err := tx.SaveTrashedBook(&t)
+			if err != nil {
+				return err
+			}
+			if files && b.Path != "" && !b.Local && inDir(outputDir, b.Path) {
+				t.File = filepath.Join(dir, fmt.Sprintf("%d-%s", t.ID, filepath.Base(b.Path)))
+				err = tx.SaveTrashedBook(&t)
+				if err != nil {
+					return err
+				}
+			}
+			err = tx.DeleteBook(b.ID)
+			if err != nil {
+				return err
+			}
+			trashed = append(trashed, t)
+		}
+		return nil
+	})
+	if err != nil || !files {
+		return trashed, err
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return trashed, err
+	}
+	for i, t := range trashed {
+		if t.File == "" {
+			continue
+		}
+		err = moveFile(t.Book.Path, t.File)
+		if err == nil {
+			continue
+		}
+		//the record stays in the trash, it just can't bring its file back
+		trashed[i].File = ""
+		serr := s.SaveTrashedBook(&trashed[i])
+		if serr != nil {
+			return trashed, serr
+		}
+		if !os.IsNotExist(err) {
+			return trashed, fmt.Errorf("could not move %s to the trash: %w", t.Book.Path, err)
+		}
+	}
+	return trashed, nil
+}
+
+// inDir returns true if path is inside dir
+func inDir(dir, path string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// RestoreBooks puts trashed books back in the database and moves their files back to where
+// they were. A book is left in the trash when a book with the same hash has been added since.
+func RestoreBooks(s Store, trashed []TrashedBook) ([]TrashedBook, error) {
+	var restored []TrashedBook
+	err := s.Update(func(tx Store) error {
+		restored = nil
+		for _, t := range trashed {
+			if _, err := tx.BookByHash(t.Book.Hash); err == nil {
+				continue
+			}
+			book := t.Book
+			err := tx.SaveBook(&book)
+			if err != nil {
+				return err
+			}
+			err = tx.DeleteTrashedBook(t.ID)
+			if err != nil {
+				return err
+			}
+			restored = append(restored, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range restored {
+		if t.File == "" {
+			continue
+		}
+		if _, err := os.Stat(t.Book.Path); err == nil {
+			return restored, fmt.Errorf("could not restore %s: %w", t.Book.Path, os.ErrExist)
+		}
+		err = os.MkdirAll(filepath.Dir(t.Book.Path), 0755)
+		if err != nil {
+			return restored, err
+		}
+		err = moveFile(t.File, t.Book.Path)
+		if err != nil {
+			return restored, fmt.Errorf("could not restore %s: %w", t.Book.Path, err)
+		}
+	}
+	return restored, nil
+}
+
+// LastTrashed returns the books that were trashed together most recently
+func LastTrashed(s Store) ([]TrashedBook, error) {
+	trash, err := s.Trash()
+	if err != nil || len(trash) == 0 {
+		return nil, err
+	}
+	last := trash[len(trash)-1].Deleted
+	var batch []TrashedBook
+	for _, t := range trash {
+		if t.Deleted.Equal(last) {
+			batch = append(batch, t)
+		}
+	}
+	return batch, nil
+}
+
+// ExpireTrash permanently removes the books that were trashed before the given time, together with their trashed files
+func ExpireTrash(s Store, before time.Time) (int, error) {
+	var expired []TrashedBook
+	err := s.Update(func(tx Store) error {
+		trash, err := tx.Trash()
+		if err != nil {
+			return err
+		}
+		for _, t := range trash {
+			if !t.Deleted.Before(before) {
+				continue
+			}
+			err = tx.DeleteTrashedBook(t.ID)
+			if err != nil {
+				return err
+			}
+			expired = append(expired, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range expired {
+		if t.File == "" {
+			continue
+		}
+		err = os.Remove(t.File)
+		if err != nil && !os.IsNotExist(err) {
+			return len(expired), err
+		}
+	}
+	return len(expired), nil
+}
+
+// Since returns the time before which trashed books expire
+func (e TrashExpiry) Since(now time.Time) time.Time {
+	return now.AddDate(0, 0, -e.KeepDays)
+}
+
+// moveFile moves a file, it is copied when it can't be renamed because dst is on another file system
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || os.IsNotExist(err) {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,146 @@
+package lib_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gnur/demeter/db"
+	"github.com/gnur/demeter/lib"
+)
+
+func TestBookFilter(t *testing.T) {
+	now := time.Now()
+	b := lib.Book{Added: now.Add(-time.Hour), SourceID: 2, Author: "Frank Herbert", Hash: "herbertdune"}
+	for _, c := range []struct {
+		f    lib.BookFilter
+		want bool
+	}{
+		{lib.BookFilter{}, true},
+		{lib.BookFilter{Since: now.Add(-2 * time.Hour)}, true},
+		{lib.BookFilter{Since: now}, false},
+		{lib.BookFilter{Until: now}, true},
+		{lib.BookFilter{Until: b.Added}, false},
+		{lib.BookFilter{HostIDs: []int{1, 2}}, true},
+		{lib.BookFilter{HostIDs: []int{1}}, false},
+		{lib.BookFilter{Author: "herb"}, true},
+		{lib.BookFilter{Author: "asimov"}, false},
+		{lib.BookFilter{Hashes: []string{"herbertdune"}, HostIDs: []int{2}}, true},
+		{lib.BookFilter{Hashes: []string{"herbertdune"}, HostIDs: []int{3}}, false},
+	} {
+		if got := c.f.Match(b); got != c.want {
+			t.Errorf("%+v matches %v, want %v", c.f, got, c.want)
+		}
+	}
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	s := db.NewMemory()
+	root := t.TempDir()
+	out := filepath.Join(root, "books")
+	library := filepath.Join(root, "library")
+	trash := filepath.Join(root, "trash")
+	for _, d := range []string{out, library} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	books := []lib.Book{
+		{Hash: "downloaded", Path: writeFile(t, out, "downloaded.epub", "downloaded")},
+		{Hash: "local", Path: writeFile(t, out, "local.epub", "local"), Local: true},
+		{Hash: "calibre", Path: writeFile(t, library, "calibre.epub", "calibre"), Local: true},
+		{Hash: "outside", Path: writeFile(t, library, "outside.epub", "outside")},
+		{Hash: "hashonly"},
+	}
+	for i := range books {
+		if err := s.SaveBook(&books[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trashed, err := lib.TrashBooks(s, books, trash, out, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trashed) != len(books) {
+		t.Fatalf("trashed %d books, want %d", len(trashed), len(books))
+	}
+	if left, _ := s.Books(); len(left) != 0 {
+		t.Errorf("books left = %+v", left)
+	}
+	for _, tb := range trashed {
+		if tb.Book.Path == "" {
+			continue
+		}
+		_, err := os.Stat(tb.Book.Path)
+		moved := os.IsNotExist(err)
+		if want := tb.Book.Hash == "downloaded"; moved != want || (tb.File != "") != want {
+			t.Errorf("%s: file moved %v, trash file %q", tb.Book.Hash, moved, tb.File)
+		}
+	}
+
+	last, _ := lib.LastTrashed(s)
+	restored, err := lib.RestoreBooks(s, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != len(books) {
+		t.Errorf("restored %d books, want %d", len(restored), len(books))
+	}
+	if _, err = os.Stat(books[0].Path); err != nil {
+		t.Errorf("file was not restored: %v", err)
+	}
+	if trash, _ := s.Trash(); len(trash) != 0 {
+		t.Errorf("trash after restore = %+v", trash)
+	}
+}
+
+func TestRestoreSkipsReplacedBooks(t *testing.T) {
+	s := db.NewMemory()
+	b := lib.Book{Hash: "herbertdune"}
+	if err := s.SaveBook(&b); err != nil {
+		t.Fatal(err)
+	}
+	trashed, err := lib.TrashBooks(s, []lib.Book{b}, t.TempDir(), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := lib.Book{Hash: "herbertdune"}
+	if err = s.SaveBook(&again); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := lib.RestoreBooks(s, trashed)
+	if err != nil || len(restored) != 0 {
+		t.Errorf("restored %+v, %v, want nothing", restored, err)
+	}
+	if trash, _ := s.Trash(); len(trash) != 1 {
+		t.Errorf("trash = %+v, want the book to stay", trash)
+	}
+}
+
+func TestExpireTrash(t *testing.T) {
+	s := db.NewMemory()
+	out := t.TempDir()
+	trashDir := t.TempDir()
+	b := lib.Book{Hash: "herbertdune", Path: writeFile(t, out, "herbertdune.epub", "dune")}
+	if err := s.SaveBook(&b); err != nil {
+		t.Fatal(err)
+	}
+	trashed, err := lib.TrashBooks(s, []lib.Book{b}, trashDir, out, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := lib.ExpireTrash(s, lib.TrashExpiry{KeepDays: 1}.Since(time.Now()))
+	if err != nil || n != 0 {
+		t.Errorf("expired %d, %v, want nothing", n, err)
+	}
+	n, err = lib.ExpireTrash(s, time.Now().Add(time.Second))
+	if err != nil || n != 1 {
+		t.Errorf("expired %d, %v, want 1", n, err)
+	}
+	if _, err = os.Stat(trashed[0].File); !os.IsNotExist(err) {
+		t.Errorf("trashed file was kept: %v", err)
+	}
+}